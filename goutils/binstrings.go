@@ -0,0 +1,215 @@
+package goutils
+
+// strings(1) style extraction of printable runs from binary content
+// ASCII, UTF-8 and UTF-16LE/BE runs with a byte offset for each string
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Struct of a printable run found in binary content
+type BinaryString struct {
+	Offset   int    `json:"offset"`
+	Encoding string `json:"encoding"`
+	Value    string `json:"value"`
+}
+
+// Encodings of binary string runs
+const (
+	StringsAscii   = "ascii"
+	StringsUtf8    = "utf8"
+	StringsUtf16le = "utf16le"
+	StringsUtf16be = "utf16be"
+)
+
+// Struct of binary string extraction options
+type StringsOptions struct {
+	// Minimum run length in characters, 4 when unset
+	MinLength int
+	// Encodings to scan for, all encodings when empty
+	Encodings []string
+	// Keep runs made only of whitespace
+	KeepWhitespace bool
+}
+
+// Default minimum run length, the same as strings(1)
+const defaultStringsMinLength = 4
+
+// Set the options used when extracting strings from binary content
+func (file *File) SetStringsOptions(options StringsOptions) {
+	file.fileStringsOptions = options
+}
+
+// Return printable runs found in binary content with their offsets
+func (file *File) GetBinaryStrings() []BinaryString {
+	return file.fileBinaryStrings
+}
+
+// Extract printable runs from binary content, the fallback parser for
+// file types without a dedicated parser
+func extractBinaryStrings(file *File) ([]string, error) {
+	file.fileBinaryStrings = ExtractBinaryStrings(file.fileBytes, file.fileStringsOptions)
+	var binaryStrings []string
+	for _, binaryString := range file.fileBinaryStrings {
+		binaryStrings = append(binaryStrings, binaryString.Value)
+	}
+	return binaryStrings, nil
+}
+
+// Extract printable ASCII, UTF-8 and UTF-16 runs from bytes, ordered by offset
+func ExtractBinaryStrings(data []byte, options StringsOptions) []BinaryString {
+	if options.MinLength <= 0 {
+		options.MinLength = defaultStringsMinLength
+	}
+	encodings := map[string]bool{}
+	for _, encoding := range options.Encodings {
+		encodings[encoding] = true
+	}
+	if len(encodings) == 0 {
+		encodings = map[string]bool{StringsAscii: true, StringsUtf8: true, StringsUtf16le: true, StringsUtf16be: true}
+	}
+	var binaryStrings []BinaryString
+	if encodings[StringsAscii] || encodings[StringsUtf8] {
+		binaryStrings = append(binaryStrings, utf8Runs(data, encodings[StringsUtf8], options)...)
+	}
+	var leRuns, beRuns []BinaryString
+	if encodings[StringsUtf16le] {
+		leRuns = utf16Runs(data, false, options)
+	}
+	if encodings[StringsUtf16be] {
+		beRuns = utf16Runs(data, true, options)
+	}
+	binaryStrings = append(binaryStrings, resolveUtf16Runs(leRuns, beRuns)...)
+	sort.SliceStable(binaryStrings, func(i, j int) bool {
+		return binaryStrings[i].Offset < binaryStrings[j].Offset
+	})
+	return binaryStrings
+}
+
+// Printable characters of a run, tab is included as in strings(1)
+func printableAscii(b byte) bool {
+	return b == '\t' || (b >= 0x20 && b < 0x7f)
+}
+
+// Find ASCII runs, and multi-byte UTF-8 runs when allowUtf8 is set
+func utf8Runs(data []byte, allowUtf8 bool, options StringsOptions) []BinaryString {
+	var runs []BinaryString
+	start, length, multiByte := -1, 0, false
+	flush := func(end int) {
+		if start >= 0 && length >= options.MinLength {
+			value := string(data[start:end])
+			if options.KeepWhitespace || len(strings.TrimSpace(value)) > 0 {
+				encoding := StringsAscii
+				if multiByte {
+					encoding = StringsUtf8
+				}
+				runs = append(runs, BinaryString{Offset: start, Encoding: encoding, Value: value})
+			}
+		}
+		start, length, multiByte = -1, 0, false
+	}
+	for i := 0; i < len(data); {
+		size := 1
+		printable := printableAscii(data[i])
+		if !printable && allowUtf8 && data[i] >= utf8.RuneSelf {
+			r, runeSize := utf8.DecodeRune(data[i:])
+			if r != utf8.RuneError && unicode.IsPrint(r) {
+				printable, size = true, runeSize
+			}
+		}
+		if !printable {
+			flush(i)
+			i++
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		if size > 1 {
+			multiByte = true
+		}
+		length++
+		i += size
+	}
+	flush(len(data))
+	return runs
+}
+
+// Find UTF-16 runs of 7 bit characters at both byte alignments
+func utf16Runs(data []byte, bigEndian bool, options StringsOptions) []BinaryString {
+	encoding := StringsUtf16le
+	if bigEndian {
+		encoding = StringsUtf16be
+	}
+	var runs []BinaryString
+	for alignment := 0; alignment < 2; alignment++ {
+		start := -1
+		var value []byte
+		flush := func() {
+			if start >= 0 && len(value) >= options.MinLength {
+				if options.KeepWhitespace || len(strings.TrimSpace(string(value))) > 0 {
+					runs = append(runs, BinaryString{Offset: start, Encoding: encoding, Value: string(value)})
+				}
+			}
+			start, value = -1, nil
+		}
+		for i := alignment; i+1 < len(data); i += 2 {
+			char, high := data[i], data[i+1]
+			if bigEndian {
+				char, high = data[i+1], data[i]
+			}
+			if high != 0 || !printableAscii(char) {
+				flush()
+				continue
+			}
+			if start < 0 {
+				start = i
+			}
+			value = append(value, char)
+		}
+		flush()
+	}
+	// Runs at the two alignments never share bytes, sorting them keeps the
+	// list disjoint
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Offset < runs[j].Offset })
+	return runs
+}
+
+// A UTF-16 run also matches one byte off in the other byte order, of two
+// overlapping runs keep the longer one and the little endian one on a tie
+func resolveUtf16Runs(leRuns []BinaryString, beRuns []BinaryString) []BinaryString {
+	var runs []BinaryString
+	for i, longest := range longestOverlaps(leRuns, beRuns) {
+		if longest <= len(leRuns[i].Value) {
+			runs = append(runs, leRuns[i])
+		}
+	}
+	for i, longest := range longestOverlaps(beRuns, leRuns) {
+		if longest < len(beRuns[i].Value) {
+			runs = append(runs, beRuns[i])
+		}
+	}
+	return runs
+}
+
+// Return for each UTF-16 run the length of the longest other run overlapping
+// its bytes, both lists are sorted by offset and hold disjoint runs
+func longestOverlaps(runs []BinaryString, others []BinaryString) []int {
+	longest := make([]int, len(runs))
+	first := 0
+	for i, run := range runs {
+		end := run.Offset + 2*len(run.Value)
+		for first < len(others) && others[first].Offset+2*len(others[first].Value) <= run.Offset {
+			first++
+		}
+		for j := first; j < len(others) && others[j].Offset < end; j++ {
+			if len(others[j].Value) > longest[i] {
+				longest[i] = len(others[j].Value)
+			}
+		}
+	}
+	return longest
+}
@@ -0,0 +1,422 @@
+package goutils
+
+// OLE2 compound file (MS-CFB) reader
+// Storages and streams of Outlook .msg files and other compound documents,
+// and a writer that copies a storage out as a compound file of its own
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// Directory entry types
+const (
+	cfbStorage = 1
+	cfbStream  = 2
+	cfbRoot    = 5
+)
+
+// Special sector numbers
+const (
+	cfbFreeSector   = 0xffffffff
+	cfbEndOfChain   = 0xfffffffe
+	cfbFatSector    = 0xfffffffd
+	cfbDifatSector  = 0xfffffffc
+	cfbMaxSector    = 0xfffffffa
+	cfbNoStream     = 0xffffffff
+	cfbHeaderDifats = 109
+)
+
+// Streams smaller than the cutoff live in the mini stream
+const cfbMiniStreamCutoff = 4096
+
+// Struct of a compound file directory entry
+type cfbEntry struct {
+	name      string
+	entryType byte
+	left      uint32
+	right     uint32
+	child     uint32
+	start     uint32
+	size      uint64
+}
+
+// Reader of a compound file held in memory
+type cfbReader struct {
+	data           []byte
+	sectorSize     int
+	miniSectorSize int
+	miniCutoff     uint64
+	fat            []uint32
+	miniFat        []uint32
+	entries        []cfbEntry
+	miniStream     []byte
+}
+
+// Parse the header, allocation tables and directory of a compound file
+func newCfbReader(data []byte) (*cfbReader, error) {
+	if len(data) < 512 || !bytes.HasPrefix(data, cfbSignature) {
+		return nil, errors.New("not a compound file")
+	}
+	sectorShift := binary.LittleEndian.Uint16(data[30:])
+	miniSectorShift := binary.LittleEndian.Uint16(data[32:])
+	if sectorShift != 9 && sectorShift != 12 || miniSectorShift != 6 {
+		return nil, fmt.Errorf("invalid sector shift %d/%d", sectorShift, miniSectorShift)
+	}
+	reader := &cfbReader{
+		data:           data,
+		sectorSize:     1 << sectorShift,
+		miniSectorSize: 1 << miniSectorShift,
+		miniCutoff:     uint64(binary.LittleEndian.Uint32(data[56:])),
+	}
+	// Sectors holding the FAT are listed in the header and the DIFAT chain
+	var fatSectors []uint32
+	for i := 0; i < cfbHeaderDifats; i++ {
+		fatSectors = append(fatSectors, binary.LittleEndian.Uint32(data[76+4*i:]))
+	}
+	difatSector := binary.LittleEndian.Uint32(data[68:])
+	for visited := 0; difatSector <= cfbMaxSector && visited < len(data)/reader.sectorSize; visited++ {
+		sector, err := reader.sector(difatSector)
+		if err != nil {
+			return nil, err
+		}
+		entries := reader.sectorSize/4 - 1
+		for i := 0; i < entries; i++ {
+			fatSectors = append(fatSectors, binary.LittleEndian.Uint32(sector[4*i:]))
+		}
+		difatSector = binary.LittleEndian.Uint32(sector[4*entries:])
+	}
+	for _, fatSector := range fatSectors {
+		if fatSector > cfbMaxSector {
+			continue
+		}
+		sector, err := reader.sector(fatSector)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < reader.sectorSize; i += 4 {
+			reader.fat = append(reader.fat, binary.LittleEndian.Uint32(sector[i:]))
+		}
+	}
+	directory, err := reader.chain(binary.LittleEndian.Uint32(data[48:]), reader.fat, reader.sector)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+128 <= len(directory); i += 128 {
+		entry := directory[i : i+128]
+		nameLength := int(binary.LittleEndian.Uint16(entry[64:]))
+		if nameLength > 64 {
+			nameLength = 64
+		}
+		units := make([]uint16, 0, 32)
+		for j := 0; j+1 < nameLength; j += 2 {
+			if unit := binary.LittleEndian.Uint16(entry[j:]); unit != 0 {
+				units = append(units, unit)
+			}
+		}
+		reader.entries = append(reader.entries, cfbEntry{
+			name:      string(utf16.Decode(units)),
+			entryType: entry[66],
+			left:      binary.LittleEndian.Uint32(entry[68:]),
+			right:     binary.LittleEndian.Uint32(entry[72:]),
+			child:     binary.LittleEndian.Uint32(entry[76:]),
+			start:     binary.LittleEndian.Uint32(entry[116:]),
+			size:      binary.LittleEndian.Uint64(entry[120:]),
+		})
+	}
+	if len(reader.entries) == 0 || reader.entries[0].entryType != cfbRoot {
+		return nil, errors.New("missing root directory entry")
+	}
+	// Version 3 files may leave garbage in the high half of the size
+	if reader.sectorSize == 512 {
+		for i := range reader.entries {
+			reader.entries[i].size &= 0xffffffff
+		}
+	}
+	miniFat, err := reader.chain(binary.LittleEndian.Uint32(data[60:]), reader.fat, reader.sector)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+4 <= len(miniFat); i += 4 {
+		reader.miniFat = append(reader.miniFat, binary.LittleEndian.Uint32(miniFat[i:]))
+	}
+	reader.miniStream, err = reader.chain(reader.entries[0].start, reader.fat, reader.sector)
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// Return the bytes of a regular sector
+func (reader *cfbReader) sector(sector uint32) ([]byte, error) {
+	offset := (int(sector) + 1) * reader.sectorSize
+	if sector > cfbMaxSector || offset+reader.sectorSize > len(reader.data) {
+		return nil, fmt.Errorf("sector %d beyond the end of the file", sector)
+	}
+	return reader.data[offset : offset+reader.sectorSize], nil
+}
+
+// Return the bytes of a mini sector
+func (reader *cfbReader) miniSector(sector uint32) ([]byte, error) {
+	offset := int(sector) * reader.miniSectorSize
+	if offset+reader.miniSectorSize > len(reader.miniStream) {
+		return nil, fmt.Errorf("mini sector %d beyond the end of the mini stream", sector)
+	}
+	return reader.miniStream[offset : offset+reader.miniSectorSize], nil
+}
+
+// Follow a sector chain through an allocation table and concatenate it
+func (reader *cfbReader) chain(start uint32, table []uint32, read func(uint32) ([]byte, error)) ([]byte, error) {
+	var chain []byte
+	for sector, visited := start, 0; sector != cfbEndOfChain && sector != cfbFreeSector; visited++ {
+		if int(sector) >= len(table) || visited > len(table) {
+			return chain, fmt.Errorf("broken sector chain at %d", sector)
+		}
+		data, err := read(sector)
+		if err != nil {
+			return chain, err
+		}
+		chain = append(chain, data...)
+		sector = table[sector]
+	}
+	return chain, nil
+}
+
+// Return the content of a stream entry
+func (reader *cfbReader) stream(index int) ([]byte, error) {
+	entry := reader.entries[index]
+	if entry.entryType != cfbStream {
+		return nil, fmt.Errorf("entry %s is not a stream", entry.name)
+	}
+	var data []byte
+	var err error
+	if entry.size < reader.miniCutoff {
+		data, err = reader.chain(entry.start, reader.miniFat, reader.miniSector)
+	} else {
+		data, err = reader.chain(entry.start, reader.fat, reader.sector)
+	}
+	if uint64(len(data)) > entry.size {
+		data = data[:entry.size]
+	}
+	return data, err
+}
+
+// Return the entry indexes of the children of a storage
+func (reader *cfbReader) children(index int) []int {
+	var children []int
+	visited := map[uint32]bool{}
+	var walk func(id uint32)
+	walk = func(id uint32) {
+		if id == cfbNoStream || int(id) >= len(reader.entries) || visited[id] {
+			return
+		}
+		visited[id] = true
+		walk(reader.entries[id].left)
+		children = append(children, int(id))
+		walk(reader.entries[id].right)
+	}
+	walk(reader.entries[index].child)
+	return children
+}
+
+// Find a child of a storage by case-insensitive name
+func (reader *cfbReader) find(parent int, name string) (int, bool) {
+	for _, child := range reader.children(parent) {
+		if strings.EqualFold(reader.entries[child].name, name) {
+			return child, true
+		}
+	}
+	return 0, false
+}
+
+// Copy a storage and everything under it into a new compound file
+// The storage becomes the root entry, transform is applied to each stream
+func (reader *cfbReader) extractStorage(storage int, transform func(path string, data []byte) []byte) ([]byte, error) {
+	writer := &cfbWriter{}
+	root := writer.addEntry("Root Entry", cfbRoot)
+	var copyStorage func(from int, to int, path string) error
+	copyStorage = func(from int, to int, path string) error {
+		for _, child := range reader.children(from) {
+			entry := reader.entries[child]
+			switch entry.entryType {
+			case cfbStorage:
+				index := writer.addEntry(entry.name, cfbStorage)
+				writer.addChild(to, index)
+				if err := copyStorage(child, index, path+entry.name+"/"); err != nil {
+					return err
+				}
+			case cfbStream:
+				data, err := reader.stream(child)
+				if err != nil {
+					return err
+				}
+				if transform != nil {
+					data = transform(path+entry.name, data)
+				}
+				index := writer.addEntry(entry.name, cfbStream)
+				writer.entries[index].data = data
+				writer.addChild(to, index)
+			}
+		}
+		return nil
+	}
+	if err := copyStorage(storage, root, ""); err != nil {
+		return nil, err
+	}
+	return writer.bytes()
+}
+
+// Entry of a compound file being written
+type cfbWriterEntry struct {
+	name      string
+	entryType byte
+	right     uint32
+	child     uint32
+	start     uint32
+	data      []byte
+}
+
+// Writer of a version 3 compound file with 512 byte sectors
+type cfbWriter struct {
+	entries []cfbWriterEntry
+}
+
+// Add a directory entry and return its index
+func (writer *cfbWriter) addEntry(name string, entryType byte) int {
+	writer.entries = append(writer.entries, cfbWriterEntry{name: name, entryType: entryType, right: cfbNoStream, child: cfbNoStream, start: cfbEndOfChain})
+	return len(writer.entries) - 1
+}
+
+// Link a child into a storage, siblings form a right-leaning chain
+func (writer *cfbWriter) addChild(parent int, child int) {
+	writer.entries[child].right = writer.entries[parent].child
+	writer.entries[parent].child = uint32(child)
+}
+
+// Lay out streams, allocation tables and the directory
+func (writer *cfbWriter) bytes() ([]byte, error) {
+	const sectorSize, miniSectorSize = 512, 64
+	var sectors []byte
+	var fat []uint32
+	// Append data as a chain of regular sectors and return its first sector
+	allocate := func(data []byte) uint32 {
+		if len(data) == 0 {
+			return cfbEndOfChain
+		}
+		start := uint32(len(fat))
+		count := (len(data) + sectorSize - 1) / sectorSize
+		for i := 0; i < count; i++ {
+			next := uint32(len(fat) + 1)
+			if i == count-1 {
+				next = cfbEndOfChain
+			}
+			fat = append(fat, next)
+		}
+		padded := make([]byte, count*sectorSize)
+		copy(padded, data)
+		sectors = append(sectors, padded...)
+		return start
+	}
+	// Small streams go to the mini stream, large ones to regular sectors
+	var miniStream []byte
+	var miniFat []uint32
+	for i := range writer.entries {
+		entry := &writer.entries[i]
+		if entry.entryType != cfbStream {
+			continue
+		}
+		if len(entry.data) >= cfbMiniStreamCutoff {
+			entry.start = allocate(entry.data)
+			continue
+		}
+		if len(entry.data) == 0 {
+			continue
+		}
+		entry.start = uint32(len(miniFat))
+		count := (len(entry.data) + miniSectorSize - 1) / miniSectorSize
+		for j := 0; j < count; j++ {
+			next := uint32(len(miniFat) + 1)
+			if j == count-1 {
+				next = cfbEndOfChain
+			}
+			miniFat = append(miniFat, next)
+		}
+		padded := make([]byte, count*miniSectorSize)
+		copy(padded, entry.data)
+		miniStream = append(miniStream, padded...)
+	}
+	writer.entries[0].start = allocate(miniStream)
+	writer.entries[0].data = miniStream
+	var miniFatBytes bytes.Buffer
+	for _, next := range miniFat {
+		binary.Write(&miniFatBytes, binary.LittleEndian, next)
+	}
+	miniFatStart := allocate(miniFatBytes.Bytes())
+	var directory bytes.Buffer
+	for _, entry := range writer.entries {
+		record := make([]byte, 128)
+		units := utf16.Encode([]rune(entry.name))
+		if len(units) > 31 {
+			units = units[:31]
+		}
+		for j, unit := range units {
+			binary.LittleEndian.PutUint16(record[2*j:], unit)
+		}
+		binary.LittleEndian.PutUint16(record[64:], uint16(2*len(units)+2))
+		record[66] = entry.entryType
+		record[67] = 1
+		binary.LittleEndian.PutUint32(record[68:], cfbNoStream)
+		binary.LittleEndian.PutUint32(record[72:], entry.right)
+		binary.LittleEndian.PutUint32(record[76:], entry.child)
+		binary.LittleEndian.PutUint32(record[116:], entry.start)
+		binary.LittleEndian.PutUint64(record[120:], uint64(len(entry.data)))
+		directory.Write(record)
+	}
+	directoryStart := allocate(directory.Bytes())
+	// The FAT covers every sector including its own
+	fatEntries := sectorSize / 4
+	fatCount := (len(fat) + fatEntries) / fatEntries
+	for (len(fat)+fatCount+fatEntries-1)/fatEntries > fatCount {
+		fatCount++
+	}
+	if fatCount > cfbHeaderDifats {
+		return nil, fmt.Errorf("compound file of %d sectors is too large", len(fat))
+	}
+	fatStart := uint32(len(fat))
+	for i := 0; i < fatCount; i++ {
+		fat = append(fat, cfbFatSector)
+	}
+	for len(fat)%fatEntries != 0 {
+		fat = append(fat, cfbFreeSector)
+	}
+	var fatBytes bytes.Buffer
+	for _, next := range fat {
+		binary.Write(&fatBytes, binary.LittleEndian, next)
+	}
+	sectors = append(sectors, fatBytes.Bytes()...)
+	header := make([]byte, sectorSize)
+	copy(header, cfbSignature)
+	binary.LittleEndian.PutUint16(header[24:], 0x3e)
+	binary.LittleEndian.PutUint16(header[26:], 3)
+	binary.LittleEndian.PutUint16(header[28:], 0xfffe)
+	binary.LittleEndian.PutUint16(header[30:], 9)
+	binary.LittleEndian.PutUint16(header[32:], 6)
+	binary.LittleEndian.PutUint32(header[44:], uint32(fatCount))
+	binary.LittleEndian.PutUint32(header[48:], directoryStart)
+	binary.LittleEndian.PutUint32(header[56:], cfbMiniStreamCutoff)
+	binary.LittleEndian.PutUint32(header[60:], miniFatStart)
+	binary.LittleEndian.PutUint32(header[64:], uint32(len(miniFat)*4+sectorSize-1)/sectorSize)
+	binary.LittleEndian.PutUint32(header[68:], cfbEndOfChain)
+	for i := 0; i < cfbHeaderDifats; i++ {
+		sector := uint32(cfbFreeSector)
+		if i < fatCount {
+			sector = fatStart + uint32(i)
+		}
+		binary.LittleEndian.PutUint32(header[76+4*i:], sector)
+	}
+	return append(header, sectors...), nil
+}
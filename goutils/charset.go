@@ -0,0 +1,257 @@
+package goutils
+
+// Character encoding detection and transcoding of text inputs to UTF-8
+// Byte order marks and HTML meta charset declarations are trusted,
+// otherwise the encoding is guessed from byte statistics
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Charset used when no other encoding fits, a superset of ISO-8859-1
+const defaultCharset = "windows-1252"
+
+// Struct of a single byte encoding, frequent holds the non-ASCII letters
+// most used by the languages written in it
+type singleByteCharset struct {
+	name     string
+	frequent string
+}
+
+// Single byte encodings tried by the statistical detection, the default
+// charset is scored first and only replaced by a strictly better candidate
+var singleByteCharsets = []singleByteCharset{
+	{defaultCharset, "àâäçèéêëîïôöùûüßñáíóúãœæøå"},
+	{"windows-1250", "ąćęłńóśźżčďěňřšťůžýáéíúőű"},
+	{"iso-8859-2", "ąćęłńóśźżčďěňřšťůžýáéíúőű"},
+	{"windows-1251", "оеаинтсрвлкмдпуяы"},
+	{"koi8-r", "оеаинтсрвлкмдпуяы"},
+	{"iso-8859-5", "оеаинтсрвлкмдпуяы"},
+	{"iso-8859-7", "αοιετσνηρυκπμλςάέίόήύώ"},
+}
+
+// Multi-byte encodings tried by the statistical detection, in order of
+// preference when scores are equal
+var cjkCharsets = []string{"shift_jis", "euc-jp", "gb18030", "big5", "euc-kr"}
+
+// Frequent characters that mark text in a language beyond its script
+var frequentHanChars = "的是不了在人有我他这這中大来來上国國个個到说說们們为為和你地出道也时時年"
+
+// Minimum fraction of non-ASCII bytes next to another non-ASCII byte for
+// the input to be considered multi-byte text
+const minMultiBytePairRatio = 0.5
+
+// Return the detected character encoding of a text file
+func (file *File) GetCharset() string {
+	return file.fileCharset
+}
+
+// Detect the character encoding of text, certain is set when the encoding
+// comes from a byte order mark or a charset declaration
+func DetectCharset(data []byte) (string, bool) {
+	// Without a byte order mark the prescan of meta declarations returns
+	// uncertain, its default guesses are utf-8 and windows-1252
+	_, name, certain := charset.DetermineEncoding(data, "")
+	if certain || (name != "utf-8" && name != defaultCharset) {
+		return name, true
+	}
+	if utf8.Valid(data) {
+		return "utf-8", false
+	}
+	if name := detectUtf16(data); len(name) > 0 {
+		return name, false
+	}
+	bestCharset, bestScore := "", 0.0
+	for _, candidate := range singleByteCharsets {
+		score := singleByteScore(data, candidate)
+		if len(bestCharset) == 0 || score > bestScore {
+			bestCharset, bestScore = candidate.name, score
+		}
+	}
+	if multiBytePairRatio(data) < minMultiBytePairRatio {
+		return bestCharset, false
+	}
+	for _, candidate := range cjkCharsets {
+		score := cjkScore(data, candidate)
+		if score > bestScore {
+			bestCharset, bestScore = candidate, score
+		}
+	}
+	return bestCharset, false
+}
+
+// Extract strings from a text file transcoded to UTF-8 from its detected charset
+func extractText(file *File) ([]string, error) {
+	return extractStrings(&File{fileBytes: textBytes(file)})
+}
+
+// Detect the charset of a text file and return its bytes transcoded to UTF-8
+func textBytes(file *File) []byte {
+	file.fileCharset, _ = DetectCharset(file.fileBytes)
+	if file.fileCharset == "utf-8" {
+		return file.fileBytes
+	}
+	utf8Bytes, err := TranscodeToUtf8(file.fileBytes, file.fileCharset)
+	if err != nil {
+		log.Printf("MODULE=textBytes OPERATION=TranscodeToUtf8 CHARSET=%s ERROR=%s", file.fileCharset, err)
+		return file.fileBytes
+	}
+	return utf8Bytes
+}
+
+// Transcode text in the named encoding to UTF-8
+func TranscodeToUtf8(data []byte, name string) ([]byte, error) {
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimPrefix(decoded, []byte("\ufeff")), nil
+}
+
+// Detect UTF-16 without a byte order mark from zero high order bytes
+func detectUtf16(data []byte) string {
+	if len(data) < 4 {
+		return ""
+	}
+	evenZeros, oddZeros := 0, 0
+	for i := 0; i+1 < len(data); i += 2 {
+		if data[i] == 0 {
+			evenZeros++
+		}
+		if data[i+1] == 0 {
+			oddZeros++
+		}
+	}
+	units := len(data) / 2
+	switch {
+	case float64(oddZeros) >= minPrintableRatio*float64(units):
+		return "utf-16le"
+	case float64(evenZeros) >= minPrintableRatio*float64(units):
+		return "utf-16be"
+	}
+	return ""
+}
+
+// Fraction of non-ASCII bytes that sit next to another non-ASCII byte
+// Accented letters in single byte encodings are mostly surrounded by ASCII
+func multiBytePairRatio(data []byte) float64 {
+	high, paired := 0, 0
+	for i, b := range data {
+		if b < utf8.RuneSelf {
+			continue
+		}
+		high++
+		if (i > 0 && data[i-1] >= utf8.RuneSelf) || (i+1 < len(data) && data[i+1] >= utf8.RuneSelf) {
+			paired++
+		}
+	}
+	if high == 0 {
+		return 0
+	}
+	return float64(paired) / float64(high)
+}
+
+// Score how well data decodes in a single byte encoding on the scale of
+// cjkScore. Letters count once, frequent letters of the languages of the
+// encoding count twice more. Symbols, control characters, letters that
+// break a word by script or by an uppercase after a lowercase, and accented
+// Latin letters between two other non-ASCII letters count nothing
+func singleByteScore(data []byte, candidate singleByteCharset) float64 {
+	decoded, err := TranscodeToUtf8(data, candidate.name)
+	if err != nil {
+		return 0
+	}
+	runes := []rune(string(decoded))
+	total, letters, frequent := 0, 0, 0
+	for i, r := range runes {
+		if r < utf8.RuneSelf {
+			continue
+		}
+		total++
+		if !unicode.IsLetter(r) || (i > 0 && breaksWord(runes[i-1], r)) || (i+1 < len(runes) && breaksWord(r, runes[i+1])) {
+			continue
+		}
+		if unicode.Is(unicode.Latin, r) && i > 0 && i+1 < len(runes) && nonAsciiLetter(runes[i-1]) && nonAsciiLetter(runes[i+1]) {
+			continue
+		}
+		letters++
+		if strings.ContainsRune(candidate.frequent, r) {
+			frequent++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(letters+2*frequent) / float64(total)
+}
+
+// Check if two adjacent letters cannot belong to the same word, mixing
+// scripts or following a lowercase with an uppercase
+func breaksWord(first rune, second rune) bool {
+	if !unicode.IsLetter(first) || !unicode.IsLetter(second) {
+		return false
+	}
+	if unicode.IsLower(first) && unicode.IsUpper(second) {
+		return true
+	}
+	for _, script := range []*unicode.RangeTable{unicode.Latin, unicode.Cyrillic, unicode.Greek} {
+		if unicode.Is(script, first) != unicode.Is(script, second) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a rune is a letter outside ASCII
+func nonAsciiLetter(r rune) bool {
+	return r >= utf8.RuneSelf && unicode.IsLetter(r)
+}
+
+// Score how well data decodes in a multi-byte encoding, zero when it does
+// not decode cleanly. Script characters count once, characters marking a
+// language (kana, hangul, frequent hanzi) count twice more
+func cjkScore(data []byte, name string) float64 {
+	decoded, err := TranscodeToUtf8(data, name)
+	if err != nil || bytes.ContainsRune(decoded, utf8.RuneError) {
+		return 0
+	}
+	total, script, marker := 0, 0, 0
+	for _, r := range string(decoded) {
+		if r < utf8.RuneSelf {
+			continue
+		}
+		total++
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			script++
+			if name == "shift_jis" || name == "euc-jp" {
+				marker++
+			}
+		case unicode.Is(unicode.Hangul, r):
+			script++
+			if name == "euc-kr" {
+				marker++
+			}
+		case unicode.Is(unicode.Han, r):
+			script++
+			if (name == "gb18030" || name == "big5") && strings.ContainsRune(frequentHanChars, r) {
+				marker++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(script+2*marker) / float64(total)
+}
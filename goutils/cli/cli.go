@@ -2,8 +2,12 @@ package main
 
 import (
   "flag"
+  "fmt"
   "goutils"
+  "io/ioutil"
   "log"
+  "strconv"
+  "strings"
 )
 
 func main() {
@@ -19,9 +23,242 @@ func main() {
   } else if *FlagUrl != "false" {
     log.Println("Starting HttpGet")
     goutils.GetUrl(*FlagUrl)
-  }else {
+  } else if *FlagIndicators != "false" {
+    log.Println("Starting Indicators")
+    file := parseInputFile(*FlagInput)
+    if file != nil {
+      options := goutils.IndicatorOptions{Count: *FlagCount != "false"}
+      for _, indicator := range file.IndicatorsWithOptions(options) {
+        if options.Count {
+          fmt.Printf("type=%s value=%s count=%d\n", indicator.Type, indicator.Value, indicator.Count)
+        } else {
+          fmt.Printf("type=%s value=%s\n", indicator.Type, indicator.Value)
+        }
+      }
+    }
+  } else if *FlagSecrets != "false" {
+    log.Println("Starting Secrets")
+    file := parseInputFile(*FlagInput)
+    if file != nil {
+      for _, secret := range file.Secrets() {
+        fmt.Printf("type=%s location=%s preview=%s count=%d\n", secret.Type, secret.Location, secret.Preview, secret.Count)
+      }
+    }
+  } else if *FlagDecoded != "false" {
+    log.Println("Starting DecodedStrings")
+    file := parseInputFile(*FlagInput)
+    if file != nil {
+      for _, decoded := range file.GetDecodedStrings() {
+        fmt.Printf("encoding=%s depth=%d value=%q\n", decoded.Encoding, decoded.Depth, decoded.Value)
+      }
+    }
+  } else if *FlagStrings != "false" {
+    log.Println("Starting ExtractBinaryStrings")
+    fileBytes, err := ioutil.ReadFile(*FlagInput)
+    if err != nil {
+      log.Println(fmt.Sprintf("status=in_file_read_fail file=%s error=%s", *FlagInput, err))
+      return
+    }
+    options := goutils.StringsOptions{KeepWhitespace: *FlagWhitespace != "false"}
+    if *FlagMinLength != "false" {
+      options.MinLength, _ = strconv.Atoi(*FlagMinLength)
+    }
+    for _, binaryString := range goutils.ExtractBinaryStrings(fileBytes, options) {
+      fmt.Printf("offset=%d encoding=%s value=%q\n", binaryString.Offset, binaryString.Encoding, binaryString.Value)
+    }
+  } else if *FlagHtml != "false" {
+    log.Println("Starting ParseHtml")
+    file := parseInputFile(*FlagInput)
+    if file != nil && file.GetHtml() != nil {
+      document := file.GetHtml()
+      for _, link := range document.Links {
+        fmt.Printf("link tag=%s attribute=%s url=%s\n", link.Tag, link.Attribute, link.Url)
+      }
+      for _, form := range document.Forms {
+        fmt.Printf("form action=%s method=%s fields=%d has_password=%t\n", form.Action, form.Method, len(form.Fields), form.HasPassword)
+      }
+      for _, script := range document.Scripts {
+        fmt.Printf("script src=%s type=%s length=%d\n", script.Src, script.Type, len(script.Body))
+      }
+    }
+  } else if *FlagRtf != "false" {
+    log.Println("Starting ParseRtf")
+    file := parseInputFile(*FlagInput)
+    if file != nil && file.GetRtf() != nil {
+      document := file.GetRtf()
+      for _, object := range document.Objects {
+        fmt.Printf("object class=%s size=%d file_name=%s link=%s auto_update=%t\n", object.Class, object.Size, object.FileName, object.Link, object.AutoUpdate)
+      }
+      fmt.Printf("pictures=%d\n", document.Pictures)
+      for _, anomaly := range document.Anomalies {
+        fmt.Printf("anomaly=%s\n", anomaly)
+      }
+    }
+  } else if *FlagEmail != "false" {
+    log.Println("Starting ParseEmail")
+    file := parseInputFile(*FlagInput)
+    if file != nil && file.GetEmail() != nil {
+      email := file.GetEmail()
+      fmt.Printf("from=%s\nto=%s\nsubject=%s\ndate=%s\nmessage_id=%s\n", email.From, email.To, email.Subject, email.Date, email.MessageId)
+      for _, received := range email.Received {
+        fmt.Printf("received=%s\n", received)
+      }
+      for _, results := range email.AuthenticationResults {
+        fmt.Printf("authentication_results=%s\n", results)
+      }
+      for _, attachment := range email.Attachments {
+        fmt.Printf("attachment file_name=%s content_type=%s size=%d inline=%t\n", attachment.FileName, attachment.ContentType, attachment.Size, attachment.Inline)
+      }
+    }
+  } else if *FlagMsg != "false" {
+    log.Println("Starting ParseOutlookMessage")
+    file := parseInputFile(*FlagInput)
+    if file != nil && file.GetOutlookMessage() != nil {
+      message := file.GetOutlookMessage()
+      fmt.Printf("sender_name=%s\nsender_email=%s\nsubject=%s\nmessage_id=%s\n", message.SenderName, message.SenderEmail, message.Subject, message.MessageId)
+      for _, received := range message.Received {
+        fmt.Printf("received=%s\n", received)
+      }
+      for _, results := range message.AuthenticationResults {
+        fmt.Printf("authentication_results=%s\n", results)
+      }
+      for _, recipient := range message.Recipients {
+        fmt.Printf("recipient name=%s email=%s type=%s\n", recipient.Name, recipient.Email, recipient.Type)
+      }
+      for _, attachment := range message.Attachments {
+        fmt.Printf("attachment file_name=%s size=%d embedded=%t\n", attachment.FileName, attachment.Size, attachment.Embedded)
+      }
+    }
+  } else if *FlagExe != "false" {
+    log.Println("Starting AnalyzeExecutable")
+    file := parseInputFile(*FlagInput)
+    if file != nil && file.GetExecutable() != nil {
+      executable := file.GetExecutable()
+      fmt.Printf("format=%s\narchitecture=%s\nbits=%d\nentry_point=0x%x\ntimestamp=%s\nimphash=%s\n", executable.Format, executable.Architecture, executable.Bits, executable.EntryPoint, executable.Timestamp, executable.Imphash)
+      for _, section := range executable.Sections {
+        fmt.Printf("section name=%s address=0x%x size=%d entropy=%.3f permissions=%s\n", section.Name, section.Address, section.Size, section.Entropy, section.Permissions)
+      }
+      for _, function := range executable.Imports {
+        fmt.Printf("import=%s\n", function)
+      }
+      for _, function := range executable.Exports {
+        fmt.Printf("export=%s\n", function)
+      }
+      for _, resource := range executable.Resources {
+        fmt.Printf("resource type=%s name=%s language=%d size=%d entropy=%.3f\n", resource.Type, resource.Name, resource.Language, resource.Size, resource.Entropy)
+      }
+      for key, value := range executable.VersionInfo {
+        fmt.Printf("version_info %s=%s\n", key, value)
+      }
+      if executable.OverlaySize > 0 {
+        fmt.Printf("overlay offset=%d size=%d\n", executable.OverlayOffset, executable.OverlaySize)
+      }
+    }
+  } else if *FlagImage != "false" {
+    log.Println("Starting ImageMetadata")
+    file := parseInputFile(*FlagInput)
+    if file != nil && file.GetImageMetadata() != nil {
+      metadata := file.GetImageMetadata()
+      fmt.Printf("format=%s\nwidth=%d\nheight=%d\n", metadata.Format, metadata.Width, metadata.Height)
+      if metadata.Gps != nil {
+        fmt.Printf("gps latitude=%f longitude=%f altitude=%.1f timestamp=%s\n", metadata.Gps.Latitude, metadata.Gps.Longitude, metadata.Gps.Altitude, metadata.Gps.Timestamp)
+      }
+      for key, value := range metadata.Exif {
+        fmt.Printf("exif %s=%s\n", key, value)
+      }
+      for key, value := range metadata.Iptc {
+        fmt.Printf("iptc %s=%s\n", key, value)
+      }
+      for _, comment := range metadata.Comments {
+        fmt.Printf("comment=%s\n", comment)
+      }
+      if len(metadata.Xmp) > 0 {
+        fmt.Printf("xmp=%s\n", metadata.Xmp)
+      }
+    }
+  } else if *FlagTypeCheck != "false" {
+    log.Println("Starting TypeCheck")
+    file := parseInputFile(*FlagInput)
+    if file != nil && file.GetTypeCheck() != nil {
+      check := file.GetTypeCheck()
+      fmt.Printf("declared=%s\ndetected=%s\nmismatch=%t\ndisguised_executable=%t\npolyglot=%t\n", check.DeclaredExtension, check.DetectedType, check.ExtensionMismatch, check.DisguisedExecutable, check.Polyglot)
+      for _, signature := range check.Signatures {
+        fmt.Printf("signature format=%s offset=%d\n", signature.Format, signature.Offset)
+      }
+    }
+  } else if *FlagEntropy != "false" {
+    log.Println("Starting Entropy")
+    file := parseInputFile(*FlagInput)
+    if file != nil && file.GetEntropy() != nil {
+      entropy := file.GetEntropy()
+      fmt.Printf("entropy=%.3f\nmax_entropy=%.3f\nwindow_size=%d\n", entropy.Entropy, entropy.MaxEntropy, entropy.WindowSize)
+      for _, region := range entropy.HighRegions {
+        fmt.Printf("high_region offset=%d size=%d entropy=%.3f compressed=%s\n", region.Offset, region.Size, region.Entropy, region.Compressed)
+      }
+      for _, member := range entropy.Members {
+        fmt.Printf("member name=%s compressed=%d uncompressed=%d ratio=%.2f incompressible=%t excessive=%t\n", member.Name, member.CompressedSize, member.UncompressedSize, member.Ratio, member.Incompressible, member.Excessive)
+      }
+    }
+  } else if *FlagRules != "false" {
+    log.Println("Starting MatchRules")
+    rules, err := goutils.LoadRules(*FlagRules)
+    if err != nil {
+      log.Println(fmt.Sprintf("status=rules_load_fail file=%s error=%s", *FlagRules, err))
+      return
+    }
+    file := parseInputFile(*FlagInput)
+    if file != nil {
+      for _, match := range file.MatchRules(rules) {
+        fmt.Printf("rule=%s file=%s depth=%d tags=%s\n", match.Rule, match.FileName, match.Depth, strings.Join(match.Tags, ","))
+        for _, stringMatch := range match.Strings {
+          fmt.Printf("  string=%s source=%s offset=%d data=%+q\n", stringMatch.Identifier, stringMatch.Source, stringMatch.Offset, stringMatch.Data)
+        }
+      }
+    }
+  } else if *FlagParsers != "false" {
+    for _, parser := range goutils.Parsers() {
+      fmt.Printf("mime=%s priority=%d parser=%s extensions=%s\n", parser.Mime, parser.Priority, parser.Parser, strings.Join(parser.Extensions, ","))
+    }
+  } else if *FlagUrls != "false" {
+    log.Println("Starting UrlExtract")
+    file := parseInputFile(*FlagInput)
+    if file != nil {
+      options := goutils.UrlOptions{
+        Refang:       *FlagRefang != "false",
+        Unwrap:       *FlagUnwrap != "false",
+        Canonicalize: *FlagCanonical != "false",
+        SortQuery:    *FlagSortQuery != "false",
+        Defang:       *FlagDefang != "false",
+      }
+      if *FlagUrlParts != "false" {
+        for _, parts := range file.UrlExtractParts(options) {
+          fmt.Printf("url=%s scheme=%s host=%s port=%s registered_domain=%s path=%s query=%s\n",
+            parts.Url, parts.Scheme, parts.Host, parts.Port, parts.RegisteredDomain, parts.Path, parts.Query)
+        }
+      } else {
+        for _, url := range file.UrlExtractWithOptions(options) {
+          fmt.Println(url)
+        }
+      }
+    }
+  } else {
     log.Println("No input received")
     log.Println(*FlagFlattenJson)
     log.Println(*FlagTimeCount)
   }
 }
+
+// Load and parse the input file
+func parseInputFile(inFile string) *goutils.File {
+  fileBytes, err := ioutil.ReadFile(inFile)
+  if err != nil {
+    log.Println(fmt.Sprintf("status=in_file_read_fail file=%s error=%s", inFile, err))
+    return nil
+  }
+  file := goutils.LoadFile(fileBytes, inFile)
+  _, err = file.Parse()
+  if err != nil {
+    log.Println(fmt.Sprintf("status=file_parse_fail file=%s error=%s", inFile, err))
+  }
+  return file
+}
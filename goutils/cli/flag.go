@@ -10,4 +10,28 @@ var FlagTimeCountBack = flag.String("back", "false", "Timecount, back time")
 var FlagTimeCountIncr = flag.String("increment", "false", "Timecount, increment")
 var FlagInput = flag.String("i", "false", "Input file")
 var FlagOutput = flag.String("o", "false", "Outputs the modified file")
-var FlagUrl = flag.String("url", "false", "Outputs the modified file")
\ No newline at end of file
+var FlagUrl = flag.String("url", "false", "Outputs the modified file")
+var FlagIndicators = flag.String("indicators", "false", "Extract indicators of compromise from the input file")
+var FlagCount = flag.String("count", "false", "Indicators, count the occurrences of each indicator")
+var FlagUrls = flag.String("urls", "false", "Extract urls from the input file")
+var FlagRefang = flag.String("refang", "false", "Urls, recover defanged and obfuscated urls")
+var FlagDefang = flag.String("defang", "false", "Urls, output defanged urls")
+var FlagUnwrap = flag.String("unwrap", "false", "Urls, add the targets of Safe Links, Google and Proofpoint redirects")
+var FlagCanonical = flag.String("canonical", "false", "Urls, output canonical urls")
+var FlagSortQuery = flag.String("sortquery", "false", "Urls, sort query parameters of canonical urls")
+var FlagUrlParts = flag.String("urlparts", "false", "Urls, output each url decomposed into its parts")
+var FlagDecoded = flag.String("decoded", "false", "Output strings decoded from base64, hex, UTF-16 and compressed regions of the input file")
+var FlagStrings = flag.String("strings", "false", "Output printable strings of the input file with their offsets")
+var FlagMinLength = flag.String("minlen", "false", "Strings, minimum string length")
+var FlagWhitespace = flag.String("whitespace", "false", "Strings, keep whitespace only strings")
+var FlagHtml = flag.String("html", "false", "Output links, forms and scripts of an HTML input file")
+var FlagRtf = flag.String("rtf", "false", "Output embedded objects and anomalies of an RTF input file")
+var FlagEmail = flag.String("email", "false", "Output headers and attachments of an email input file")
+var FlagMsg = flag.String("msg", "false", "Output headers, recipients and attachments of an Outlook msg input file")
+var FlagExe = flag.String("exe", "false", "Output sections, imports, exports and resources of a PE, ELF or Mach-O input file")
+var FlagImage = flag.String("image", "false", "Output EXIF, GPS, IPTC and XMP metadata of an image input file")
+var FlagTypeCheck = flag.String("typecheck", "false", "Output extension mismatches and polyglot signatures of an input file")
+var FlagEntropy = flag.String("entropy", "false", "Output the entropy profile, high entropy regions and archive member ratios of an input file")
+var FlagSecrets = flag.String("secrets", "false", "Output secrets and credentials found in an input file with redacted previews")
+var FlagRules = flag.String("rules", "false", "Match the rules of a rule file against an input file and its child files")
+var FlagParsers = flag.String("parsers", "false", "List the registered parsers in resolution order")
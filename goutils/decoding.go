@@ -0,0 +1,223 @@
+package goutils
+
+// Decoding pass over extracted strings
+// Finds base64, hex and UTF-16LE/BE regions, decodes them recursively and
+// decompresses zlib and gzip blobs found inside the decoded bytes
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Struct of a string recovered by the decoding pass and its provenance
+type DecodedString struct {
+	Value    string `json:"value"`
+	Encoding string `json:"encoding"`
+	Encoded  string `json:"encoded"`
+	Depth    int    `json:"depth"`
+}
+
+// Encodings recorded in a decoded string's encoding chain
+const (
+	EncodingBase64  = "base64"
+	EncodingHex     = "hex"
+	EncodingUtf16le = "utf16le"
+	EncodingUtf16be = "utf16be"
+	EncodingZlib    = "zlib"
+	EncodingGzip    = "gzip"
+)
+
+// Limits of the decoding pass
+const (
+	maxDecodeDepth      = 3
+	maxDecodedStrings   = 1000
+	maxDecompressedSize = 10 << 20
+	maxEncodedPreview   = 64
+	minDecodedLength    = 6
+)
+
+// Minimum fraction of printable runes for decoded bytes to count as text
+const minPrintableRatio = 0.9
+
+var base64RegionRe = regexp.MustCompile(`[A-Za-z0-9+/_-]{24,}={0,2}`)
+var hexRegionRe = regexp.MustCompile(`\b(?:[0-9A-Fa-f]{2}){12,}\b`)
+var utf16leRegionRe = regexp.MustCompile(`(?:[\x09\x0a\x0d\x20-\x7e]\x00){6,}`)
+var utf16beRegionRe = regexp.MustCompile(`(?:\x00[\x09\x0a\x0d\x20-\x7e]){6,}`)
+var hexOnlyRe = regexp.MustCompile(`^[0-9A-Fa-f]+$`)
+
+// Return strings recovered from encoded regions by the decoding pass
+func (file *File) GetDecodedStrings() []DecodedString {
+	return file.fileDecoded
+}
+
+// Decode encoded regions found in strings, recursing into decoded text
+func decodeStrings(strs []string) []DecodedString {
+	var decoded []DecodedString
+	seen := map[string]bool{}
+	for _, str := range strs {
+		decoded = decodeRegions(str, "", 1, decoded, seen)
+		if len(decoded) >= maxDecodedStrings {
+			break
+		}
+	}
+	return decoded
+}
+
+// Decode the encoded regions of one string and append the results
+// chain is the encoding chain of the string itself, empty at the top level
+func decodeRegions(str string, chain string, depth int, decoded []DecodedString, seen map[string]bool) []DecodedString {
+	if depth > maxDecodeDepth {
+		return decoded
+	}
+	add := func(encoding string, encoded string, decodedBytes []byte) {
+		if len(decoded) >= maxDecodedStrings {
+			return
+		}
+		encoding, decodedBytes = decompressBlob(encoding, decodedBytes)
+		// Encoded scripts such as PowerShell -EncodedCommand are UTF-16LE
+		if looksUtf16le(decodedBytes) {
+			encoding = encoding + "/" + EncodingUtf16le
+			decodedBytes = decodeUtf16(decodedBytes, false)
+		}
+		if !printableText(decodedBytes) {
+			return
+		}
+		value := string(decodedBytes)
+		if seen[value] {
+			return
+		}
+		seen[value] = true
+		if len(chain) > 0 {
+			encoding = chain + "/" + encoding
+		}
+		if len(encoded) > maxEncodedPreview {
+			encoded = encoded[:maxEncodedPreview]
+		}
+		decoded = append(decoded, DecodedString{Value: value, Encoding: encoding, Encoded: encoded, Depth: depth})
+		decoded = decodeRegions(value, encoding, depth+1, decoded, seen)
+	}
+	// Both patterns match a UTF-16 region shifted by one byte, a zero byte
+	// before a little endian match or after a big endian match means the
+	// region belongs to the other byte order
+	for _, loc := range utf16leRegionRe.FindAllStringIndex(str, -1) {
+		if loc[0] > 0 && str[loc[0]-1] == 0 {
+			continue
+		}
+		region := str[loc[0]:loc[1]]
+		add(EncodingUtf16le, region, decodeUtf16([]byte(region), false))
+	}
+	for _, loc := range utf16beRegionRe.FindAllStringIndex(str, -1) {
+		if loc[1] < len(str) && str[loc[1]] == 0 {
+			continue
+		}
+		region := str[loc[0]:loc[1]]
+		add(EncodingUtf16be, region, decodeUtf16([]byte(region), true))
+	}
+	for _, region := range hexRegionRe.FindAllString(str, -1) {
+		decodedBytes, err := hex.DecodeString(region)
+		if err == nil {
+			add(EncodingHex, region, decodedBytes)
+		}
+	}
+	for _, region := range base64RegionRe.FindAllString(str, -1) {
+		// Hex digits are valid base64, those regions are left to the hex decoder
+		if hexOnlyRe.MatchString(region) {
+			continue
+		}
+		decodedBytes, err := decodeBase64(region)
+		if err == nil {
+			add(EncodingBase64, region, decodedBytes)
+		}
+	}
+	return decoded
+}
+
+// Decode standard or url safe base64, with or without padding
+func decodeBase64(encoded string) ([]byte, error) {
+	encoded = strings.TrimRight(encoded, "=")
+	// A trailing single character carries no complete byte
+	if len(encoded)%4 == 1 {
+		encoded = encoded[:len(encoded)-1]
+	}
+	if strings.ContainsAny(encoded, "-_") {
+		return base64.RawURLEncoding.DecodeString(encoded)
+	}
+	return base64.RawStdEncoding.DecodeString(encoded)
+}
+
+// Decode UTF-16 code units to UTF-8
+func decodeUtf16(encoded []byte, bigEndian bool) []byte {
+	units := make([]uint16, len(encoded)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(encoded[2*i])<<8 | uint16(encoded[2*i+1])
+		} else {
+			units[i] = uint16(encoded[2*i+1])<<8 | uint16(encoded[2*i])
+		}
+	}
+	return []byte(string(utf16.Decode(units)))
+}
+
+// Check for UTF-16LE text, where most high order bytes are zero
+func looksUtf16le(decodedBytes []byte) bool {
+	if len(decodedBytes) < 2*minDecodedLength || len(decodedBytes)%2 != 0 {
+		return false
+	}
+	zeros := 0
+	for i := 1; i < len(decodedBytes); i += 2 {
+		if decodedBytes[i] == 0 {
+			zeros++
+		}
+	}
+	return float64(zeros) >= minPrintableRatio*float64(len(decodedBytes)/2)
+}
+
+// Decompress zlib and gzip blobs, the encoding chain records each step
+func decompressBlob(encoding string, blob []byte) (string, []byte) {
+	var reader io.ReadCloser
+	var err error
+	switch {
+	case len(blob) > 2 && blob[0] == 0x1f && blob[1] == 0x8b:
+		reader, err = gzip.NewReader(bytes.NewReader(blob))
+		encoding = encoding + "/" + EncodingGzip
+	case len(blob) > 2 && blob[0]&0x0f == 8 && (uint16(blob[0])<<8|uint16(blob[1]))%31 == 0:
+		reader, err = zlib.NewReader(bytes.NewReader(blob))
+		encoding = encoding + "/" + EncodingZlib
+	default:
+		return encoding, blob
+	}
+	if err != nil {
+		return encoding, nil
+	}
+	defer reader.Close()
+	decompressed, err := ioutil.ReadAll(io.LimitReader(reader, maxDecompressedSize))
+	if err != nil && len(decompressed) == 0 {
+		return encoding, nil
+	}
+	return encoding, decompressed
+}
+
+// Check that bytes are valid UTF-8 and mostly printable text
+func printableText(decodedBytes []byte) bool {
+	if len(decodedBytes) < minDecodedLength || !utf8.Valid(decodedBytes) {
+		return false
+	}
+	total, printable := 0, 0
+	for _, r := range string(decodedBytes) {
+		total++
+		if unicode.IsPrint(r) || r == '\t' || r == '\n' || r == '\r' {
+			printable++
+		}
+	}
+	return float64(printable) >= minPrintableRatio*float64(total)
+}
@@ -0,0 +1,291 @@
+package goutils
+
+// Email (message/rfc822) parsing
+// Headers, quoted-printable and base64 text and HTML bodies, and every
+// attachment or inline part as a child file
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// Struct of a parsed email message
+type EmailMessage struct {
+	From                  string            `json:"from"`
+	To                    string            `json:"to"`
+	Cc                    string            `json:"cc,omitempty"`
+	ReplyTo               string            `json:"reply_to,omitempty"`
+	ReturnPath            string            `json:"return_path,omitempty"`
+	Subject               string            `json:"subject"`
+	Date                  string            `json:"date"`
+	MessageId             string            `json:"message_id"`
+	Received              []string          `json:"received,omitempty"`
+	AuthenticationResults []string          `json:"authentication_results,omitempty"`
+	TextBody              string            `json:"text_body,omitempty"`
+	HtmlBody              string            `json:"html_body,omitempty"`
+	Attachments           []EmailAttachment `json:"attachments,omitempty"`
+}
+
+// Struct of an attachment or inline part extracted as a child file
+type EmailAttachment struct {
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	ContentId   string `json:"content_id,omitempty"`
+	Inline      bool   `json:"inline"`
+	Size        int    `json:"size"`
+}
+
+// Maximum nesting depth of multipart bodies
+const maxMimeDepth = 16
+
+// Headers that mark the start of an email message
+var emailHeaderRe = regexp.MustCompile(`(?im)^(?:Received|Return-Path|From|To|Subject|Message-ID|MIME-Version|Date|Delivered-To|X-[A-Za-z-]+):[ \t]`)
+var emailFirstLineRe = regexp.MustCompile(`^(?:From [^\r\n]+|[A-Za-z0-9-]+:[^\r\n]*)\r?\n`)
+
+// Minimum number of known headers for a text file to be treated as email
+const minEmailHeaders = 3
+
+// Decoder of RFC 2047 encoded words, charsets are transcoded with x/text
+var emailWordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		encoded, err := ioutil.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := TranscodeToUtf8(encoded, charset)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(decoded), nil
+	},
+}
+
+// Return the parsed email message of an email file
+func (file *File) GetEmail() *EmailMessage {
+	return file.fileEmail
+}
+
+// Sniff email messages by their header block
+func sniffEmail(fileBytes []byte) (string, string) {
+	if looksLikeEmail(fileBytes) {
+		return "message/rfc822", "eml"
+	}
+	return "", ""
+}
+
+// Check whether bytes start with an RFC 822 header block
+func looksLikeEmail(fileBytes []byte) bool {
+	if !emailFirstLineRe.Match(fileBytes) {
+		return false
+	}
+	head := fileBytes
+	if end := bytes.Index(head, []byte("\n\n")); end >= 0 {
+		head = head[:end]
+	} else if end := bytes.Index(head, []byte("\r\n\r\n")); end >= 0 {
+		head = head[:end]
+	}
+	return len(emailHeaderRe.FindAll(head, minEmailHeaders)) >= minEmailHeaders
+}
+
+// Extract headers and bodies from an email, attachments become child files
+func extractEmail(file *File) ([]string, error) {
+	fileBytes := file.fileBytes
+	// An mbox "From " separator line precedes the headers
+	if bytes.HasPrefix(fileBytes, []byte("From ")) {
+		if end := bytes.IndexByte(fileBytes, '\n'); end >= 0 {
+			fileBytes = fileBytes[end+1:]
+		}
+	}
+	message, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(fileBytes)))
+	if err != nil {
+		return nil, err
+	}
+	email := &EmailMessage{
+		From:                  decodeEmailHeader(message.Header.Get("From")),
+		To:                    decodeEmailHeader(message.Header.Get("To")),
+		Cc:                    decodeEmailHeader(message.Header.Get("Cc")),
+		ReplyTo:               decodeEmailHeader(message.Header.Get("Reply-To")),
+		ReturnPath:            message.Header.Get("Return-Path"),
+		Subject:               decodeEmailHeader(message.Header.Get("Subject")),
+		Date:                  message.Header.Get("Date"),
+		MessageId:             message.Header.Get("Message-Id"),
+		Received:              message.Header["Received"],
+		AuthenticationResults: message.Header["Authentication-Results"],
+	}
+	file.fileEmail = email
+	walker := &emailWalker{file: file, email: email}
+	walker.walk(textproto.MIMEHeader(message.Header), message.Body, 0)
+	textBodies, htmlBodies := walker.textBodies, walker.htmlBodies
+	email.TextBody = strings.Join(textBodies, "\n")
+	email.HtmlBody = strings.Join(htmlBodies, "\n")
+	emailStrings := []string{email.From, email.To, email.Cc, email.ReplyTo, email.ReturnPath, email.Subject}
+	emailStrings = append(emailStrings, email.Received...)
+	emailStrings = append(emailStrings, email.AuthenticationResults...)
+	emailStrings = append(emailStrings, textBodies...)
+	for _, body := range htmlBodies {
+		emailStrings = append(emailStrings, htmlBodyStrings(body)...)
+	}
+	var nonEmpty []string
+	for _, str := range emailStrings {
+		if len(strings.TrimSpace(str)) > 0 {
+			nonEmpty = append(nonEmpty, str)
+		}
+	}
+	return nonEmpty, nil
+}
+
+// Return the visible text, links and scripts of an HTML message body
+func htmlBodyStrings(body string) []string {
+	document, err := ParseHtml([]byte(body))
+	if err != nil {
+		return []string{body}
+	}
+	bodyStrings := []string{document.Text}
+	for _, link := range document.Links {
+		bodyStrings = append(bodyStrings, link.Url)
+	}
+	for _, script := range document.Scripts {
+		bodyStrings = append(bodyStrings, script.Body)
+	}
+	return bodyStrings
+}
+
+// Walker over the MIME tree of a message
+type emailWalker struct {
+	file       *File
+	email      *EmailMessage
+	textBodies []string
+	htmlBodies []string
+	parts      int
+}
+
+// Walk a MIME part, collecting text bodies and adding other parts as children
+func (walker *emailWalker) walk(header textproto.MIMEHeader, body io.Reader, depth int) {
+	if depth > maxMimeDepth {
+		log.Printf("MODULE=extractEmail ERROR=maximum MIME depth %d reached", maxMimeDepth)
+		return
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Printf("MODULE=extractEmail OPERATION=NextRawPart ERROR=%s", err)
+				break
+			}
+			walker.walk(part.Header, part, depth+1)
+		}
+		return
+	}
+	partBytes, err := ioutil.ReadAll(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil && len(partBytes) == 0 {
+		log.Printf("MODULE=extractEmail OPERATION=decodeTransferEncoding ERROR=%s", err)
+		return
+	}
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	fileName := decodeEmailHeader(dispositionParams["filename"])
+	if len(fileName) == 0 {
+		fileName = decodeEmailHeader(params["name"])
+	}
+	isBody := disposition != "attachment" && len(fileName) == 0
+	if isBody && (mediaType == "text/plain" || mediaType == "text/html") {
+		text := string(partBytes)
+		if charset := strings.ToLower(params["charset"]); len(charset) > 0 && charset != "utf-8" && charset != "us-ascii" {
+			if decoded, err := TranscodeToUtf8(partBytes, charset); err == nil {
+				text = string(decoded)
+			}
+		}
+		if mediaType == "text/html" {
+			walker.htmlBodies = append(walker.htmlBodies, text)
+		} else {
+			walker.textBodies = append(walker.textBodies, text)
+		}
+		return
+	}
+	walker.parts++
+	if len(fileName) == 0 {
+		extension := ".bin"
+		if mediaType == "message/rfc822" {
+			extension = ".eml"
+		} else if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 {
+			extension = extensions[0]
+		}
+		fileName = fmt.Sprintf("part_%d%s", walker.parts, extension)
+	}
+	attachment := EmailAttachment{
+		FileName:    fileName,
+		ContentType: mediaType,
+		ContentId:   strings.Trim(header.Get("Content-Id"), "<>"),
+		Inline:      disposition != "attachment",
+		Size:        len(partBytes),
+	}
+	walker.email.Attachments = append(walker.email.Attachments, attachment)
+	attributes := fileHashes(partBytes)
+	attributes["source"] = "email_attachment"
+	if attachment.Inline {
+		attributes["source"] = "email_inline"
+	}
+	attributes["content_type"] = mediaType
+	if len(attachment.ContentId) > 0 {
+		attributes["content_id"] = attachment.ContentId
+	}
+	walker.file.addChild(partBytes, fileName, attributes)
+}
+
+// Wrap a part body in the decoder of its Content-Transfer-Encoding
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Cleaner{reader: body})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// Reader dropping characters outside the base64 alphabet, mailers wrap
+// lines with spaces and some pad bodies with junk
+type base64Cleaner struct {
+	reader io.Reader
+}
+
+// Read from the underlying reader and keep base64 characters only
+func (cleaner *base64Cleaner) Read(p []byte) (int, error) {
+	n, err := cleaner.reader.Read(p)
+	kept := 0
+	for _, c := range p[:n] {
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '+' || c == '/' || c == '=' {
+			p[kept] = c
+			kept++
+		}
+	}
+	return kept, err
+}
+
+// Decode RFC 2047 encoded words in a header value
+func decodeEmailHeader(value string) string {
+	decoded, err := emailWordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
@@ -0,0 +1,341 @@
+package goutils
+
+// Entropy analysis of files and archive members
+// A sliding window entropy profile locates encrypted or packed regions, and
+// compression ratios of zip members reveal members that do not compress
+// Regions inside data compressed by the file format itself, PDF streams,
+// zip members and image data, are labeled so they can be told apart
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Struct of the entropy analysis of a file
+type EntropyAnalysis struct {
+	Entropy     float64         `json:"entropy"`
+	WindowSize  int             `json:"window_size"`
+	WindowStep  int             `json:"window_step"`
+	Profile     []float64       `json:"profile,omitempty"`
+	MaxEntropy  float64         `json:"max_entropy"`
+	HighRegions []EntropyRegion `json:"high_regions,omitempty"`
+	Members     []ArchiveMember `json:"members,omitempty"`
+}
+
+// Struct of a high entropy region of a file, Compressed names the kind of
+// format compressed data the region lies in
+type EntropyRegion struct {
+	Offset     int     `json:"offset"`
+	Size       int     `json:"size"`
+	Entropy    float64 `json:"entropy"`
+	Compressed string  `json:"compressed,omitempty"`
+}
+
+// Struct of the compression of an archive member, Ratio is the uncompressed
+// size over the compressed size
+type ArchiveMember struct {
+	Name             string  `json:"name"`
+	Method           uint16  `json:"method"`
+	CompressedSize   uint64  `json:"compressed_size"`
+	UncompressedSize uint64  `json:"uncompressed_size"`
+	Ratio            float64 `json:"ratio"`
+	Encrypted        bool    `json:"encrypted,omitempty"`
+	Incompressible   bool    `json:"incompressible,omitempty"`
+	Excessive        bool    `json:"excessive,omitempty"`
+}
+
+// Entropy in bits per byte above which a window is considered encrypted
+// or packed
+const highEntropyThreshold = 7.2
+
+// Smallest window of the entropy profile, and the number of windows larger
+// files are divided into
+const (
+	minEntropyWindow = 1024
+	entropyWindows   = 64
+)
+
+// Files shorter than this cannot reach a meaningful entropy
+const minEntropySize = 256
+
+// Fraction of a high entropy region that must lie in format compressed
+// data for the region to be labeled compressed
+const minCompressedCoverage = 0.8
+
+// Kinds of format compressed data
+const (
+	CompressedPdfStream = "pdf_stream"
+	CompressedZipMember = "zip_member"
+	CompressedJpegScan  = "jpeg_scan"
+	CompressedPngData   = "png_data"
+)
+
+// PDF stream keyword and the filter of its object dictionary
+var pdfStreamRe = regexp.MustCompile(`stream\r?\n`)
+var pdfFilterRe = regexp.MustCompile(`/Filter\b`)
+
+// Deflated members of at least this size saving less than the ratio are
+// incompressible, members above the excessive ratio are likely zip bombs
+const (
+	minIncompressibleSize = 1024
+	incompressibleRatio   = 1.05
+	excessiveRatio        = 100
+	minExcessiveSize      = 1024 * 1024
+)
+
+// Member extensions of data compressed by its own format
+var compressedMemberExtensions = map[string]bool{
+	"jpg":   true,
+	"jpeg":  true,
+	"png":   true,
+	"gif":   true,
+	"webp":  true,
+	"zip":   true,
+	"gz":    true,
+	"7z":    true,
+	"rar":   true,
+	"mp3":   true,
+	"mp4":   true,
+	"odttf": true,
+}
+
+// Return the entropy analysis of a file
+func (file *File) GetEntropy() *EntropyAnalysis {
+	return file.fileEntropy
+}
+
+// Compute the overall entropy, the window profile with its high entropy
+// regions and the compression of zip members
+func analyzeEntropy(file *File) *EntropyAnalysis {
+	data := file.fileBytes
+	analysis := &EntropyAnalysis{Entropy: shannonEntropy(data)}
+	if len(data) >= minEntropySize {
+		analysis.WindowSize = minEntropyWindow
+		if len(data)/entropyWindows > analysis.WindowSize {
+			analysis.WindowSize = (len(data)/entropyWindows + minEntropyWindow - 1) / minEntropyWindow * minEntropyWindow
+		}
+		if analysis.WindowSize > len(data) {
+			analysis.WindowSize = len(data)
+		}
+		analysis.WindowStep = analysis.WindowSize / 2
+		regionStart, regionEnd := -1, -1
+		spans, kind := compressedSpans(data)
+		for _, offset := range windowOffsets(len(data), analysis.WindowSize, analysis.WindowStep) {
+			entropy := shannonEntropy(data[offset : offset+analysis.WindowSize])
+			analysis.Profile = append(analysis.Profile, entropy)
+			if entropy > analysis.MaxEntropy {
+				analysis.MaxEntropy = entropy
+			}
+			if entropy < highEntropyThreshold {
+				continue
+			}
+			// Overlapping or adjacent high windows extend the current region
+			if regionStart >= 0 && offset <= regionEnd {
+				regionEnd = offset + analysis.WindowSize
+				continue
+			}
+			analysis.addRegion(data, regionStart, regionEnd, spans, kind)
+			regionStart, regionEnd = offset, offset+analysis.WindowSize
+		}
+		analysis.addRegion(data, regionStart, regionEnd, spans, kind)
+	}
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		analysis.Members = zipMemberRatios(data)
+	}
+	return analysis
+}
+
+// Return the window offsets of a profile, a last window ends at the end of
+// the data when the step leaves its tail uncovered
+func windowOffsets(size int, windowSize int, windowStep int) []int {
+	var offsets []int
+	offset := 0
+	for ; offset+windowSize <= size; offset += windowStep {
+		offsets = append(offsets, offset)
+	}
+	if last := offsets[len(offsets)-1]; last+windowSize < size {
+		offsets = append(offsets, size-windowSize)
+	}
+	return offsets
+}
+
+// Add a high entropy region, nothing when start is negative, labeled with
+// the kind of compressed data covering most of it
+func (analysis *EntropyAnalysis) addRegion(data []byte, start int, end int, spans [][2]int, kind string) {
+	if start < 0 {
+		return
+	}
+	region := EntropyRegion{
+		Offset:  start,
+		Size:    end - start,
+		Entropy: shannonEntropy(data[start:end]),
+	}
+	covered := 0
+	for _, span := range spans {
+		spanStart, spanEnd := span[0], span[1]
+		if spanStart < start {
+			spanStart = start
+		}
+		if spanEnd > end {
+			spanEnd = end
+		}
+		if spanStart < spanEnd {
+			covered += spanEnd - spanStart
+		}
+	}
+	if float64(covered) >= minCompressedCoverage*float64(region.Size) {
+		region.Compressed = kind
+	}
+	analysis.HighRegions = append(analysis.HighRegions, region)
+}
+
+// Return the spans of data compressed by the format of a file and their kind
+func compressedSpans(data []byte) ([][2]int, string) {
+	header := data
+	if len(header) > 1024 {
+		header = header[:1024]
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return zipMemberSpans(data), CompressedZipMember
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return jpegScanSpans(data), CompressedJpegScan
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return pngDataSpans(data), CompressedPngData
+	case bytes.Contains(header, []byte("%PDF-")):
+		return pdfStreamSpans(data), CompressedPdfStream
+	}
+	return nil, ""
+}
+
+// Return the data spans of PDF streams with a filter in their dictionary
+func pdfStreamSpans(data []byte) [][2]int {
+	var spans [][2]int
+	for _, match := range pdfStreamRe.FindAllIndex(data, -1) {
+		// Skip the stream keyword ending endstream
+		if match[0] >= 3 && string(data[match[0]-3:match[0]]) == "end" {
+			continue
+		}
+		dictionaryStart := bytes.LastIndex(data[:match[0]], []byte("obj"))
+		if dictionaryStart < 0 || !pdfFilterRe.Match(data[dictionaryStart:match[0]]) {
+			continue
+		}
+		end := bytes.Index(data[match[1]:], []byte("endstream"))
+		if end < 0 {
+			end = len(data) - match[1]
+		}
+		spans = append(spans, [2]int{match[1], match[1] + end})
+	}
+	return spans
+}
+
+// Return the data spans of deflated, encrypted or already compressed zip
+// members
+func zipMemberSpans(data []byte) [][2]int {
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil
+	}
+	var spans [][2]int
+	for _, zipFile := range zipReader.File {
+		extension := strings.ToLower(strings.TrimPrefix(path.Ext(zipFile.Name), "."))
+		if zipFile.Method == zip.Store && zipFile.Flags&0x1 == 0 && !compressedMemberExtensions[extension] {
+			continue
+		}
+		offset, err := zipFile.DataOffset()
+		if err != nil || offset < 0 || offset > int64(len(data)) {
+			continue
+		}
+		end := len(data)
+		if zipFile.CompressedSize64 < uint64(len(data))-uint64(offset) {
+			end = int(offset) + int(zipFile.CompressedSize64)
+		}
+		spans = append(spans, [2]int{int(offset), end})
+	}
+	return spans
+}
+
+// Return the span of JPEG entropy coded data, from the first start of scan
+// to the end of image
+func jpegScanSpans(data []byte) [][2]int {
+	for offset := 2; offset+4 <= len(data) && data[offset] == 0xff; {
+		marker := data[offset+1]
+		if marker == 0xff || marker == 0x01 || (marker >= 0xd0 && marker <= 0xd8) {
+			offset++
+			if marker != 0xff {
+				offset++
+			}
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		if length < 2 || offset+2+length > len(data) {
+			break
+		}
+		offset += 2 + length
+		if marker == 0xda {
+			end := bytes.LastIndex(data, []byte{0xff, 0xd9})
+			if end < offset {
+				end = len(data)
+			}
+			return [][2]int{{offset, end}}
+		}
+	}
+	return nil
+}
+
+// Return the data spans of PNG image data chunks
+func pngDataSpans(data []byte) [][2]int {
+	var spans [][2]int
+	for offset := 8; offset+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		if length < 0 || length > len(data)-offset-12 {
+			break
+		}
+		if string(data[offset+4:offset+8]) == "IDAT" {
+			spans = append(spans, [2]int{offset + 8, offset + 8 + length})
+		}
+		offset += 12 + length
+	}
+	return spans
+}
+
+// Return the compression of the members of a zip archive
+func zipMemberRatios(data []byte) []ArchiveMember {
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil
+	}
+	var members []ArchiveMember
+	for _, zipFile := range zipReader.File {
+		if strings.HasSuffix(zipFile.Name, "/") {
+			continue
+		}
+		member := ArchiveMember{
+			Name:             zipFile.Name,
+			Method:           zipFile.Method,
+			CompressedSize:   zipFile.CompressedSize64,
+			UncompressedSize: zipFile.UncompressedSize64,
+			Encrypted:        zipFile.Flags&0x1 != 0,
+		}
+		if member.CompressedSize > 0 {
+			member.Ratio = math.Round(float64(member.UncompressedSize)/float64(member.CompressedSize)*100) / 100
+		}
+		extension := strings.ToLower(strings.TrimPrefix(path.Ext(zipFile.Name), "."))
+		// Encrypted data never compresses, deflated members should unless
+		// their format is compressed already
+		if member.Method == zip.Deflate && !member.Encrypted && !compressedMemberExtensions[extension] &&
+			member.UncompressedSize >= minIncompressibleSize && member.Ratio < incompressibleRatio {
+			member.Incompressible = true
+		}
+		if member.Ratio > excessiveRatio && member.UncompressedSize >= minExcessiveSize {
+			member.Excessive = true
+		}
+		members = append(members, member)
+	}
+	return members
+}
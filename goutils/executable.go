@@ -0,0 +1,332 @@
+package goutils
+
+// Executable analysis of PE, ELF and Mach-O files
+// Architecture, sections with entropy, imports, exports and overlay data
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Struct of an analyzed executable
+type ExecutableInfo struct {
+	Format        string               `json:"format"`
+	Architecture  string               `json:"architecture"`
+	Bits          int                  `json:"bits"`
+	EntryPoint    uint64               `json:"entry_point"`
+	Timestamp     string               `json:"timestamp,omitempty"`
+	Sections      []ExecutableSection  `json:"sections,omitempty"`
+	Libraries     []string             `json:"libraries,omitempty"`
+	Imports       []string             `json:"imports,omitempty"`
+	Exports       []string             `json:"exports,omitempty"`
+	Imphash       string               `json:"imphash,omitempty"`
+	Resources     []ExecutableResource `json:"resources,omitempty"`
+	VersionInfo   map[string]string    `json:"version_info,omitempty"`
+	Manifest      string               `json:"manifest,omitempty"`
+	OverlayOffset int64                `json:"overlay_offset,omitempty"`
+	OverlaySize   int64                `json:"overlay_size,omitempty"`
+}
+
+// Struct of a section, Permissions is r, w and x or dashes
+type ExecutableSection struct {
+	Name        string  `json:"name"`
+	Address     uint64  `json:"address"`
+	VirtualSize uint64  `json:"virtual_size"`
+	Offset      uint64  `json:"offset"`
+	Size        uint64  `json:"size"`
+	Entropy     float64 `json:"entropy"`
+	Permissions string  `json:"permissions"`
+}
+
+// Struct of a PE resource leaf
+type ExecutableResource struct {
+	Type     string  `json:"type"`
+	Name     string  `json:"name"`
+	Language int     `json:"language"`
+	Offset   int64   `json:"offset"`
+	Size     int64   `json:"size"`
+	Entropy  float64 `json:"entropy"`
+}
+
+// Mach-O load command of the main entry point, not decoded by debug/macho
+const machoLoadCmdMain = 0x80000028
+
+// Mach-O symbol type bits
+const (
+	machoSymbolStab     = 0xe0
+	machoSymbolExternal = 0x01
+)
+
+// Return the analysis of an executable file
+func (file *File) GetExecutable() *ExecutableInfo {
+	return file.fileExecutable
+}
+
+// Analyze a PE, ELF or Mach-O executable, returning its printable strings
+// and the strings of its resources, the overlay becomes a child file
+func extractExecutable(file *File) ([]string, error) {
+	var executable *ExecutableInfo
+	var resourceStrings []string
+	var err error
+	switch file.fileExtension {
+	case "exe":
+		executable, resourceStrings, err = analyzePe(file)
+	case "elf":
+		executable, err = analyzeElf(file.fileBytes)
+	case "macho":
+		executable, err = analyzeMacho(file.fileBytes)
+	default:
+		err = fmt.Errorf("executable: unknown format %s", file.fileExtension)
+	}
+	// Truncated and malformed binaries still yield their strings
+	if err != nil {
+		return extractBinaryStrings(file)
+	}
+	file.fileExecutable = executable
+	if executable.OverlaySize > 0 {
+		overlay := file.fileBytes[executable.OverlayOffset:]
+		attributes := fileHashes(overlay)
+		attributes["source"] = "overlay"
+		attributes["offset"] = fmt.Sprint(executable.OverlayOffset)
+		file.addChild(overlay, "overlay.bin", attributes)
+	}
+	executableStrings, err := extractBinaryStrings(file)
+	if err != nil {
+		return nil, err
+	}
+	return append(executableStrings, resourceStrings...), nil
+}
+
+// Return the overlay offset and size given the end of the mapped data
+func overlayRange(fileSize int, end uint64) (int64, int64) {
+	if end == 0 || end >= uint64(fileSize) {
+		return 0, 0
+	}
+	return int64(end), int64(uint64(fileSize) - end)
+}
+
+// Return the Shannon entropy of bytes in bits per byte, 0 to 8
+func shannonEntropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, c := range data {
+		counts[c]++
+	}
+	entropy := 0.0
+	size := float64(len(data))
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / size
+			entropy -= p * math.Log2(p)
+		}
+	}
+	// Rounded so reports stay readable
+	return math.Round(entropy*1000) / 1000
+}
+
+// Return an r, w and x permission string
+func permissionString(read bool, write bool, execute bool) string {
+	permissions := []byte("---")
+	if read {
+		permissions[0] = 'r'
+	}
+	if write {
+		permissions[1] = 'w'
+	}
+	if execute {
+		permissions[2] = 'x'
+	}
+	return string(permissions)
+}
+
+// Analyze an ELF executable or shared object
+func analyzeElf(fileBytes []byte) (*ExecutableInfo, error) {
+	elfFile, err := elf.NewFile(bytes.NewReader(fileBytes))
+	if err != nil {
+		return nil, err
+	}
+	defer elfFile.Close()
+	executable := &ExecutableInfo{
+		Format:       "elf",
+		Architecture: strings.ToLower(strings.TrimPrefix(elfFile.Machine.String(), "EM_")),
+		Bits:         32,
+		EntryPoint:   elfFile.Entry,
+	}
+	if elfFile.Class == elf.ELFCLASS64 {
+		executable.Bits = 64
+	}
+	// The section header table usually ends the mapped data
+	var end uint64
+	for _, section := range elfFile.Sections {
+		if section.Type == elf.SHT_NULL {
+			continue
+		}
+		entry := ExecutableSection{
+			Name:        section.Name,
+			Address:     section.Addr,
+			VirtualSize: section.Size,
+			Offset:      section.Offset,
+			Permissions: permissionString(section.Flags&elf.SHF_ALLOC != 0, section.Flags&elf.SHF_WRITE != 0, section.Flags&elf.SHF_EXECINSTR != 0),
+		}
+		if section.Type != elf.SHT_NOBITS {
+			entry.Size = section.FileSize
+			if data, err := section.Data(); err == nil {
+				entry.Entropy = shannonEntropy(data)
+			}
+			if section.Offset+section.FileSize > end {
+				end = section.Offset + section.FileSize
+			}
+		}
+		executable.Sections = append(executable.Sections, entry)
+	}
+	for _, program := range elfFile.Progs {
+		if program.Off+program.Filesz > end {
+			end = program.Off + program.Filesz
+		}
+	}
+	// Header fields are read raw, debug/elf does not expose the table offsets
+	var sectionTableEnd uint64
+	if executable.Bits == 64 && len(fileBytes) >= 64 {
+		sectionTableEnd = elfFile.ByteOrder.Uint64(fileBytes[40:48]) + uint64(elfFile.ByteOrder.Uint16(fileBytes[58:60]))*uint64(elfFile.ByteOrder.Uint16(fileBytes[60:62]))
+	} else if len(fileBytes) >= 52 {
+		sectionTableEnd = uint64(elfFile.ByteOrder.Uint32(fileBytes[32:36])) + uint64(elfFile.ByteOrder.Uint16(fileBytes[46:48]))*uint64(elfFile.ByteOrder.Uint16(fileBytes[48:50]))
+	}
+	if sectionTableEnd > end {
+		end = sectionTableEnd
+	}
+	executable.OverlayOffset, executable.OverlaySize = overlayRange(len(fileBytes), end)
+	executable.Libraries, _ = elfFile.ImportedLibraries()
+	if symbols, err := elfFile.ImportedSymbols(); err == nil {
+		for _, symbol := range symbols {
+			name := symbol.Name
+			if len(symbol.Library) > 0 {
+				name = symbol.Library + "!" + name
+			}
+			executable.Imports = append(executable.Imports, name)
+		}
+	}
+	if symbols, err := elfFile.DynamicSymbols(); err == nil {
+		for _, symbol := range symbols {
+			binding := elf.ST_BIND(symbol.Info)
+			symbolType := elf.ST_TYPE(symbol.Info)
+			if symbol.Section == elf.SHN_UNDEF || (binding != elf.STB_GLOBAL && binding != elf.STB_WEAK) {
+				continue
+			}
+			if symbolType == elf.STT_FUNC || symbolType == elf.STT_OBJECT {
+				executable.Exports = append(executable.Exports, symbol.Name)
+			}
+		}
+	}
+	return executable, nil
+}
+
+// Analyze a Mach-O executable, universal binaries report their first
+// architecture and list all of them
+func analyzeMacho(fileBytes []byte) (*ExecutableInfo, error) {
+	machoFile, err := macho.NewFile(bytes.NewReader(fileBytes))
+	architectures := ""
+	if err != nil {
+		fatFile, fatErr := macho.NewFatFile(bytes.NewReader(fileBytes))
+		if fatErr != nil {
+			return nil, err
+		}
+		defer fatFile.Close()
+		if len(fatFile.Arches) == 0 {
+			return nil, fmt.Errorf("macho: universal binary without architectures")
+		}
+		var names []string
+		for _, arch := range fatFile.Arches {
+			names = append(names, machoCpuName(arch.Cpu))
+		}
+		architectures = strings.Join(names, ",")
+		machoFile = fatFile.Arches[0].File
+	} else {
+		defer machoFile.Close()
+	}
+	executable := &ExecutableInfo{
+		Format:       "macho",
+		Architecture: machoCpuName(machoFile.Cpu),
+		Bits:         32,
+	}
+	if len(architectures) > 0 {
+		executable.Architecture = architectures
+	}
+	if machoFile.Magic == macho.Magic64 {
+		executable.Bits = 64
+	}
+	var end uint64
+	for _, load := range machoFile.Loads {
+		switch command := load.(type) {
+		case *macho.Segment:
+			if command.Offset+command.Filesz > end {
+				end = command.Offset + command.Filesz
+			}
+		case macho.LoadBytes:
+			raw := command.Raw()
+			if len(raw) >= 16 && machoFile.ByteOrder.Uint32(raw[0:4]) == machoLoadCmdMain {
+				executable.EntryPoint = machoFile.ByteOrder.Uint64(raw[8:16])
+			}
+		}
+	}
+	for _, section := range machoFile.Sections {
+		entry := ExecutableSection{
+			Name:        section.Seg + "," + section.Name,
+			Address:     section.Addr,
+			VirtualSize: section.Size,
+			Offset:      uint64(section.Offset),
+		}
+		if segment := machoFile.Segment(section.Seg); segment != nil {
+			entry.Permissions = permissionString(segment.Prot&1 != 0, segment.Prot&2 != 0, segment.Prot&4 != 0)
+		}
+		// Zero fill sections have no file data
+		if section.Offset > 0 {
+			entry.Size = section.Size
+			if data, err := section.Data(); err == nil {
+				entry.Entropy = shannonEntropy(data)
+			}
+		}
+		executable.Sections = append(executable.Sections, entry)
+	}
+	// Slices of a universal binary are not followed by an overlay of their own
+	if len(architectures) == 0 {
+		executable.OverlayOffset, executable.OverlaySize = overlayRange(len(fileBytes), end)
+	}
+	executable.Libraries, _ = machoFile.ImportedLibraries()
+	executable.Imports, _ = machoFile.ImportedSymbols()
+	if machoFile.Symtab != nil {
+		for _, symbol := range machoFile.Symtab.Syms {
+			if symbol.Type&machoSymbolStab == 0 && symbol.Type&machoSymbolExternal != 0 && symbol.Sect != 0 {
+				executable.Exports = append(executable.Exports, symbol.Name)
+			}
+		}
+	}
+	return executable, nil
+}
+
+// Return the lower case name of a Mach-O cpu type
+func machoCpuName(cpu macho.Cpu) string {
+	return strings.ToLower(strings.TrimPrefix(cpu.String(), "Cpu"))
+}
+
+// Return a little endian uint16 at an offset, 0 when out of range
+func readUint16(data []byte, offset int) uint16 {
+	if offset < 0 || offset+2 > len(data) {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(data[offset:])
+}
+
+// Return a little endian uint32 at an offset, 0 when out of range
+func readUint32(data []byte, offset int) uint32 {
+	if offset < 0 || offset+4 > len(data) {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(data[offset:])
+}
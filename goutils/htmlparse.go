@@ -0,0 +1,323 @@
+package goutils
+
+// HTML parsing with golang.org/x/net/html
+// Visible text, links resolved against <base>, forms with their fields,
+// inline scripts and event handlers, comments and data attributes
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Struct of a parsed HTML document
+type HtmlDocument struct {
+	Title          string          `json:"title,omitempty"`
+	Base           string          `json:"base,omitempty"`
+	Text           string          `json:"text"`
+	Links          []HtmlLink      `json:"links,omitempty"`
+	Forms          []HtmlForm      `json:"forms,omitempty"`
+	Scripts        []HtmlScript    `json:"scripts,omitempty"`
+	Handlers       []HtmlAttribute `json:"handlers,omitempty"`
+	DataAttributes []HtmlAttribute `json:"data_attributes,omitempty"`
+	Comments       []string        `json:"comments,omitempty"`
+}
+
+// Struct of a link-bearing attribute, Url is resolved against the base
+type HtmlLink struct {
+	Tag       string `json:"tag"`
+	Attribute string `json:"attribute"`
+	Raw       string `json:"raw"`
+	Url       string `json:"url"`
+}
+
+// Struct of a form, its resolved action target and its fields
+type HtmlForm struct {
+	Action      string          `json:"action"`
+	Method      string          `json:"method"`
+	HasPassword bool            `json:"has_password"`
+	Fields      []HtmlFormField `json:"fields,omitempty"`
+}
+
+// Struct of a form input, select, textarea or button
+type HtmlFormField struct {
+	Tag   string `json:"tag"`
+	Name  string `json:"name,omitempty"`
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// Struct of a script element, Body is empty for external scripts
+type HtmlScript struct {
+	Src  string `json:"src,omitempty"`
+	Type string `json:"type,omitempty"`
+	Body string `json:"body,omitempty"`
+}
+
+// Struct of an element attribute kept for its value
+type HtmlAttribute struct {
+	Tag   string `json:"tag"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Attributes holding a single url
+var htmlLinkAttributes = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"action":     true,
+	"formaction": true,
+	"data":       true,
+	"poster":     true,
+	"background": true,
+	"cite":       true,
+}
+
+// Elements whose text is not rendered
+var htmlHiddenElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Template: true,
+	atom.Head:     true,
+}
+
+var cssUrlRe = regexp.MustCompile(`(?i)url\(\s*['"]?([^'")\s]+)['"]?\s*\)`)
+var metaRefreshUrlRe = regexp.MustCompile(`(?i)url\s*=\s*['"]?([^'"\s]+)`)
+
+// Return the parsed HTML document of an HTML file
+func (file *File) GetHtml() *HtmlDocument {
+	return file.fileHtml
+}
+
+// Parse HTML into visible text, links, forms and scripts
+func ParseHtml(htmlBytes []byte) (*HtmlDocument, error) {
+	root, err := html.Parse(bytes.NewReader(htmlBytes))
+	if err != nil {
+		return nil, err
+	}
+	document := &HtmlDocument{}
+	// The base url applies to every link in the document, wherever it appears
+	var findBase func(node *html.Node)
+	findBase = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.DataAtom == atom.Base && len(document.Base) == 0 {
+			document.Base = strings.TrimSpace(htmlAttribute(node, "href"))
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			findBase(child)
+		}
+	}
+	findBase(root)
+	baseUrl, _ := url.Parse(document.Base)
+	var text []string
+	// Forms cannot nest, fields belong to the form being walked, -1 outside forms
+	var walk func(node *html.Node, form int, hidden bool)
+	walk = func(node *html.Node, form int, hidden bool) {
+		switch node.Type {
+		case html.TextNode:
+			if !hidden {
+				if str := strings.Join(strings.Fields(node.Data), " "); len(str) > 0 {
+					text = append(text, str)
+				}
+			}
+		case html.CommentNode:
+			if comment := strings.TrimSpace(node.Data); len(comment) > 0 {
+				document.Comments = append(document.Comments, comment)
+			}
+		case html.ElementNode:
+			document.addLinks(node, baseUrl)
+			document.addAttributes(node)
+			switch node.DataAtom {
+			case atom.Title:
+				if len(document.Title) == 0 {
+					document.Title = strings.TrimSpace(htmlNodeText(node))
+				}
+			case atom.Script:
+				document.Scripts = append(document.Scripts, HtmlScript{
+					Src:  resolveHtmlUrl(baseUrl, htmlAttribute(node, "src")),
+					Type: htmlAttribute(node, "type"),
+					Body: strings.TrimSpace(htmlNodeText(node)),
+				})
+			case atom.Style:
+				document.addCssLinks(node.Data, htmlNodeText(node), baseUrl)
+			case atom.Form:
+				method := strings.ToUpper(htmlAttribute(node, "method"))
+				if len(method) == 0 {
+					method = "GET"
+				}
+				document.Forms = append(document.Forms, HtmlForm{
+					Action: resolveHtmlUrl(baseUrl, htmlAttribute(node, "action")),
+					Method: method,
+				})
+				form = len(document.Forms) - 1
+			case atom.Input, atom.Select, atom.Textarea, atom.Button:
+				if form >= 0 {
+					field := HtmlFormField{
+						Tag:   node.Data,
+						Name:  htmlAttribute(node, "name"),
+						Type:  strings.ToLower(htmlAttribute(node, "type")),
+						Value: htmlAttribute(node, "value"),
+					}
+					if field.Type == "password" {
+						document.Forms[form].HasPassword = true
+					}
+					document.Forms[form].Fields = append(document.Forms[form].Fields, field)
+				}
+			}
+			hidden = hidden || htmlHiddenElements[node.DataAtom]
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child, form, hidden)
+		}
+	}
+	walk(root, -1, false)
+	document.Text = strings.Join(text, "\n")
+	return document, nil
+}
+
+// Record the link-bearing attributes of an element
+func (document *HtmlDocument) addLinks(node *html.Node, baseUrl *url.URL) {
+	for _, attribute := range node.Attr {
+		key := strings.ToLower(attribute.Key)
+		value := strings.TrimSpace(attribute.Val)
+		switch {
+		case htmlLinkAttributes[key] && len(value) > 0:
+			// The base element itself is not resolved against the base
+			if node.DataAtom == atom.Base {
+				document.addLink(node.Data, key, value, value)
+				continue
+			}
+			document.addLink(node.Data, key, value, resolveHtmlUrl(baseUrl, value))
+		case key == "srcset":
+			for _, candidate := range strings.Split(value, ",") {
+				fields := strings.Fields(candidate)
+				if len(fields) > 0 {
+					document.addLink(node.Data, key, fields[0], resolveHtmlUrl(baseUrl, fields[0]))
+				}
+			}
+		case key == "style":
+			document.addCssLinks(node.Data, value, baseUrl)
+		case key == "content" && strings.EqualFold(htmlAttribute(node, "http-equiv"), "refresh"):
+			if match := metaRefreshUrlRe.FindStringSubmatch(value); match != nil {
+				document.addLink(node.Data, "refresh", match[1], resolveHtmlUrl(baseUrl, match[1]))
+			}
+		}
+	}
+}
+
+// Record the event handler and data attributes of an element
+func (document *HtmlDocument) addAttributes(node *html.Node) {
+	for _, attribute := range node.Attr {
+		key := strings.ToLower(attribute.Key)
+		value := strings.TrimSpace(attribute.Val)
+		if len(value) == 0 {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(key, "on") && len(key) > 2:
+			document.Handlers = append(document.Handlers, HtmlAttribute{Tag: node.Data, Name: key, Value: value})
+		case strings.HasPrefix(key, "data-"):
+			document.DataAttributes = append(document.DataAttributes, HtmlAttribute{Tag: node.Data, Name: key, Value: value})
+		}
+	}
+}
+
+// Record url() references in CSS
+func (document *HtmlDocument) addCssLinks(tag string, css string, baseUrl *url.URL) {
+	for _, match := range cssUrlRe.FindAllStringSubmatch(css, -1) {
+		document.addLink(tag, "url()", match[1], resolveHtmlUrl(baseUrl, match[1]))
+	}
+}
+
+// Record a link with its raw and resolved url
+func (document *HtmlDocument) addLink(tag string, attribute string, raw string, resolved string) {
+	document.Links = append(document.Links, HtmlLink{Tag: tag, Attribute: attribute, Raw: raw, Url: resolved})
+}
+
+// Resolve a reference against the base url, the reference itself when
+// there is no base or it does not parse
+func resolveHtmlUrl(baseUrl *url.URL, reference string) string {
+	reference = strings.TrimSpace(reference)
+	if baseUrl == nil || len(baseUrl.Host) == 0 || len(reference) == 0 {
+		return reference
+	}
+	referenceUrl, err := url.Parse(reference)
+	if err != nil {
+		return reference
+	}
+	return baseUrl.ResolveReference(referenceUrl).String()
+}
+
+// Return the value of an attribute, empty when it is not set
+func htmlAttribute(node *html.Node, key string) string {
+	for _, attribute := range node.Attr {
+		if strings.EqualFold(attribute.Key, key) {
+			return attribute.Val
+		}
+	}
+	return ""
+}
+
+// Return the concatenated text nodes under a node
+func htmlNodeText(node *html.Node) string {
+	var text strings.Builder
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.TextNode {
+			text.WriteString(node.Data)
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+	return text.String()
+}
+
+// Extract visible text, resolved links, inline scripts and event handlers,
+// comments, data attributes and form field names and values from HTML,
+// embedded data URI images become child files
+func extractHtmlStrings(file *File) ([]string, error) {
+	extractDataUriImages(file)
+	document, err := ParseHtml(textBytes(file))
+	if err != nil {
+		return extractText(file)
+	}
+	file.fileHtml = document
+	var htmlStrings []string
+	if len(document.Title) > 0 {
+		htmlStrings = append(htmlStrings, document.Title)
+	}
+	if len(document.Text) > 0 {
+		htmlStrings = append(htmlStrings, document.Text)
+	}
+	for _, link := range document.Links {
+		htmlStrings = append(htmlStrings, link.Url)
+	}
+	for _, script := range document.Scripts {
+		if len(script.Body) > 0 {
+			htmlStrings = append(htmlStrings, script.Body)
+		}
+	}
+	for _, handler := range document.Handlers {
+		htmlStrings = append(htmlStrings, handler.Value)
+	}
+	htmlStrings = append(htmlStrings, document.Comments...)
+	for _, attribute := range document.DataAttributes {
+		htmlStrings = append(htmlStrings, attribute.Value)
+	}
+	// Hidden inputs carry victim addresses and tokens that are never rendered
+	for _, form := range document.Forms {
+		for _, field := range form.Fields {
+			for _, str := range []string{field.Name, field.Value} {
+				if len(strings.TrimSpace(str)) > 0 {
+					htmlStrings = append(htmlStrings, str)
+				}
+			}
+		}
+	}
+	return htmlStrings, nil
+}
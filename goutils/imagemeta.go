@@ -0,0 +1,818 @@
+package goutils
+
+// Image metadata extraction
+// EXIF (with GPS), IPTC and XMP from JPEG, PNG, TIFF, WebP and HEIF images
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Struct of the metadata of an image
+type ImageMetadata struct {
+	Format            string            `json:"format"`
+	Width             int               `json:"width,omitempty"`
+	Height            int               `json:"height,omitempty"`
+	Make              string            `json:"make,omitempty"`
+	Model             string            `json:"model,omitempty"`
+	SerialNumber      string            `json:"serial_number,omitempty"`
+	Software          string            `json:"software,omitempty"`
+	Artist            string            `json:"artist,omitempty"`
+	Copyright         string            `json:"copyright,omitempty"`
+	DateTime          string            `json:"date_time,omitempty"`
+	DateTimeOriginal  string            `json:"date_time_original,omitempty"`
+	DateTimeDigitized string            `json:"date_time_digitized,omitempty"`
+	Gps               *ImageGps         `json:"gps,omitempty"`
+	Exif              map[string]string `json:"exif,omitempty"`
+	Iptc              map[string]string `json:"iptc,omitempty"`
+	Xmp               string            `json:"xmp,omitempty"`
+	Comments          []string          `json:"comments,omitempty"`
+}
+
+// Struct of GPS coordinates in decimal degrees, altitude in meters
+type ImageGps struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
+	Timestamp string  `json:"timestamp,omitempty"`
+}
+
+// TIFF tags pointing at sub IFDs and embedded metadata
+const (
+	tiffTagExifIfd         = 0x8769
+	tiffTagGpsIfd          = 0x8825
+	tiffTagIptc            = 0x83bb
+	tiffTagXmp             = 0x02bc
+	tiffTagThumbnailOffset = 0x0201
+	tiffTagThumbnailLength = 0x0202
+)
+
+// Byte sizes of TIFF field types
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// Names of the EXIF tags kept in the metadata
+var exifTagNames = map[uint16]string{
+	0x010e: "ImageDescription",
+	0x010f: "Make",
+	0x0110: "Model",
+	0x0112: "Orientation",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013b: "Artist",
+	0x8298: "Copyright",
+	0x9c9b: "XPTitle",
+	0x9c9c: "XPComment",
+	0x9c9d: "XPAuthor",
+	0x9c9e: "XPKeywords",
+	0x9c9f: "XPSubject",
+	0x829a: "ExposureTime",
+	0x829d: "FNumber",
+	0x8827: "ISOSpeedRatings",
+	0x9000: "ExifVersion",
+	0x9003: "DateTimeOriginal",
+	0x9004: "DateTimeDigitized",
+	0x9010: "OffsetTime",
+	0x9011: "OffsetTimeOriginal",
+	0x920a: "FocalLength",
+	0x9286: "UserComment",
+	0xa002: "PixelXDimension",
+	0xa003: "PixelYDimension",
+	0xa420: "ImageUniqueID",
+	0xa430: "CameraOwnerName",
+	0xa431: "BodySerialNumber",
+	0xa433: "LensMake",
+	0xa434: "LensModel",
+	0xa435: "LensSerialNumber",
+}
+
+// Names of the GPS tags kept in the metadata
+var gpsTagNames = map[uint16]string{
+	0x0001: "GPSLatitudeRef",
+	0x0002: "GPSLatitude",
+	0x0003: "GPSLongitudeRef",
+	0x0004: "GPSLongitude",
+	0x0005: "GPSAltitudeRef",
+	0x0006: "GPSAltitude",
+	0x0007: "GPSTimeStamp",
+	0x0011: "GPSImgDirection",
+	0x0012: "GPSMapDatum",
+	0x001d: "GPSDateStamp",
+}
+
+// Names of the IPTC application record datasets
+var iptcDatasetNames = map[byte]string{
+	5:   "ObjectName",
+	25:  "Keywords",
+	55:  "DateCreated",
+	60:  "TimeCreated",
+	80:  "Byline",
+	85:  "BylineTitle",
+	90:  "City",
+	92:  "Sublocation",
+	95:  "ProvinceState",
+	100: "CountryCode",
+	101: "Country",
+	105: "Headline",
+	110: "Credit",
+	115: "Source",
+	116: "CopyrightNotice",
+	120: "Caption",
+	122: "Writer",
+}
+
+// Limits on the walked structures of malformed images
+const (
+	maxTiffIfds       = 16
+	maxTiffEntries    = 1024
+	maxImageSegments  = 4096
+	maxTiffValueBytes = 65536
+)
+
+// Return the metadata of an image file
+func (file *File) GetImageMetadata() *ImageMetadata {
+	return file.fileImage
+}
+
+// Extract EXIF, IPTC and XMP metadata of an image, followed by its
+// printable strings, the EXIF thumbnail becomes a child file
+func extractImage(file *File) ([]string, error) {
+	metadata := &ImageMetadata{Format: file.fileExtension}
+	if imageConfig, _, err := image.DecodeConfig(bytes.NewReader(file.fileBytes)); err == nil {
+		metadata.Width = imageConfig.Width
+		metadata.Height = imageConfig.Height
+	}
+	var tiff []byte
+	switch file.fileExtension {
+	case "jpg":
+		tiff = metadata.parseJpeg(file.fileBytes)
+	case "png":
+		tiff = metadata.parsePng(file.fileBytes)
+	case "tif", "cr2":
+		tiff = file.fileBytes
+	case "webp":
+		tiff = metadata.parseWebp(file.fileBytes)
+	case "heif":
+		tiff = metadata.parseHeif(file.fileBytes)
+	}
+	if len(tiff) > 0 {
+		thumbnail := metadata.parseTiff(tiff)
+		if len(thumbnail) > 0 {
+			addImageChild(file, thumbnail, "exif_thumbnail.jpg", "exif_thumbnail")
+		}
+	}
+	file.fileImage = metadata
+	imageStrings := metadata.strings()
+	binaryStrings, err := extractBinaryStrings(file)
+	if err != nil {
+		return nil, err
+	}
+	return append(imageStrings, binaryStrings...), nil
+}
+
+// Return the metadata values as strings, in a stable order
+func (metadata *ImageMetadata) strings() []string {
+	var metadataStrings []string
+	for _, values := range []map[string]string{metadata.Exif, metadata.Iptc} {
+		var keys []string
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			metadataStrings = append(metadataStrings, key+": "+values[key])
+		}
+	}
+	metadataStrings = append(metadataStrings, metadata.Comments...)
+	if len(metadata.Xmp) > 0 {
+		metadataStrings = append(metadataStrings, metadata.Xmp)
+	}
+	return metadataStrings
+}
+
+// Walk the segments of a JPEG, returning the EXIF TIFF data
+func (metadata *ImageMetadata) parseJpeg(data []byte) []byte {
+	var tiff []byte
+	for offset, segments := 2, 0; offset+4 <= len(data) && segments < maxImageSegments; segments++ {
+		if data[offset] != 0xff {
+			break
+		}
+		marker := data[offset+1]
+		// Fill bytes and markers without a length
+		if marker == 0xff || marker == 0x01 || (marker >= 0xd0 && marker <= 0xd8) {
+			offset++
+			if marker != 0xff {
+				offset++
+			}
+			continue
+		}
+		// Entropy coded data follows the start of scan
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		segment := data[offset+4 : end]
+		switch {
+		case marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) && tiff == nil:
+			tiff = segment[6:]
+		case marker == 0xe1 && bytes.HasPrefix(segment, []byte("http://ns.adobe.com/xap/1.0/\x00")):
+			metadata.Xmp = string(segment[len("http://ns.adobe.com/xap/1.0/\x00"):])
+		case marker == 0xed && bytes.HasPrefix(segment, []byte("Photoshop 3.0\x00")):
+			metadata.parsePhotoshop(segment[len("Photoshop 3.0\x00"):])
+		case marker == 0xfe:
+			metadata.Comments = append(metadata.Comments, strings.TrimRight(string(segment), "\x00"))
+		}
+		offset = end
+	}
+	return tiff
+}
+
+// Walk the chunks of a PNG, returning the EXIF TIFF data, text chunks
+// become comments
+func (metadata *ImageMetadata) parsePng(data []byte) []byte {
+	var tiff []byte
+	for offset, chunks := 8, 0; offset+12 <= len(data) && chunks < maxImageSegments; chunks++ {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		chunkType := string(data[offset+4 : offset+8])
+		end := offset + 12 + length
+		if length < 0 || end > len(data) || end < offset {
+			break
+		}
+		chunk := data[offset+8 : offset+8+length]
+		offset = end
+		switch chunkType {
+		case "eXIf":
+			tiff = chunk
+			continue
+		case "IEND":
+			return tiff
+		case "tEXt", "zTXt", "iTXt":
+		default:
+			continue
+		}
+		separator := bytes.IndexByte(chunk, 0)
+		if separator < 0 {
+			continue
+		}
+		keyword := string(chunk[:separator])
+		text, err := pngChunkText(chunkType, chunk[separator+1:])
+		if err != nil {
+			log.Printf("MODULE=extractImage OPERATION=pngChunkText CHUNK=%s ERROR=%s", chunkType, err)
+			continue
+		}
+		switch {
+		case keyword == "XML:com.adobe.xmp":
+			metadata.Xmp = text
+		case strings.HasPrefix(keyword, "Raw profile type exif") || strings.HasPrefix(keyword, "Raw profile type APP1"):
+			// ImageMagick stores EXIF as hex after a name and a length line
+			lines := strings.SplitN(strings.TrimLeft(text, "\n"), "\n", 3)
+			if len(lines) == 3 && tiff == nil {
+				raw, err := hex.DecodeString(strings.Join(strings.Fields(lines[2]), ""))
+				if err == nil {
+					tiff = bytes.TrimPrefix(raw, []byte("Exif\x00\x00"))
+				}
+			}
+		default:
+			metadata.Comments = append(metadata.Comments, keyword+": "+text)
+		}
+	}
+	return tiff
+}
+
+// Return the text of a PNG text chunk after its keyword
+func pngChunkText(chunkType string, data []byte) (string, error) {
+	compressed := false
+	switch chunkType {
+	case "zTXt":
+		if len(data) < 1 {
+			return "", fmt.Errorf("png: truncated zTXt")
+		}
+		data, compressed = data[1:], true
+	case "iTXt":
+		// Compression flag and method, then language and translated keyword
+		if len(data) < 2 {
+			return "", fmt.Errorf("png: truncated iTXt")
+		}
+		compressed = data[0] == 1
+		data = data[2:]
+		for i := 0; i < 2; i++ {
+			separator := bytes.IndexByte(data, 0)
+			if separator < 0 {
+				return "", fmt.Errorf("png: truncated iTXt")
+			}
+			data = data[separator+1:]
+		}
+	}
+	if compressed {
+		reader, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		data, err = ioutil.ReadAll(reader)
+		if err != nil {
+			return "", err
+		}
+	}
+	return string(data), nil
+}
+
+// Walk the chunks of a WebP RIFF container, returning the EXIF TIFF data
+func (metadata *ImageMetadata) parseWebp(data []byte) []byte {
+	var tiff []byte
+	for offset, chunks := 12, 0; offset+8 <= len(data) && chunks < maxImageSegments; chunks++ {
+		length := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		end := offset + 8 + length
+		if length < 0 || end > len(data) || end < offset {
+			break
+		}
+		chunk := data[offset+8 : end]
+		switch string(data[offset : offset+4]) {
+		case "EXIF":
+			tiff = bytes.TrimPrefix(chunk, []byte("Exif\x00\x00"))
+		case "XMP ":
+			metadata.Xmp = string(chunk)
+		}
+		// Chunks are padded to an even size
+		offset = end + length%2
+	}
+	return tiff
+}
+
+// Struct of an ISOBMFF box
+type isoBox struct {
+	boxType string
+	data    []byte
+}
+
+// Return the boxes of an ISOBMFF byte range
+func isoBoxes(data []byte) []isoBox {
+	var boxes []isoBox
+	for offset := 0; offset+8 <= len(data) && len(boxes) < maxImageSegments; {
+		size := uint64(binary.BigEndian.Uint32(data[offset:]))
+		header := uint64(8)
+		if size == 1 && offset+16 <= len(data) {
+			size, header = binary.BigEndian.Uint64(data[offset+8:]), 16
+		} else if size == 0 {
+			size = uint64(len(data) - offset)
+		}
+		// Compared against the remaining bytes, 64-bit sizes overflow a sum
+		if size < header || size > uint64(len(data)-offset) {
+			break
+		}
+		boxes = append(boxes, isoBox{boxType: string(data[offset+4 : offset+8]), data: data[uint64(offset)+header : uint64(offset)+size]})
+		offset += int(size)
+	}
+	return boxes
+}
+
+// Read a big endian unsigned integer of 0, 2, 4 or 8 bytes, advancing the offset
+func readBigEndian(data []byte, offset *int, size int) uint64 {
+	if *offset+size > len(data) {
+		*offset = len(data)
+		return 0
+	}
+	var value uint64
+	for i := 0; i < size; i++ {
+		value = value<<8 | uint64(data[*offset+i])
+	}
+	*offset += size
+	return value
+}
+
+// Find the Exif and XMP items of a HEIF meta box, returning the EXIF TIFF data
+func (metadata *ImageMetadata) parseHeif(data []byte) []byte {
+	var meta []byte
+	for _, box := range isoBoxes(data) {
+		if box.boxType == "meta" && len(box.data) > 4 {
+			meta = box.data[4:]
+		}
+	}
+	itemTypes := map[uint64]string{}
+	type extent struct{ offset, length uint64 }
+	itemExtents := map[uint64][]extent{}
+	for _, box := range isoBoxes(meta) {
+		if len(box.data) < 4 {
+			continue
+		}
+		version := box.data[0]
+		switch box.boxType {
+		case "iinf":
+			offset := 4
+			if version == 0 {
+				offset += 2
+			} else {
+				offset += 4
+			}
+			if offset > len(box.data) {
+				continue
+			}
+			for _, entry := range isoBoxes(box.data[offset:]) {
+				if entry.boxType != "infe" || len(entry.data) < 4 || entry.data[0] < 2 {
+					continue
+				}
+				position := 4
+				idSize := 2
+				if entry.data[0] >= 3 {
+					idSize = 4
+				}
+				id := readBigEndian(entry.data, &position, idSize)
+				position += 2
+				if position+4 > len(entry.data) {
+					continue
+				}
+				itemType := string(entry.data[position : position+4])
+				// Mime items name their content type after the item name
+				if itemType == "mime" {
+					fields := bytes.SplitN(entry.data[position+4:], []byte{0}, 3)
+					if len(fields) > 1 && bytes.Contains(fields[1], []byte("xml")) {
+						itemType = "xmp"
+					}
+				}
+				itemTypes[id] = itemType
+			}
+		case "iloc":
+			position := 4
+			sizes := readBigEndian(box.data, &position, 2)
+			offsetSize, lengthSize := int(sizes>>12), int(sizes>>8&0xf)
+			baseOffsetSize, indexSize := int(sizes>>4&0xf), int(sizes&0xf)
+			if version == 0 {
+				indexSize = 0
+			}
+			countSize, idSize := 2, 2
+			if version == 2 {
+				countSize, idSize = 4, 4
+			}
+			count := readBigEndian(box.data, &position, countSize)
+			for i := uint64(0); i < count && position < len(box.data); i++ {
+				id := readBigEndian(box.data, &position, idSize)
+				constructionMethod := uint64(0)
+				if version >= 1 {
+					constructionMethod = readBigEndian(box.data, &position, 2) & 0xf
+				}
+				position += 2
+				baseOffset := readBigEndian(box.data, &position, baseOffsetSize)
+				extents := readBigEndian(box.data, &position, 2)
+				for j := uint64(0); j < extents && position < len(box.data); j++ {
+					position += indexSize
+					extentOffset := readBigEndian(box.data, &position, offsetSize)
+					extentLength := readBigEndian(box.data, &position, lengthSize)
+					// Only items stored at file offsets are read
+					if constructionMethod == 0 {
+						itemExtents[id] = append(itemExtents[id], extent{baseOffset + extentOffset, extentLength})
+					}
+				}
+			}
+		}
+	}
+	var tiff []byte
+	for id, itemType := range itemTypes {
+		var item []byte
+		for _, itemExtent := range itemExtents[id] {
+			if itemExtent.offset > uint64(len(data)) || itemExtent.length > uint64(len(data))-itemExtent.offset {
+				break
+			}
+			item = append(item, data[itemExtent.offset:itemExtent.offset+itemExtent.length]...)
+		}
+		switch itemType {
+		case "Exif":
+			// Exif items start with the offset of the TIFF header
+			if len(item) >= 4 {
+				headerOffset := 4 + int(binary.BigEndian.Uint32(item))
+				if headerOffset < len(item) {
+					tiff = item[headerOffset:]
+				}
+			}
+		case "xmp":
+			metadata.Xmp = string(item)
+		}
+	}
+	return tiff
+}
+
+// Walk the Photoshop image resources of a JPEG APP13 segment for IPTC data
+func (metadata *ImageMetadata) parsePhotoshop(data []byte) {
+	for offset := 0; offset+12 <= len(data) && bytes.Equal(data[offset:offset+4], []byte("8BIM")); {
+		id := binary.BigEndian.Uint16(data[offset+4:])
+		// The name is a Pascal string padded to an even size
+		nameLength := int(data[offset+6])
+		position := offset + 6 + nameLength + 1 + (nameLength+1)%2
+		if position+4 > len(data) {
+			return
+		}
+		size := int(binary.BigEndian.Uint32(data[position:]))
+		position += 4
+		if size < 0 || position+size > len(data) {
+			return
+		}
+		if id == 0x0404 {
+			metadata.parseIptc(data[position : position+size])
+		}
+		offset = position + size + size%2
+	}
+}
+
+// Parse the application record of IPTC IIM data
+func (metadata *ImageMetadata) parseIptc(data []byte) {
+	for offset := 0; offset+5 <= len(data) && data[offset] == 0x1c; {
+		record, dataset := data[offset+1], data[offset+2]
+		length := int(binary.BigEndian.Uint16(data[offset+3:]))
+		offset += 5
+		// Extended datasets give the byte count of their length first
+		if length&0x8000 != 0 {
+			lengthSize := length & 0x7fff
+			length = int(readBigEndian(data, &offset, lengthSize))
+		}
+		if length < 0 || offset+length > len(data) {
+			return
+		}
+		value := strings.TrimSpace(string(data[offset : offset+length]))
+		offset += length
+		name, ok := iptcDatasetNames[dataset]
+		if record != 2 || !ok || len(value) == 0 {
+			continue
+		}
+		if metadata.Iptc == nil {
+			metadata.Iptc = map[string]string{}
+		}
+		// Repeatable datasets such as keywords are joined
+		if previous, found := metadata.Iptc[name]; found {
+			value = previous + "; " + value
+		}
+		metadata.Iptc[name] = value
+	}
+}
+
+// Reader of TIFF structures in their byte order
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// Struct of a TIFF IFD entry
+type tiffEntry struct {
+	tag       uint16
+	fieldType uint16
+	count     uint32
+	value     []byte
+}
+
+// Return the entries of the IFD at an offset and the offset of the next IFD
+func (reader *tiffReader) ifd(offset uint32) ([]tiffEntry, uint32) {
+	if uint64(offset)+2 > uint64(len(reader.data)) {
+		return nil, 0
+	}
+	count := int(reader.order.Uint16(reader.data[offset:]))
+	if count > maxTiffEntries {
+		return nil, 0
+	}
+	var entries []tiffEntry
+	position := int(offset) + 2
+	for i := 0; i < count && position+12 <= len(reader.data); i, position = i+1, position+12 {
+		entry := tiffEntry{
+			tag:       reader.order.Uint16(reader.data[position:]),
+			fieldType: reader.order.Uint16(reader.data[position+2:]),
+			count:     reader.order.Uint32(reader.data[position+4:]),
+		}
+		size := uint64(tiffTypeSizes[entry.fieldType]) * uint64(entry.count)
+		if size == 0 || size > maxTiffValueBytes {
+			continue
+		}
+		// Values of up to four bytes are stored in the entry itself
+		valueOffset := uint64(position + 8)
+		if size > 4 {
+			valueOffset = uint64(reader.order.Uint32(reader.data[position+8:]))
+		}
+		if valueOffset+size > uint64(len(reader.data)) {
+			continue
+		}
+		entry.value = reader.data[valueOffset : valueOffset+size]
+		entries = append(entries, entry)
+	}
+	if position+4 > len(reader.data) {
+		return entries, 0
+	}
+	return entries, reader.order.Uint32(reader.data[position:])
+}
+
+// Return the unsigned integers of a BYTE, SHORT or LONG entry
+func (reader *tiffReader) integers(entry tiffEntry) []uint64 {
+	var values []uint64
+	size := tiffTypeSizes[entry.fieldType]
+	for i := 0; i+size <= len(entry.value); i += size {
+		switch entry.fieldType {
+		case 1, 7:
+			values = append(values, uint64(entry.value[i]))
+		case 3:
+			values = append(values, uint64(reader.order.Uint16(entry.value[i:])))
+		case 4:
+			values = append(values, uint64(reader.order.Uint32(entry.value[i:])))
+		}
+	}
+	return values
+}
+
+// Return the numbers of a RATIONAL or SRATIONAL entry
+func (reader *tiffReader) rationals(entry tiffEntry) []float64 {
+	var values []float64
+	for i := 0; i+8 <= len(entry.value) && (entry.fieldType == 5 || entry.fieldType == 10); i += 8 {
+		numerator, denominator := float64(reader.order.Uint32(entry.value[i:])), float64(reader.order.Uint32(entry.value[i+4:]))
+		if entry.fieldType == 10 {
+			numerator, denominator = float64(int32(reader.order.Uint32(entry.value[i:]))), float64(int32(reader.order.Uint32(entry.value[i+4:])))
+		}
+		if denominator == 0 {
+			values = append(values, 0)
+			continue
+		}
+		values = append(values, numerator/denominator)
+	}
+	return values
+}
+
+// Return the value of an entry as a string
+func (reader *tiffReader) text(entry tiffEntry) string {
+	switch {
+	case entry.tag >= 0x9c9b && entry.tag <= 0x9c9f:
+		// Windows XP tags are UTF-16 little endian in BYTE entries
+		units := make([]uint16, len(entry.value)/2)
+		for i := range units {
+			units[i] = binary.LittleEndian.Uint16(entry.value[2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	case entry.tag == 0x9286 && len(entry.value) >= 8:
+		// User comments start with an eight byte character code
+		if bytes.HasPrefix(entry.value, []byte("UNICODE")) {
+			return strings.TrimRight(string(decodeUtf16(entry.value[8:], reader.order == binary.BigEndian)), "\x00 ")
+		}
+		return strings.TrimRight(string(entry.value[8:]), "\x00 ")
+	case entry.fieldType == 2 || entry.fieldType == 7:
+		return strings.TrimSpace(strings.TrimRight(string(entry.value), "\x00"))
+	case entry.fieldType == 5 || entry.fieldType == 10:
+		var values []string
+		for i, value := range reader.rationals(entry) {
+			// Exposure times read better as fractions
+			numerator, denominator := reader.order.Uint32(entry.value[8*i:]), reader.order.Uint32(entry.value[8*i+4:])
+			if entry.tag == 0x829a && numerator == 1 && denominator > 1 {
+				values = append(values, fmt.Sprintf("1/%d", denominator))
+				continue
+			}
+			values = append(values, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+		return strings.Join(values, " ")
+	}
+	var values []string
+	for _, value := range reader.integers(entry) {
+		values = append(values, strconv.FormatUint(value, 10))
+	}
+	return strings.Join(values, " ")
+}
+
+// Parse the IFDs of EXIF TIFF data into the metadata, returning the
+// JPEG thumbnail of IFD1
+func (metadata *ImageMetadata) parseTiff(data []byte) []byte {
+	reader := &tiffReader{data: data}
+	switch {
+	case len(data) < 8:
+		return nil
+	case bytes.HasPrefix(data, []byte("II*\x00")):
+		reader.order = binary.LittleEndian
+	case bytes.HasPrefix(data, []byte("MM\x00*")):
+		reader.order = binary.BigEndian
+	default:
+		return nil
+	}
+	if metadata.Exif == nil {
+		metadata.Exif = map[string]string{}
+	}
+	var thumbnail []byte
+	var gpsEntries []tiffEntry
+	visited := map[uint32]bool{}
+	var thumbnailIfd uint32
+	// IFD0 and IFD1 are chained, the EXIF and GPS IFDs hang off IFD0
+	queue := []uint32{reader.order.Uint32(data[4:])}
+	for index := 0; index < len(queue) && index < maxTiffIfds; index++ {
+		offset := queue[index]
+		if offset == 0 || visited[offset] {
+			continue
+		}
+		visited[offset] = true
+		entries, next := reader.ifd(offset)
+		var thumbnailOffset, thumbnailLength uint64
+		for _, entry := range entries {
+			switch entry.tag {
+			case tiffTagExifIfd:
+				if values := reader.integers(entry); len(values) > 0 {
+					queue = append(queue, uint32(values[0]))
+				}
+			case tiffTagGpsIfd:
+				if values := reader.integers(entry); len(values) > 0 {
+					gpsEntries, _ = reader.ifd(uint32(values[0]))
+				}
+			case tiffTagIptc:
+				metadata.parseIptc(entry.value)
+			case tiffTagXmp:
+				metadata.Xmp = string(entry.value)
+			case tiffTagThumbnailOffset:
+				if values := reader.integers(entry); len(values) > 0 {
+					thumbnailOffset = values[0]
+				}
+			case tiffTagThumbnailLength:
+				if values := reader.integers(entry); len(values) > 0 {
+					thumbnailLength = values[0]
+				}
+			default:
+				// IFD1 describes the thumbnail, its tags are not the image's
+				if name, ok := exifTagNames[entry.tag]; ok && offset != thumbnailIfd {
+					if value := reader.text(entry); len(value) > 0 {
+						metadata.Exif[name] = value
+					}
+				}
+			}
+		}
+		if thumbnailLength > 0 && thumbnailOffset <= uint64(len(data)) && thumbnailLength <= uint64(len(data))-thumbnailOffset {
+			thumbnail = data[thumbnailOffset : thumbnailOffset+thumbnailLength]
+		}
+		if index == 0 {
+			thumbnailIfd = next
+			queue = append(queue, next)
+		}
+	}
+	metadata.parseGps(reader, gpsEntries)
+	metadata.Make = metadata.Exif["Make"]
+	metadata.Model = metadata.Exif["Model"]
+	metadata.SerialNumber = metadata.Exif["BodySerialNumber"]
+	metadata.Software = metadata.Exif["Software"]
+	metadata.Artist = metadata.Exif["Artist"]
+	metadata.Copyright = metadata.Exif["Copyright"]
+	metadata.DateTime = metadata.Exif["DateTime"]
+	metadata.DateTimeOriginal = metadata.Exif["DateTimeOriginal"]
+	metadata.DateTimeDigitized = metadata.Exif["DateTimeDigitized"]
+	return thumbnail
+}
+
+// Convert the GPS IFD into decimal coordinates, the raw tags are kept in Exif
+func (metadata *ImageMetadata) parseGps(reader *tiffReader, entries []tiffEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	tags := map[string]tiffEntry{}
+	for _, entry := range entries {
+		if name, ok := gpsTagNames[entry.tag]; ok {
+			tags[name] = entry
+			if value := reader.text(entry); len(value) > 0 {
+				metadata.Exif[name] = value
+			}
+		}
+	}
+	latitude, latitudeOk := gpsDegrees(reader.rationals(tags["GPSLatitude"]))
+	longitude, longitudeOk := gpsDegrees(reader.rationals(tags["GPSLongitude"]))
+	if !latitudeOk || !longitudeOk {
+		return
+	}
+	if strings.HasPrefix(strings.ToUpper(reader.text(tags["GPSLatitudeRef"])), "S") {
+		latitude = -latitude
+	}
+	if strings.HasPrefix(strings.ToUpper(reader.text(tags["GPSLongitudeRef"])), "W") {
+		longitude = -longitude
+	}
+	gps := &ImageGps{Latitude: roundCoordinate(latitude), Longitude: roundCoordinate(longitude)}
+	if altitude := reader.rationals(tags["GPSAltitude"]); len(altitude) > 0 {
+		gps.Altitude = altitude[0]
+		if reference := reader.integers(tags["GPSAltitudeRef"]); len(reference) > 0 && reference[0] == 1 {
+			gps.Altitude = -gps.Altitude
+		}
+	}
+	// The GPS time is UTC, split over a date string and hour, minute, second rationals
+	date := strings.Replace(reader.text(tags["GPSDateStamp"]), ":", "-", 2)
+	if clock := reader.rationals(tags["GPSTimeStamp"]); len(date) == 10 && len(clock) == 3 {
+		gps.Timestamp = fmt.Sprintf("%sT%02d:%02d:%02dZ", date, int(clock[0]), int(clock[1]), int(clock[2]))
+	}
+	metadata.Gps = gps
+}
+
+// Convert degrees, minutes and seconds to decimal degrees
+func gpsDegrees(values []float64) (float64, bool) {
+	if len(values) != 3 {
+		return 0, false
+	}
+	return values[0] + values[1]/60 + values[2]/3600, true
+}
+
+// Round decimal degrees to seven places, about a centimeter
+func roundCoordinate(degrees float64) float64 {
+	return math.Round(degrees*1e7) / 1e7
+}
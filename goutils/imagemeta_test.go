@@ -0,0 +1,114 @@
+package goutils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// Return a minimal little endian TIFF with one ASCII Make entry
+func testTiff() []byte {
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	entries := make([]byte, 2+12+4)
+	binary.LittleEndian.PutUint16(entries, 1)
+	binary.LittleEndian.PutUint16(entries[2:], 0x010f)
+	binary.LittleEndian.PutUint16(entries[4:], 2)
+	binary.LittleEndian.PutUint32(entries[6:], 4)
+	copy(entries[10:], "Cam\x00")
+	return append(tiff, entries...)
+}
+
+// Return a JPEG with an APP1 Exif segment holding the TIFF data
+func testJpegExif(tiff []byte) []byte {
+	exif := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xff, 0xd8, 0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[4:], uint16(len(exif)+2))
+	return append(append(segment, exif...), 0xff, 0xd9)
+}
+
+// Return an ISOBMFF box
+func testBox(boxType string, payload []byte) []byte {
+	box := make([]byte, 8)
+	binary.BigEndian.PutUint32(box, uint32(len(payload)+8))
+	copy(box[4:], boxType)
+	return append(box, payload...)
+}
+
+func TestParseTiffExif(t *testing.T) {
+	metadata := &ImageMetadata{}
+	metadata.parseTiff(testTiff())
+	if metadata.Exif["Make"] != "Cam" {
+		t.Fatalf("Make = %q, want Cam", metadata.Exif["Make"])
+	}
+}
+
+func TestImageMetadataTruncated(t *testing.T) {
+	largeBox := make([]byte, 16)
+	binary.BigEndian.PutUint32(largeBox, 1)
+	copy(largeBox[4:], "free")
+	binary.BigEndian.PutUint64(largeBox[8:], 1<<64-4)
+	tests := []struct {
+		name  string
+		parse func(metadata *ImageMetadata, data []byte) []byte
+		data  []byte
+	}{
+		{"jpeg exif header only", (*ImageMetadata).parseJpeg, []byte("\xff\xd8\xff\xe1\x00\x0cExif\x00\x00II*\x00")},
+		{"jpeg segment past end", (*ImageMetadata).parseJpeg, []byte("\xff\xd8\xff\xe1\xff\xffExif")},
+		{"jpeg truncated exif", (*ImageMetadata).parseJpeg, testJpegExif(testTiff())[:20]},
+		{"tiff header only", (*ImageMetadata).parseTiff, []byte("II*\x00")},
+		{"tiff ifd past end", (*ImageMetadata).parseTiff, []byte("MM\x00*\xff\xff\xff\xf0")},
+		{"tiff truncated entries", (*ImageMetadata).parseTiff, testTiff()[:16]},
+		{"heif large box size", (*ImageMetadata).parseHeif, append(testBox("ftyp", []byte("heic")), largeBox...)},
+		{"heif truncated meta", (*ImageMetadata).parseHeif, testBox("meta", []byte{0, 0, 0, 0, 0, 0, 0, 40, 'i', 'l', 'o', 'c'})},
+		{"webp chunk past end", (*ImageMetadata).parseWebp, []byte("RIFF\x00\x00\x00\x00WEBPEXIF\xff\xff\xff\x7f")},
+		{"webp header only", (*ImageMetadata).parseWebp, []byte("RIFF\x00\x00\x00\x00WEBP")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata := &ImageMetadata{}
+			if tiff := test.parse(metadata, test.data); len(tiff) > 0 {
+				metadata.parseTiff(tiff)
+			}
+		})
+	}
+}
+
+func TestExtractImageTruncated(t *testing.T) {
+	var buffer bytes.Buffer
+	jpeg.Encode(&buffer, image.NewGray(image.Rect(0, 0, 4, 4)), nil)
+	valid := testJpegExif(testTiff())
+	for size := 4; size < len(valid); size++ {
+		file := LoadFile(valid[:size], "image.jpg")
+		file.Parse()
+	}
+	file := LoadFile(buffer.Bytes(), "image.jpg")
+	if _, err := file.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if file.GetImageMetadata() == nil || file.GetImageMetadata().Width != 4 {
+		t.Fatalf("metadata = %+v", file.GetImageMetadata())
+	}
+}
+
+func FuzzImageMetadata(f *testing.F) {
+	f.Add(testTiff())
+	f.Add(testJpegExif(testTiff()))
+	f.Add(append(testBox("ftyp", []byte("heic")), testBox("meta", make([]byte, 16))...))
+	f.Add([]byte("RIFF\x00\x00\x00\x00WEBPEXIF\x08\x00\x00\x00II*\x00\x08\x00\x00\x00"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, parse := range []func(metadata *ImageMetadata, data []byte) []byte{
+			(*ImageMetadata).parseJpeg,
+			(*ImageMetadata).parsePng,
+			(*ImageMetadata).parseWebp,
+			(*ImageMetadata).parseHeif,
+		} {
+			metadata := &ImageMetadata{}
+			if tiff := parse(metadata, data); len(tiff) > 0 {
+				metadata.parseTiff(tiff)
+			}
+		}
+		(&ImageMetadata{}).parseTiff(data)
+	})
+}
@@ -0,0 +1,251 @@
+package goutils
+
+// Embedded image extraction
+// PDF image XObjects, office document media folders and HTML data URIs
+// become child files with dimensions, format and hashes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/unidoc/unipdf/core"
+	"github.com/unidoc/unipdf/model"
+)
+
+var dataUriImageRe = regexp.MustCompile(`data:image/([a-zA-Z0-9.+-]+);base64,([A-Za-z0-9+/=\s]+)`)
+
+// Office document folders holding embedded media
+var officeMediaRe = regexp.MustCompile(`^(word|ppt|xl)/media/`)
+
+// Add an image as a child file with its dimensions, format and hashes
+func addImageChild(file *File, imageBytes []byte, fileName string, source string) *File {
+	attributes := fileHashes(imageBytes)
+	attributes["source"] = source
+	imageConfig, format, err := image.DecodeConfig(bytes.NewReader(imageBytes))
+	if err == nil {
+		attributes["format"] = format
+		attributes["width"] = strconv.Itoa(imageConfig.Width)
+		attributes["height"] = strconv.Itoa(imageConfig.Height)
+	} else {
+		attributes["format"] = strings.TrimPrefix(path.Ext(fileName), ".")
+	}
+	return file.addChild(imageBytes, fileName, attributes)
+}
+
+// Extract image XObjects from a PDF as child files
+// DCT and JPX streams are kept in their original encoding, other
+// filters are decoded by unipdf and re-encoded as PNG
+func extractPdfImages(file *File) error {
+	pdfParser, err := newPdfParser(file)
+	if err != nil {
+		return err
+	}
+	for _, objNum := range pdfParser.GetObjectNums() {
+		obj, err := pdfParser.LookupByNumber(objNum)
+		if err != nil {
+			continue
+		}
+		stream, ok := obj.(*core.PdfObjectStream)
+		if !ok {
+			continue
+		}
+		if subtype, _ := core.GetNameVal(stream.Get("Subtype")); subtype != "Image" {
+			continue
+		}
+		filters := pdfStreamFilters(stream)
+		lastFilter := ""
+		if len(filters) > 0 {
+			lastFilter = filters[len(filters)-1]
+		}
+		switch lastFilter {
+		case "CCITTFaxDecode":
+			// Fax streams are wrapped in a TIFF container instead of being decoded
+			tiffBytes, width, height, err := pdfCcittToTiff(stream)
+			if err != nil {
+				log.Printf("MODULE=extractPdfImages OPERATION=pdfCcittToTiff OBJECT=%d ERROR=%s", objNum, err)
+				continue
+			}
+			child := addImageChild(file, tiffBytes, fmt.Sprintf("pdf_image_%d.tif", objNum), "pdf_image")
+			if child != nil {
+				child.fileAttributes["format"] = "tiff"
+				child.fileAttributes["width"] = strconv.Itoa(width)
+				child.fileAttributes["height"] = strconv.Itoa(height)
+			}
+		case "DCTDecode", "JPXDecode":
+			imageBytes := stream.Stream
+			// Filters applied on top of the image encoding are decoded first
+			if len(filters) > 1 {
+				imageBytes, err = decodePdfImageFilters(stream, filters[:len(filters)-1])
+				if err != nil {
+					log.Printf("MODULE=extractPdfImages OPERATION=decodePdfImageFilters OBJECT=%d ERROR=%s", objNum, err)
+					continue
+				}
+			}
+			extension := "jpg"
+			if lastFilter == "JPXDecode" {
+				extension = "jp2"
+			}
+			addImageChild(file, imageBytes, fmt.Sprintf("pdf_image_%d.%s", objNum, extension), "pdf_image")
+		default:
+			pngBytes, err := pdfImageToPng(stream)
+			if err != nil {
+				log.Printf("MODULE=extractPdfImages OPERATION=pdfImageToPng OBJECT=%d ERROR=%s", objNum, err)
+				continue
+			}
+			addImageChild(file, pngBytes, fmt.Sprintf("pdf_image_%d.png", objNum), "pdf_image")
+		}
+	}
+	return nil
+}
+
+// Decode a subset of a stream's filters, leaving the image encoding intact
+func decodePdfImageFilters(stream *core.PdfObjectStream, filters []string) ([]byte, error) {
+	partial := &core.PdfObjectStream{
+		PdfObjectDictionary: core.MakeDict(),
+		Stream:              stream.Stream,
+	}
+	if len(filters) == 1 {
+		partial.Set("Filter", core.MakeName(filters[0]))
+	} else {
+		filterArray := core.MakeArray()
+		for _, filter := range filters {
+			filterArray.Append(core.MakeName(filter))
+		}
+		partial.Set("Filter", filterArray)
+	}
+	partial.Set("DecodeParms", stream.Get("DecodeParms"))
+	return core.DecodeStream(partial)
+}
+
+// Wrap a CCITT fax image stream in a single strip TIFF file
+func pdfCcittToTiff(stream *core.PdfObjectStream) ([]byte, int, int, error) {
+	filters := pdfStreamFilters(stream)
+	faxBytes := stream.Stream
+	var err error
+	if len(filters) > 1 {
+		faxBytes, err = decodePdfImageFilters(stream, filters[:len(filters)-1])
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	// Fax parameters from the last DecodeParms entry
+	params, ok := core.GetDict(stream.Get("DecodeParms"))
+	if parmsArray, isArray := core.GetArray(stream.Get("DecodeParms")); isArray && parmsArray.Len() > 0 {
+		params, ok = core.GetDict(parmsArray.Get(parmsArray.Len() - 1))
+	}
+	k, columns, rows, blackIs1 := 0, 1728, 0, false
+	if ok {
+		if value, found := core.GetIntVal(params.Get("K")); found {
+			k = value
+		}
+		if value, found := core.GetIntVal(params.Get("Columns")); found {
+			columns = value
+		}
+		if value, found := core.GetIntVal(params.Get("Rows")); found {
+			rows = value
+		}
+		blackIs1, _ = core.GetBoolVal(params.Get("BlackIs1"))
+	}
+	if rows <= 0 {
+		rows, _ = core.GetIntVal(stream.Get("Height"))
+	}
+	if columns <= 0 || rows <= 0 {
+		return nil, 0, 0, fmt.Errorf("invalid fax dimensions %dx%d", columns, rows)
+	}
+	// Group 4 for negative K, Group 3 otherwise with 2D coding for positive K
+	compression, t4Options := uint32(4), uint32(0)
+	if k >= 0 {
+		compression = 3
+		if k > 0 {
+			t4Options = 1
+		}
+	}
+	photometric := uint32(1)
+	if blackIs1 {
+		photometric = 0
+	}
+	entries := [][3]uint32{
+		{256, 4, uint32(columns)},
+		{257, 4, uint32(rows)},
+		{258, 3, 1},
+		{259, 3, compression},
+		{262, 3, photometric},
+		{273, 4, 0},
+		{277, 3, 1},
+		{278, 4, uint32(rows)},
+		{279, 4, uint32(len(faxBytes))},
+	}
+	if compression == 3 {
+		entries = append(entries, [3]uint32{292, 4, t4Options})
+	}
+	dataOffset := uint32(8 + 2 + 12*len(entries) + 4)
+	var tiff bytes.Buffer
+	tiff.WriteString("II*\x00")
+	binary.Write(&tiff, binary.LittleEndian, uint32(8))
+	binary.Write(&tiff, binary.LittleEndian, uint16(len(entries)))
+	for _, entry := range entries {
+		value := entry[2]
+		if entry[0] == 273 {
+			value = dataOffset
+		}
+		binary.Write(&tiff, binary.LittleEndian, uint16(entry[0]))
+		binary.Write(&tiff, binary.LittleEndian, uint16(entry[1]))
+		binary.Write(&tiff, binary.LittleEndian, uint32(1))
+		if entry[1] == 3 {
+			binary.Write(&tiff, binary.LittleEndian, uint16(value))
+			binary.Write(&tiff, binary.LittleEndian, uint16(0))
+		} else {
+			binary.Write(&tiff, binary.LittleEndian, value)
+		}
+	}
+	binary.Write(&tiff, binary.LittleEndian, uint32(0))
+	tiff.Write(faxBytes)
+	return tiff.Bytes(), columns, rows, nil
+}
+
+// Decode a PDF image XObject to pixels and encode it as PNG
+func pdfImageToPng(stream *core.PdfObjectStream) ([]byte, error) {
+	xobjectImage, err := model.NewXObjectImageFromStream(stream)
+	if err != nil {
+		return nil, err
+	}
+	pdfImage, err := xobjectImage.ToImage()
+	if err != nil {
+		return nil, err
+	}
+	goImage, err := pdfImage.ToGoImage()
+	if err != nil {
+		return nil, err
+	}
+	var pngBuffer bytes.Buffer
+	err = png.Encode(&pngBuffer, goImage)
+	if err != nil {
+		return nil, err
+	}
+	return pngBuffer.Bytes(), nil
+}
+
+// Extract base64 encoded data URI images from HTML as child files
+func extractDataUriImages(file *File) {
+	for i, match := range dataUriImageRe.FindAllSubmatch(file.fileBytes, -1) {
+		encoded := strings.Join(strings.Fields(string(match[2])), "")
+		imageBytes, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			log.Printf("MODULE=extractDataUriImages OPERATION=base64.DecodeString ERROR=%s", err)
+			continue
+		}
+		extension := strings.SplitN(string(match[1]), "+", 2)[0]
+		addImageChild(file, imageBytes, fmt.Sprintf("data_uri_image_%d.%s", i+1, extension), "data_uri")
+	}
+}
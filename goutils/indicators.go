@@ -0,0 +1,311 @@
+package goutils
+
+// Indicator of compromise extraction from parsed file strings
+// IP addresses, domains, emails, hashes, CVE ids, crypto wallets,
+// registry keys and windows file paths
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/big"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Struct of an extracted indicator and the number of times it was seen
+type Indicator struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Count int    `json:"count,omitempty"`
+}
+
+// Struct of indicator extraction options
+type IndicatorOptions struct {
+	// Count the occurrences of each indicator
+	Count bool
+}
+
+// Indicator types
+const (
+	IndicatorIPv4        = "ipv4"
+	IndicatorIPv6        = "ipv6"
+	IndicatorDomain      = "domain"
+	IndicatorEmail       = "email"
+	IndicatorMd5         = "md5"
+	IndicatorSha1        = "sha1"
+	IndicatorSha256      = "sha256"
+	IndicatorCve         = "cve"
+	IndicatorBitcoin     = "bitcoin"
+	IndicatorEthereum    = "ethereum"
+	IndicatorRegistryKey = "registry_key"
+	IndicatorFilePath    = "file_path"
+)
+
+// Struct of an indicator pattern with its normalizer and validator
+type indicatorPattern struct {
+	indicatorType string
+	re            *regexp.Regexp
+	normalize     func(string) string
+	valid         func(string) bool
+}
+
+// Indicator patterns, checked in order over every string
+var indicatorPatterns = []indicatorPattern{
+	{IndicatorIPv4, regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), nil, validIPv4},
+	{IndicatorIPv6, regexp.MustCompile(`(?i)(?:[0-9a-f]{0,4}:){2,7}(?:[0-9a-f]{0,4}|(?:\d{1,3}\.){3}\d{1,3})`), strings.ToLower, validIPv6},
+	{IndicatorEmail, regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@(?:[A-Za-z0-9-]+\.)+[A-Za-z]{2,63}\b`), strings.ToLower, validEmail},
+	{IndicatorDomain, regexp.MustCompile(`\b(?:[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)+[A-Za-z]{2,63}\b`), strings.ToLower, validHostDomain},
+	{IndicatorMd5, regexp.MustCompile(`\b[A-Fa-f0-9]{32}\b`), strings.ToLower, nil},
+	{IndicatorSha1, regexp.MustCompile(`\b[A-Fa-f0-9]{40}\b`), strings.ToLower, nil},
+	{IndicatorSha256, regexp.MustCompile(`\b[A-Fa-f0-9]{64}\b`), strings.ToLower, nil},
+	{IndicatorCve, regexp.MustCompile(`(?i)\bCVE-\d{4}-\d{4,7}\b`), strings.ToUpper, nil},
+	{IndicatorBitcoin, regexp.MustCompile(`\b(?:[13][a-km-zA-HJ-NP-Z1-9]{25,34}|bc1[ac-hj-np-z02-9]{11,71})\b`), nil, validBitcoin},
+	{IndicatorEthereum, regexp.MustCompile(`\b0x[A-Fa-f0-9]{40}\b`), nil, nil},
+	{IndicatorRegistryKey, regexp.MustCompile(`(?i)\b(?:HKEY_LOCAL_MACHINE|HKEY_CURRENT_USER|HKEY_CLASSES_ROOT|HKEY_USERS|HKEY_CURRENT_CONFIG|HKLM|HKCU|HKCR|HKU|HKCC)(?:\\[^\\\s"'<>|]+)+`), nil, nil},
+	{IndicatorFilePath, regexp.MustCompile(`(?:\b[A-Za-z]:|\\\\[A-Za-z0-9._$-]+)\\(?:[^\\/:*?"<>|\s]+\\)*[^\\/:*?"<>|\s]*`), nil, validFilePath},
+}
+
+// Indicator types whose matches must not touch letters, digits or colons,
+// so that C++ and Ruby namespace separators are not IPv6 addresses
+var isolatedIndicators = map[string]bool{
+	IndicatorIPv6: true,
+}
+
+// Top level domains shared with common file extensions, two label names in
+// them such as archive.zip, libc.so or install.sh are taken as file names
+var fileNameTlds = map[string]bool{
+	"ai":   true,
+	"app":  true,
+	"as":   true,
+	"cab":  true,
+	"cc":   true,
+	"do":   true,
+	"dot":  true,
+	"gd":   true,
+	"gs":   true,
+	"inc":  true,
+	"java": true,
+	"la":   true,
+	"ly":   true,
+	"md":   true,
+	"me":   true,
+	"mk":   true,
+	"ml":   true,
+	"mo":   true,
+	"mov":  true,
+	"mp":   true,
+	"ms":   true,
+	"one":  true,
+	"pl":   true,
+	"pm":   true,
+	"pro":  true,
+	"ps":   true,
+	"pub":  true,
+	"py":   true,
+	"rs":   true,
+	"run":  true,
+	"sh":   true,
+	"so":   true,
+	"st":   true,
+	"sv":   true,
+	"tf":   true,
+	"vc":   true,
+	"zip":  true,
+}
+
+// Extract typed, de-duplicated and counted indicators from a file and its child files
+func (file *File) Indicators() []Indicator {
+	return file.IndicatorsWithOptions(IndicatorOptions{Count: true})
+}
+
+// Extract typed and de-duplicated indicators, counted when requested
+func (file *File) IndicatorsWithOptions(options IndicatorOptions) []Indicator {
+	return extractIndicators(file.allStrings(), options)
+}
+
+// Extract indicators from a slice of strings
+func extractIndicators(strs []string, options IndicatorOptions) []Indicator {
+	counts := map[Indicator]int{}
+	for _, str := range strs {
+		for _, pattern := range indicatorPatterns {
+			for _, index := range pattern.re.FindAllStringIndex(str, -1) {
+				if isolatedIndicators[pattern.indicatorType] && !isolatedMatch(str, index[0], index[1]) {
+					continue
+				}
+				match := str[index[0]:index[1]]
+				if pattern.normalize != nil {
+					match = pattern.normalize(match)
+				}
+				if pattern.valid != nil && !pattern.valid(match) {
+					continue
+				}
+				counts[Indicator{Type: pattern.indicatorType, Value: match}]++
+			}
+		}
+	}
+	var indicators []Indicator
+	for indicator, count := range counts {
+		if options.Count {
+			indicator.Count = count
+		}
+		indicators = append(indicators, indicator)
+	}
+	sort.Slice(indicators, func(i, j int) bool {
+		if indicators[i].Type != indicators[j].Type {
+			return indicators[i].Type < indicators[j].Type
+		}
+		return indicators[i].Value < indicators[j].Value
+	})
+	return indicators
+}
+
+// Validate dotted quad IPv4 addresses without leading zeros
+func validIPv4(str string) bool {
+	for _, octet := range strings.Split(str, ".") {
+		if len(octet) > 1 && octet[0] == '0' {
+			return false
+		}
+	}
+	return net.ParseIP(str) != nil
+}
+
+// Validate IPv6 addresses, skipping IPv4 and time-like matches. Addresses
+// need two non-empty groups of four digits in all, a::b style separators
+// are too common
+func validIPv6(str string) bool {
+	if strings.Count(str, ":") < 2 {
+		return false
+	}
+	groups, digits := 0, 0
+	for _, group := range strings.Split(str, ":") {
+		if len(group) > 0 {
+			groups++
+			digits += len(group)
+		}
+	}
+	if groups < 2 || digits < 4 {
+		return false
+	}
+	ip := net.ParseIP(str)
+	return ip != nil && ip.To4() == nil
+}
+
+// Check that the characters around a match are not letters, digits,
+// underscores or colons
+func isolatedMatch(str string, start int, end int) bool {
+	isPart := func(c byte) bool {
+		return isWordByte(c) || c == '_' || c == ':'
+	}
+	return (start == 0 || !isPart(str[start-1])) && (end == len(str) || !isPart(str[end]))
+}
+
+// Validate a domain against the public suffix list
+// Unknown top level domains, such as file extensions, are rejected
+func validDomain(domain string) bool {
+	suffix, icann := publicsuffix.PublicSuffix(domain)
+	if !icann && !strings.Contains(suffix, ".") {
+		return false
+	}
+	return len(domain) > len(suffix)
+}
+
+// Validate a domain found on its own, skipping file names that look like
+// domains in file extension top level domains
+func validHostDomain(domain string) bool {
+	labels := strings.Split(domain, ".")
+	if len(labels) == 2 && fileNameTlds[labels[1]] {
+		return false
+	}
+	return validDomain(domain)
+}
+
+// Validate the domain part of an email address
+func validEmail(email string) bool {
+	return validDomain(email[strings.LastIndex(email, "@")+1:])
+}
+
+// Reject drive letter matches that carry no path
+func validFilePath(path string) bool {
+	return len(path) > 3
+}
+
+// Validate base58check and bech32 bitcoin addresses
+func validBitcoin(address string) bool {
+	if strings.HasPrefix(address, "bc1") {
+		return validBech32(address)
+	}
+	return validBase58Check(address)
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// Decode a base58 string and verify its double sha256 checksum
+func validBase58Check(address string) bool {
+	value := big.NewInt(0)
+	radix := big.NewInt(58)
+	for _, r := range address {
+		index := strings.IndexRune(base58Alphabet, r)
+		if index < 0 {
+			return false
+		}
+		value.Mul(value, radix)
+		value.Add(value, big.NewInt(int64(index)))
+	}
+	decoded := value.Bytes()
+	// Leading '1' characters encode leading zero bytes
+	for _, r := range address {
+		if r != '1' {
+			break
+		}
+		decoded = append([]byte{0}, decoded...)
+	}
+	if len(decoded) != 25 {
+		return false
+	}
+	first := sha256.Sum256(decoded[:21])
+	second := sha256.Sum256(first[:])
+	return bytes.Equal(second[:4], decoded[21:])
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// Verify the checksum of a bech32 or bech32m address
+func validBech32(address string) bool {
+	separator := strings.LastIndex(address, "1")
+	if separator < 1 || separator+7 > len(address) {
+		return false
+	}
+	hrp := address[:separator]
+	var values []int
+	for _, r := range hrp {
+		values = append(values, int(r)>>5)
+	}
+	values = append(values, 0)
+	for _, r := range hrp {
+		values = append(values, int(r)&31)
+	}
+	for _, r := range address[separator+1:] {
+		index := strings.IndexRune(bech32Charset, r)
+		if index < 0 {
+			return false
+		}
+		values = append(values, index)
+	}
+	generator := []int{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	checksum := 1
+	for _, value := range values {
+		top := checksum >> 25
+		checksum = (checksum&0x1ffffff)<<5 ^ value
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				checksum ^= generator[i]
+			}
+		}
+	}
+	// bech32 (segwit v0) and bech32m (taproot) constants
+	return checksum == 1 || checksum == 0x2bc830a3
+}
@@ -0,0 +1,396 @@
+package goutils
+
+// OpenDocument (ODT, ODS, ODP) and EPUB parsing
+// Both are zip containers identified by their stored mimetype entry
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Struct of a parsed OpenDocument file
+type OdfDocument struct {
+	MimeType string   `json:"mime_type"`
+	Title    string   `json:"title,omitempty"`
+	Creator  string   `json:"creator,omitempty"`
+	Text     string   `json:"text"`
+	Links    []string `json:"links,omitempty"`
+}
+
+// Struct of a parsed EPUB book
+type EpubDocument struct {
+	Title      string        `json:"title,omitempty"`
+	Authors    []string      `json:"authors,omitempty"`
+	Language   string        `json:"language,omitempty"`
+	Identifier string        `json:"identifier,omitempty"`
+	Chapters   []EpubChapter `json:"chapters,omitempty"`
+}
+
+// Struct of a chapter of the spine, in reading order
+type EpubChapter struct {
+	Href  string `json:"href"`
+	Title string `json:"title,omitempty"`
+	Size  int    `json:"size"`
+}
+
+// Container formats identified by the mimetype entry, with their extensions
+var zipMimetypes = map[string]string{
+	"application/vnd.oasis.opendocument.text":                  "odt",
+	"application/vnd.oasis.opendocument.text-template":         "ott",
+	"application/vnd.oasis.opendocument.spreadsheet":           "ods",
+	"application/vnd.oasis.opendocument.spreadsheet-template":  "ots",
+	"application/vnd.oasis.opendocument.presentation":          "odp",
+	"application/vnd.oasis.opendocument.presentation-template": "otp",
+	"application/vnd.oasis.opendocument.graphics":              "odg",
+	"application/epub+zip":                                     "epub",
+}
+
+// Maximum size of the mimetype entry
+const maxMimetypeSize = 256
+
+// Return the parsed OpenDocument of an ODF file
+func (file *File) GetOdf() *OdfDocument {
+	return file.fileOdf
+}
+
+// Return the parsed book of an EPUB file
+func (file *File) GetEpub() *EpubDocument {
+	return file.fileEpub
+}
+
+// Sniff ODF and EPUB containers, returning their mimetype entry and
+// extension, empty for other zip files
+func zipMimetype(fileBytes []byte) (string, string) {
+	if !bytes.HasPrefix(fileBytes, []byte("PK\x03\x04")) {
+		return "", ""
+	}
+	zipReader, err := zip.NewReader(bytes.NewReader(fileBytes), int64(len(fileBytes)))
+	if err != nil {
+		return "", ""
+	}
+	for _, zipFile := range zipReader.File {
+		if zipFile.Name != "mimetype" || zipFile.UncompressedSize64 > maxMimetypeSize {
+			continue
+		}
+		reader, err := zipFile.Open()
+		if err != nil {
+			return "", ""
+		}
+		defer reader.Close()
+		mimetype, err := ioutil.ReadAll(io.LimitReader(reader, maxMimetypeSize))
+		if err != nil {
+			return "", ""
+		}
+		fileType := strings.TrimSpace(string(mimetype))
+		if extension, ok := zipMimetypes[fileType]; ok {
+			return fileType, extension
+		}
+		return "", ""
+	}
+	return "", ""
+}
+
+// Read a named zip member, decrypting it with the file's candidate passwords
+func readZipEntry(file *File, zipReader *zip.Reader, name string) ([]byte, error) {
+	for _, zipFile := range zipReader.File {
+		if zipFile.Name == name {
+			memberBytes, _, err := readZipMember(file, zipFile)
+			return memberBytes, err
+		}
+	}
+	return nil, fmt.Errorf("zip: member %s not found", name)
+}
+
+// Extract the text of content.xml and the metadata of an ODF file,
+// pictures become child files
+func extractOdf(file *File) ([]string, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(file.fileBytes), int64(len(file.fileBytes)))
+	if err != nil {
+		return nil, err
+	}
+	content, err := readZipEntry(file, zipReader, "content.xml")
+	if err != nil {
+		return nil, err
+	}
+	document := &OdfDocument{MimeType: file.fileType}
+	// Encrypted documents keep content.xml unreadable without a password
+	document.Text, document.Links, err = odfText(content)
+	if err != nil {
+		log.Printf("MODULE=extractOdf OPERATION=odfText ERROR=%s", err)
+		return extractZip(file)
+	}
+	if meta, err := readZipEntry(file, zipReader, "meta.xml"); err == nil {
+		document.Title, document.Creator = odfMeta(meta)
+	}
+	file.fileOdf = document
+	for _, zipFile := range zipReader.File {
+		if !strings.HasPrefix(zipFile.Name, "Pictures/") || strings.HasSuffix(zipFile.Name, "/") {
+			continue
+		}
+		pictureBytes, password, err := readZipMember(file, zipFile)
+		if err != nil {
+			log.Printf("MODULE=extractOdf OPERATION=readZipMember MEMBER=%s ERROR=%s", zipFile.Name, err)
+			continue
+		}
+		setMemberPassword(addImageChild(file, pictureBytes, zipFile.Name, "odf_picture"), password)
+	}
+	odfStrings := []string{document.Title, document.Creator, document.Text}
+	odfStrings = append(odfStrings, document.Links...)
+	var nonEmpty []string
+	for _, str := range odfStrings {
+		if len(strings.TrimSpace(str)) > 0 {
+			nonEmpty = append(nonEmpty, str)
+		}
+	}
+	return nonEmpty, nil
+}
+
+// Return the text of an ODF content.xml, paragraphs on their own lines and
+// table cells of a row separated by tabs, with the targets of its links
+func odfText(content []byte) (string, []string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	var lines []string
+	var links []string
+	var paragraph strings.Builder
+	var cell []string
+	var row []string
+	tableDepth := 0
+	// Paragraphs inside a cell belong to the cell, elsewhere to the body
+	endParagraph := func() {
+		text := strings.TrimSpace(paragraph.String())
+		paragraph.Reset()
+		if len(text) == 0 {
+			return
+		}
+		if tableDepth > 0 {
+			cell = append(cell, text)
+		} else {
+			lines = append(lines, text)
+		}
+	}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			switch element.Name.Local {
+			case "table":
+				tableDepth++
+			case "table-row":
+				row = nil
+			case "table-cell", "covered-table-cell":
+				cell = nil
+			case "s":
+				count, err := strconv.Atoi(odfAttribute(element, "c"))
+				if err != nil || count < 1 {
+					count = 1
+				}
+				paragraph.WriteString(strings.Repeat(" ", count))
+			case "tab":
+				paragraph.WriteString("\t")
+			case "line-break":
+				paragraph.WriteString("\n")
+			case "a":
+				if href := odfAttribute(element, "href"); len(href) > 0 {
+					links = append(links, href)
+				}
+			}
+		case xml.EndElement:
+			switch element.Name.Local {
+			case "p", "h":
+				endParagraph()
+			case "table-cell", "covered-table-cell":
+				endParagraph()
+				row = append(row, strings.Join(cell, " "))
+			case "table-row":
+				// Repeated empty cells pad rows up to the sheet width
+				for len(row) > 0 && len(row[len(row)-1]) == 0 {
+					row = row[:len(row)-1]
+				}
+				if len(row) > 0 {
+					lines = append(lines, strings.Join(row, "\t"))
+				}
+			case "table":
+				tableDepth--
+			}
+		case xml.CharData:
+			paragraph.Write(element)
+		}
+	}
+	endParagraph()
+	return strings.Join(lines, "\n"), links, nil
+}
+
+// Return the title and creator of an ODF meta.xml
+func odfMeta(meta []byte) (string, string) {
+	var title, creator, initialCreator string
+	decoder := xml.NewDecoder(bytes.NewReader(meta))
+	var current string
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			current = element.Name.Local
+		case xml.EndElement:
+			current = ""
+		case xml.CharData:
+			switch current {
+			case "title":
+				title += string(element)
+			case "creator":
+				creator += string(element)
+			case "initial-creator":
+				initialCreator += string(element)
+			}
+		}
+	}
+	if len(creator) == 0 {
+		creator = initialCreator
+	}
+	return strings.TrimSpace(title), strings.TrimSpace(creator)
+}
+
+// Return the value of an attribute by local name, empty when it is not set
+func odfAttribute(element xml.StartElement, local string) string {
+	for _, attribute := range element.Attr {
+		if attribute.Name.Local == local {
+			return attribute.Value
+		}
+	}
+	return ""
+}
+
+// Struct of the EPUB container.xml
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// Struct of the EPUB package (OPF) document
+type epubPackage struct {
+	Title      []string `xml:"metadata>title"`
+	Creators   []string `xml:"metadata>creator"`
+	Language   []string `xml:"metadata>language"`
+	Identifier []string `xml:"metadata>identifier"`
+	Items      []struct {
+		Id        string `xml:"id,attr"`
+		Href      string `xml:"href,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"manifest>item"`
+	Itemrefs []struct {
+		Idref string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// Extract the metadata and the chapters of an EPUB in spine order,
+// images of the manifest become child files
+func extractEpub(file *File) ([]string, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(file.fileBytes), int64(len(file.fileBytes)))
+	if err != nil {
+		return nil, err
+	}
+	containerBytes, err := readZipEntry(file, zipReader, "META-INF/container.xml")
+	if err != nil {
+		return nil, err
+	}
+	var container epubContainer
+	if err := xml.Unmarshal(containerBytes, &container); err != nil {
+		return nil, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, fmt.Errorf("epub: container has no rootfile")
+	}
+	packagePath := container.Rootfiles[0].FullPath
+	packageBytes, err := readZipEntry(file, zipReader, packagePath)
+	if err != nil {
+		return nil, err
+	}
+	var opf epubPackage
+	if err := xml.Unmarshal(packageBytes, &opf); err != nil {
+		return nil, err
+	}
+	book := &EpubDocument{}
+	if len(opf.Title) > 0 {
+		book.Title = strings.TrimSpace(opf.Title[0])
+	}
+	for _, creator := range opf.Creators {
+		if creator = strings.TrimSpace(creator); len(creator) > 0 {
+			book.Authors = append(book.Authors, creator)
+		}
+	}
+	if len(opf.Language) > 0 {
+		book.Language = strings.TrimSpace(opf.Language[0])
+	}
+	if len(opf.Identifier) > 0 {
+		book.Identifier = strings.TrimSpace(opf.Identifier[0])
+	}
+	file.fileEpub = book
+	epubStrings := []string{book.Title}
+	epubStrings = append(epubStrings, book.Authors...)
+	// Manifest hrefs are url-encoded and relative to the package document
+	manifest := map[string]string{}
+	for _, item := range opf.Items {
+		href, err := url.PathUnescape(item.Href)
+		if err != nil {
+			href = item.Href
+		}
+		href = path.Join(path.Dir(packagePath), href)
+		manifest[item.Id] = href
+		if strings.HasPrefix(item.MediaType, "image/") {
+			imageBytes, err := readZipEntry(file, zipReader, href)
+			if err != nil {
+				log.Printf("MODULE=extractEpub OPERATION=readZipEntry MEMBER=%s ERROR=%s", href, err)
+				continue
+			}
+			addImageChild(file, imageBytes, href, "epub_image")
+		}
+	}
+	for _, itemref := range opf.Itemrefs {
+		href, ok := manifest[itemref.Idref]
+		if !ok {
+			continue
+		}
+		chapterBytes, err := readZipEntry(file, zipReader, href)
+		if err != nil {
+			log.Printf("MODULE=extractEpub OPERATION=readZipEntry MEMBER=%s ERROR=%s", href, err)
+			continue
+		}
+		chapter := EpubChapter{Href: href, Size: len(chapterBytes)}
+		if document, err := ParseHtml(chapterBytes); err == nil {
+			chapter.Title = document.Title
+			epubStrings = append(epubStrings, document.Text)
+			for _, link := range document.Links {
+				epubStrings = append(epubStrings, link.Url)
+			}
+			for _, script := range document.Scripts {
+				epubStrings = append(epubStrings, script.Body)
+			}
+		}
+		book.Chapters = append(book.Chapters, chapter)
+	}
+	var nonEmpty []string
+	for _, str := range epubStrings {
+		if len(strings.TrimSpace(str)) > 0 {
+			nonEmpty = append(nonEmpty, str)
+		}
+	}
+	return nonEmpty, nil
+}
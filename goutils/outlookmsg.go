@@ -0,0 +1,433 @@
+package goutils
+
+// Outlook .msg parsing
+// MAPI properties stored as compound file streams: sender, recipients,
+// subject, transport headers, plain, HTML and compressed RTF bodies, and
+// attachments including nested messages as child files
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Struct of a parsed Outlook message
+type OutlookMessage struct {
+	Subject               string              `json:"subject"`
+	SenderName            string              `json:"sender_name"`
+	SenderEmail           string              `json:"sender_email"`
+	DisplayTo             string              `json:"display_to,omitempty"`
+	DisplayCc             string              `json:"display_cc,omitempty"`
+	MessageId             string              `json:"message_id,omitempty"`
+	TransportHeaders      string              `json:"transport_headers,omitempty"`
+	Received              []string            `json:"received,omitempty"`
+	AuthenticationResults []string            `json:"authentication_results,omitempty"`
+	Recipients            []OutlookRecipient  `json:"recipients,omitempty"`
+	TextBody              string              `json:"text_body,omitempty"`
+	HtmlBody              string              `json:"html_body,omitempty"`
+	RtfBody               bool                `json:"rtf_body"`
+	Attachments           []OutlookAttachment `json:"attachments,omitempty"`
+}
+
+// Struct of a message recipient
+type OutlookRecipient struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Type  string `json:"type"`
+}
+
+// Struct of an attachment extracted as a child file
+type OutlookAttachment struct {
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type,omitempty"`
+	Size     int    `json:"size"`
+	Embedded bool   `json:"embedded"`
+}
+
+// MAPI property ids
+const (
+	mapiSubject            = 0x0037
+	mapiSenderName         = 0x0c1a
+	mapiSenderEmail        = 0x0c1f
+	mapiSenderSmtpAddress  = 0x5d01
+	mapiDisplayTo          = 0x0e04
+	mapiDisplayCc          = 0x0e03
+	mapiInternetMessageId  = 0x1035
+	mapiTransportHeaders   = 0x007d
+	mapiBody               = 0x1000
+	mapiHtml               = 0x1013
+	mapiRtfCompressed      = 0x1009
+	mapiRecipientType      = 0x0c15
+	mapiDisplayName        = 0x3001
+	mapiEmailAddress       = 0x3003
+	mapiSmtpAddress        = 0x39fe
+	mapiAttachDataObject   = 0x3701
+	mapiAttachFilename     = 0x3704
+	mapiAttachMethod       = 0x3705
+	mapiAttachLongFilename = 0x3707
+	mapiAttachMimeTag      = 0x370e
+	mapiMessageCodepage    = 0x3ffd
+	mapiInternetCodepage   = 0x3fde
+)
+
+// MAPI property types
+const (
+	mapiTypeLong    = 0x0003
+	mapiTypeObject  = 0x000d
+	mapiTypeString8 = 0x001e
+	mapiTypeUnicode = 0x001f
+	mapiTypeBinary  = 0x0102
+)
+
+// PidTagAttachMethod value of embedded message attachments, OLE object
+// attachments are storages too
+const msgAttachEmbeddedMsg = 5
+
+// Storage and stream names of a message
+const (
+	msgPropertiesStream = "__properties_version1.0"
+	msgRecipientPrefix  = "__recip_version1.0_"
+	msgAttachmentPrefix = "__attach_version1.0_"
+)
+
+// Header sizes of the fixed length property stream
+const (
+	msgTopLevelPropertyHeader = 32
+	msgEmbeddedPropertyHeader = 24
+	msgChildPropertyHeader    = 8
+)
+
+// Recipient types by PidTagRecipientType value
+var msgRecipientTypes = map[uint32]string{1: "to", 2: "cc", 3: "bcc"}
+
+// Struct of the MAPI properties of one storage
+type msgProperties struct {
+	reader  *cfbReader
+	storage int
+	fixed   map[uint16]uint32
+	charset string
+}
+
+// Return the parsed Outlook message of a .msg file
+func (file *File) GetOutlookMessage() *OutlookMessage {
+	return file.fileOutlookMessage
+}
+
+// Sniff Outlook messages, compound files are matched as Word documents by filetype
+func sniffOutlookMsg(fileBytes []byte) (string, string) {
+	if bytes.HasPrefix(fileBytes, cfbSignature) && isOutlookMsg(fileBytes) {
+		return "application/vnd.ms-outlook", "msg"
+	}
+	return "", ""
+}
+
+// Check whether a compound file is an Outlook message
+func isOutlookMsg(fileBytes []byte) bool {
+	reader, err := newCfbReader(fileBytes)
+	if err != nil {
+		return false
+	}
+	// Embedded messages copied out of an attachment have no named property storage
+	if _, ok := reader.find(0, msgPropertiesStream); !ok {
+		return false
+	}
+	for _, child := range reader.children(0) {
+		if strings.HasPrefix(reader.entries[child].name, "__substg1.0_") {
+			return true
+		}
+	}
+	return false
+}
+
+// Extract headers and bodies from a .msg file, attachments become child files
+func extractOutlookMsg(file *File) ([]string, error) {
+	reader, err := newCfbReader(file.fileBytes)
+	if err != nil {
+		return nil, err
+	}
+	properties := readMsgProperties(reader, 0, msgTopLevelPropertyHeader)
+	message := &OutlookMessage{
+		Subject:          properties.text(mapiSubject),
+		SenderName:       properties.text(mapiSenderName),
+		SenderEmail:      properties.text(mapiSenderSmtpAddress),
+		DisplayTo:        properties.text(mapiDisplayTo),
+		DisplayCc:        properties.text(mapiDisplayCc),
+		MessageId:        properties.text(mapiInternetMessageId),
+		TransportHeaders: properties.text(mapiTransportHeaders),
+		TextBody:         properties.text(mapiBody),
+		HtmlBody:         properties.text(mapiHtml),
+	}
+	if len(message.SenderEmail) == 0 {
+		message.SenderEmail = properties.text(mapiSenderEmail)
+	}
+	file.fileOutlookMessage = message
+	if len(message.TransportHeaders) > 0 {
+		headerReader := textproto.NewReader(bufio.NewReader(strings.NewReader(strings.TrimSpace(message.TransportHeaders) + "\r\n\r\n")))
+		header, err := headerReader.ReadMIMEHeader()
+		if err != nil {
+			log.Printf("MODULE=extractOutlookMsg OPERATION=ReadMIMEHeader ERROR=%s", err)
+		}
+		message.Received = header["Received"]
+		message.AuthenticationResults = header["Authentication-Results"]
+	}
+	for _, child := range reader.children(0) {
+		entry := reader.entries[child]
+		switch {
+		case entry.entryType == cfbStorage && strings.HasPrefix(entry.name, msgRecipientPrefix):
+			recipient := readMsgProperties(reader, child, msgChildPropertyHeader)
+			email := recipient.text(mapiSmtpAddress)
+			if len(email) == 0 {
+				email = recipient.text(mapiEmailAddress)
+			}
+			recipientType, ok := msgRecipientTypes[recipient.fixed[mapiRecipientType]]
+			if !ok {
+				recipientType = "unknown"
+			}
+			message.Recipients = append(message.Recipients, OutlookRecipient{
+				Name:  recipient.text(mapiDisplayName),
+				Email: email,
+				Type:  recipientType,
+			})
+		case entry.entryType == cfbStorage && strings.HasPrefix(entry.name, msgAttachmentPrefix):
+			addMsgAttachment(file, reader, child, message)
+		}
+	}
+	if rtfCompressed := properties.binary(mapiRtfCompressed); len(rtfCompressed) > 0 {
+		rtfBytes, err := decompressRtf(rtfCompressed)
+		if err != nil {
+			log.Printf("MODULE=extractOutlookMsg OPERATION=decompressRtf ERROR=%s", err)
+		} else {
+			message.RtfBody = true
+			attributes := fileHashes(rtfBytes)
+			attributes["source"] = "msg_rtf_body"
+			file.addChild(rtfBytes, "body.rtf", attributes)
+		}
+	}
+	msgStrings := []string{message.Subject, message.SenderName, message.SenderEmail, message.DisplayTo, message.DisplayCc, message.TransportHeaders, message.TextBody}
+	for _, recipient := range message.Recipients {
+		msgStrings = append(msgStrings, recipient.Email)
+	}
+	if len(message.HtmlBody) > 0 {
+		msgStrings = append(msgStrings, htmlBodyStrings(message.HtmlBody)...)
+	}
+	var nonEmpty []string
+	for _, str := range msgStrings {
+		if len(strings.TrimSpace(str)) > 0 {
+			nonEmpty = append(nonEmpty, str)
+		}
+	}
+	return nonEmpty, nil
+}
+
+// Add the data of an attachment storage as a child file, embedded messages
+// are copied out as .msg files of their own and OLE objects as compound files
+func addMsgAttachment(file *File, reader *cfbReader, storage int, message *OutlookMessage) {
+	properties := readMsgProperties(reader, storage, msgChildPropertyHeader)
+	attachment := OutlookAttachment{
+		FileName: properties.text(mapiAttachLongFilename),
+		MimeType: properties.text(mapiAttachMimeTag),
+	}
+	if len(attachment.FileName) == 0 {
+		attachment.FileName = properties.text(mapiAttachFilename)
+	}
+	if len(attachment.FileName) == 0 {
+		attachment.FileName = properties.text(mapiDisplayName)
+	}
+	attachmentBytes := properties.binary(mapiAttachDataObject)
+	embedded, ok := reader.find(storage, mapiStreamName(mapiAttachDataObject, mapiTypeObject))
+	if ok && reader.entries[embedded].entryType == cfbStorage {
+		// OLE objects are compound files of their own, only embedded
+		// messages get the property header fix and the .msg name
+		isMessage := properties.fixed[mapiAttachMethod] == msgAttachEmbeddedMsg
+		var err error
+		attachmentBytes, err = reader.extractStorage(embedded, func(path string, data []byte) []byte {
+			// Embedded messages have a shorter property header than top level ones
+			if isMessage && path == msgPropertiesStream && len(data) >= msgEmbeddedPropertyHeader {
+				padded := append([]byte{}, data[:msgEmbeddedPropertyHeader]...)
+				padded = append(padded, make([]byte, msgTopLevelPropertyHeader-msgEmbeddedPropertyHeader)...)
+				return append(padded, data[msgEmbeddedPropertyHeader:]...)
+			}
+			return data
+		})
+		if err != nil {
+			log.Printf("MODULE=extractOutlookMsg OPERATION=extractStorage ERROR=%s", err)
+			return
+		}
+		if isMessage {
+			attachment.Embedded = true
+			if len(attachment.FileName) == 0 {
+				attachment.FileName = "embedded.msg"
+			}
+			if !strings.HasSuffix(strings.ToLower(attachment.FileName), ".msg") {
+				attachment.FileName += ".msg"
+			}
+		}
+	}
+	if len(attachmentBytes) == 0 {
+		return
+	}
+	if len(attachment.FileName) == 0 {
+		attachment.FileName = fmt.Sprintf("attachment_%d.bin", len(message.Attachments)+1)
+	}
+	attachment.Size = len(attachmentBytes)
+	message.Attachments = append(message.Attachments, attachment)
+	attributes := fileHashes(attachmentBytes)
+	attributes["source"] = "msg_attachment"
+	if len(attachment.MimeType) > 0 {
+		attributes["mime_type"] = attachment.MimeType
+	}
+	file.addChild(attachmentBytes, attachment.FileName, attributes)
+}
+
+// Read the fixed length properties and codepage of a storage
+func readMsgProperties(reader *cfbReader, storage int, headerSize int) *msgProperties {
+	properties := &msgProperties{reader: reader, storage: storage, fixed: map[uint16]uint32{}, charset: defaultCharset}
+	if stream, ok := reader.find(storage, msgPropertiesStream); ok {
+		data, err := reader.stream(stream)
+		if err != nil {
+			log.Printf("MODULE=extractOutlookMsg OPERATION=stream ERROR=%s", err)
+		}
+		// Each entry is a property tag, flags and an 8 byte value
+		for offset := headerSize; offset+16 <= len(data); offset += 16 {
+			tag := binary.LittleEndian.Uint32(data[offset:])
+			if tag&0xffff == mapiTypeLong {
+				properties.fixed[uint16(tag>>16)] = binary.LittleEndian.Uint32(data[offset+8:])
+			}
+		}
+	}
+	for _, id := range []uint16{mapiInternetCodepage, mapiMessageCodepage} {
+		if codepage, ok := properties.fixed[id]; ok {
+			properties.charset = rtfCodepageCharset(int(codepage))
+		}
+	}
+	return properties
+}
+
+// Name of the stream holding a variable length property
+func mapiStreamName(id uint16, propertyType uint16) string {
+	return fmt.Sprintf("__substg1.0_%04X%04X", id, propertyType)
+}
+
+// Return a string property, stored as UTF-16, 8 bit or binary text
+func (properties *msgProperties) text(id uint16) string {
+	reader := properties.reader
+	if stream, ok := reader.find(properties.storage, mapiStreamName(id, mapiTypeUnicode)); ok {
+		data, _ := reader.stream(stream)
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	}
+	for _, propertyType := range []uint16{mapiTypeString8, mapiTypeBinary} {
+		if stream, ok := reader.find(properties.storage, mapiStreamName(id, propertyType)); ok {
+			data, _ := reader.stream(stream)
+			data = bytes.TrimRight(data, "\x00")
+			if isAscii(data) {
+				return string(data)
+			}
+			if decoded, err := TranscodeToUtf8(data, properties.charset); err == nil {
+				return string(decoded)
+			}
+			return string(data)
+		}
+	}
+	return ""
+}
+
+// Return a binary property
+func (properties *msgProperties) binary(id uint16) []byte {
+	if stream, ok := properties.reader.find(properties.storage, mapiStreamName(id, mapiTypeBinary)); ok {
+		data, err := properties.reader.stream(stream)
+		if err == nil {
+			return data
+		}
+	}
+	return nil
+}
+
+// Check whether bytes are plain ASCII and need no transcoding
+func isAscii(data []byte) bool {
+	for _, b := range data {
+		if b >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// Dictionary preloaded by the compressed RTF format (MS-OXRTFCP)
+const rtfCompressedPrebuf = "{\\rtf1\\ansi\\mac\\deff0\\deftab720{\\fonttbl;}{\\f0\\fnil \\froman \\fswiss \\fmodern \\fscript \\fdecor MS Sans SerifSymbolArialTimes New RomanCourier{\\colortbl\\red0\\green0\\blue0\r\n\\par \\pard\\plain\\f0\\fs20\\b\\i\\u\\tab\\tx"
+
+// Compression types of compressed RTF
+const (
+	rtfCompressedLzfu = 0x75465a4c
+	rtfCompressedMela = 0x414c454d
+)
+
+// Decompress an RTF body stored in the compressed RTF format
+func decompressRtf(data []byte) ([]byte, error) {
+	if len(data) < 16 {
+		return nil, errors.New("compressed RTF header too short")
+	}
+	rawSize := int(binary.LittleEndian.Uint32(data[4:]))
+	compressionType := binary.LittleEndian.Uint32(data[8:])
+	body := data[16:]
+	switch compressionType {
+	case rtfCompressedMela:
+		if rawSize < len(body) {
+			body = body[:rawSize]
+		}
+		return body, nil
+	case rtfCompressedLzfu:
+	default:
+		return nil, errors.New("unknown compressed RTF type " + strconv.FormatUint(uint64(compressionType), 16))
+	}
+	var dictionary [4096]byte
+	copy(dictionary[:], rtfCompressedPrebuf)
+	write := len(rtfCompressedPrebuf)
+	// The header size is untrusted, clamp it to the most a body can expand
+	// to, 17 bytes for each 2 byte reference
+	capacity := rawSize
+	if capacity < 0 || capacity > 9*len(body) {
+		capacity = 9 * len(body)
+	}
+	output := make([]byte, 0, capacity)
+	for pos := 0; pos < len(body); {
+		control := body[pos]
+		pos++
+		for bit := 0; bit < 8 && pos < len(body); bit++ {
+			if control&(1<<uint(bit)) == 0 {
+				dictionary[write] = body[pos]
+				write = (write + 1) % len(dictionary)
+				output = append(output, body[pos])
+				pos++
+				continue
+			}
+			if pos+2 > len(body) {
+				return output, errors.New("truncated compressed RTF reference")
+			}
+			reference := int(body[pos])<<8 | int(body[pos+1])
+			pos += 2
+			offset, length := reference>>4, reference&0x0f+2
+			// A reference to the write position marks the end of the data
+			if offset == write {
+				return output, nil
+			}
+			for i := 0; i < length; i++ {
+				c := dictionary[(offset+i)%len(dictionary)]
+				dictionary[write] = c
+				write = (write + 1) % len(dictionary)
+				output = append(output, c)
+			}
+		}
+	}
+	return output, nil
+}
@@ -2,9 +2,11 @@ package goutils
 
 // Package for extracting text and urls from files
 // File Types: text, text/html, rtf
-//			   pdf, doc/docx, xls/xlsx, ppt/pptx
+//			   pdf, doc/docx, xls/xlsx, ppt/pptx, odt/ods/odp, epub
 //			   gzip, gzip/bz2, tar, zip
-// Limited support for all other file types (file strings only)
+//			   exe/dll, elf, mach-o
+//			   jpeg, png, tiff, webp, heif (metadata)
+// Limited support for all other file types (binary strings only)
 // V1.0
 
 import (
@@ -13,13 +15,15 @@ import (
 	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
     "net/http"
 	"regexp"
-	"strings"
 	"unicode/utf8"
 
 	"github.com/h2non/filetype"
@@ -35,22 +39,62 @@ type File struct {
 	fileType		string
 	fileExtension	string
 	fileStrings		[]string
-	fileParseMethod	func(file *File) ([]string, error)
+	fileParseMethod	ParserFunc
+	fileAttributes	map[string]string
+	fileChildren	[]*File
+	fileDepth		int
+	filePasswords	[]string
+	filePasswordFunc	PasswordFunc
+	filePasswordAttempt	int
+	filePassword	string
+	fileDecoded		[]DecodedString
+	fileStringsOptions	StringsOptions
+	fileBinaryStrings	[]BinaryString
+	fileCharset		string
+	fileHtml		*HtmlDocument
+	fileRtf			*RtfDocument
+	fileEmail		*EmailMessage
+	fileOutlookMessage	*OutlookMessage
+	fileOdf			*OdfDocument
+	fileEpub		*EpubDocument
+	fileExecutable	*ExecutableInfo
+	fileImage		*ImageMetadata
+	fileTypeCheck	*FileTypeCheck
+	fileRuleMatches	[]RuleMatch
+	fileEntropy		*EntropyAnalysis
 }
 
-// Map of file type strings handled by IX to parser functions
-var FileTypes = map[string]func(file *File) ([]string, error) {
-	"application/msword": extractStrings,
-	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": extractZip,
-	"application/vnd.ms-excel": extractZip,
-	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": extractZip,
-	"application/vnd.ms-powerpoint": extractZip,
-	"application/vnd.openxmlformats-officedocument.presentationml.presentation": extractZip,
-	"application/pdf": extractPdf,
-	"text/html": extractStrings,
-	"application/gzip": extractGzip,
-	"application/x-bzip2": extractGzip,
-	"application/zip": extractZip,
+// Maximum nesting depth of child files extracted from containers
+const maxChildDepth = 8
+
+// Built in parsers and sniffers. Parsers extract child files through LoadFile,
+// so the registry is populated at init to avoid an initialization cycle
+func init() {
+	RegisterSniffer(40, sniffOutlookMsg)
+	RegisterSniffer(30, zipMimetype)
+	RegisterFallbackSniffer(20, sniffRtf)
+	RegisterFallbackSniffer(10, sniffEmail)
+	RegisterParser("application/msword", 0, extractStrings, "doc")
+	RegisterParser("application/vnd.openxmlformats-officedocument.wordprocessingml.document", 0, extractZip, "docx")
+	RegisterParser("application/vnd.ms-excel", 0, extractZip, "xls")
+	RegisterParser("application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", 0, extractZip, "xlsx")
+	RegisterParser("application/vnd.ms-powerpoint", 0, extractZip, "ppt")
+	RegisterParser("application/vnd.openxmlformats-officedocument.presentationml.presentation", 0, extractZip, "pptx")
+	RegisterParser("application/pdf", 0, extractPdf, "pdf")
+	RegisterParser("text/html", 0, extractHtmlStrings, "html", "htm")
+	RegisterParser("text/plain", 0, extractText, "txt", "csv", "log")
+	RegisterParser("application/gzip", 0, extractGzip, "gz", "tgz")
+	RegisterParser("application/x-bzip2", 0, extractGzip, "bz2")
+	RegisterParser("application/rtf", 0, extractRtf, "rtf")
+	RegisterParser("message/rfc822", 0, extractEmail, "eml")
+	RegisterParser("application/vnd.ms-outlook", 0, extractOutlookMsg, "msg")
+	RegisterParser("application/vnd.oasis.opendocument", 0, extractOdf, "odt", "ods", "odp")
+	RegisterParser("application/epub+zip", 0, extractEpub, "epub")
+	RegisterParser("application/vnd.microsoft.portable-executable", 0, extractExecutable, "exe", "dll")
+	RegisterParser("application/x-executable", 0, extractExecutable)
+	RegisterParser("application/x-mach-binary", 0, extractExecutable)
+	RegisterParser("image/", 0, extractImage)
+	RegisterParser("application/zip", 0, extractZip, "zip")
 }
 
 // Parse a file, return the struct of the parsed file
@@ -67,31 +111,22 @@ func LoadFile(fileBytes []byte, fileName string) *File {
 }
 
 // Detect file information
-func detectFileInfo(fileBytes []byte, fileName string) (string, string, func(file *File) ([]string, error), error) {
-	// Use filetype package to parse, with http package as a fallback
+func detectFileInfo(fileBytes []byte, fileName string) (string, string, ParserFunc, error) {
+	// Registered sniffers first, then the filetype package, with fallback
+	// sniffers and the http package for unknown content
+	fileType, fileExtenstion := sniffFileType(fileBytes, false)
+	if len(fileType) > 0 {
+		return fileType, fileExtenstion, lookupParser(fileType, fileName), nil
+	}
 	kind, _ := filetype.Match(fileBytes)
-	var fileExtenstion string
-	var fileType string
-	if kind == filetype.Unknown {
-		fileType = http.DetectContentType(fileBytes)
-		fileExtenstion = "unknown"
-	} else {
+	if kind != filetype.Unknown {
 		fileType = string(kind.MIME.Value)
 		fileExtenstion = string(kind.Extension)
+	} else if fileType, fileExtenstion = sniffFileType(fileBytes, true); len(fileType) == 0 {
+		fileType = http.DetectContentType(fileBytes)
+		fileExtenstion = "unknown"
 	}
-	// Determine the parsing method to use based on file type
-	var fileParseMethod func(file *File) ([]string, error)
-	for fileTypeName, function := range FileTypes {
-		if strings.HasPrefix(fileType, fileTypeName) {
-			fileParseMethod = function
-			break
-		}
-	}
-	// Assign default string parser for undetected file types
-	if fileParseMethod == nil {
-		fileParseMethod = extractStrings
-	}
-	return fileType, fileExtenstion, fileParseMethod, nil
+	return fileType, fileExtenstion, lookupParser(fileType, fileName), nil
 }
 
 // Return file name string
@@ -114,6 +149,16 @@ func (file *File) GetFileBytes() []byte {
 	return file.fileBytes
 }
 
+// Return file attributes declared by the parent container
+func (file *File) GetFileAttributes() map[string]string {
+	return file.fileAttributes
+}
+
+// Return child files extracted during parsing
+func (file *File) GetChildren() []*File {
+	return file.fileChildren
+}
+
 // Return file strings slice
 func (file *File) GetFileStrings() []string {
 	var stringSlice []string
@@ -133,15 +178,67 @@ func (file *File) GetFileString() string {
 }
 
 // Parse files using the detected file parse method from FileTypes struct
-func (file *File) Parse() ([]string, error) {
+// Candidate passwords are tried against encrypted documents and archive members
+func (file *File) Parse(passwords ...string) ([]string, error) {
 	var err error
+	file.filePasswords = appendPasswords(file.filePasswords, passwords...)
+	file.fileChildren = nil
+	file.fileRuleMatches = nil
+	file.fileTypeCheck = checkFileType(file)
+	file.fileEntropy = analyzeEntropy(file)
 	file.fileStrings, err = file.fileParseMethod(file)
 	if err != nil {
 		return nil, err
 	}
+	file.fileDecoded = decodeStrings(file.fileStrings)
 	return file.fileStrings, nil
 }
 
+// Load and parse a file extracted from a container, attach it as a child
+func (file *File) addChild(fileBytes []byte, fileName string, attributes map[string]string) *File {
+	if file.fileDepth >= maxChildDepth {
+		log.Printf("MODULE=addChild FILE=%s ERROR=maximum child depth %d reached", fileName, maxChildDepth)
+		return nil
+	}
+	child := LoadFile(fileBytes, fileName)
+	child.fileDepth = file.fileDepth + 1
+	child.fileAttributes = attributes
+	child.filePasswords = append([]string{}, file.filePasswords...)
+	child.filePasswordFunc = file.filePasswordFunc
+	child.fileStringsOptions = file.fileStringsOptions
+	_, err := child.Parse()
+	if err != nil {
+		log.Printf("MODULE=addChild OPERATION=child.Parse FILE=%s ERROR=%s", fileName, err)
+	}
+	file.fileChildren = append(file.fileChildren, child)
+	return child
+}
+
+// Return strings of a file and all of its child files, including
+// strings recovered by the decoding pass
+func (file *File) allStrings() []string {
+	allStrings := append([]string{}, file.fileStrings...)
+	for _, decoded := range file.fileDecoded {
+		allStrings = append(allStrings, decoded.Value)
+	}
+	for _, child := range file.fileChildren {
+		allStrings = append(allStrings, child.allStrings()...)
+	}
+	return allStrings
+}
+
+// Return hex encoded md5, sha1 and sha256 hashes of bytes
+func fileHashes(fileBytes []byte) map[string]string {
+	md5Sum := md5.Sum(fileBytes)
+	sha1Sum := sha1.Sum(fileBytes)
+	sha256Sum := sha256.Sum256(fileBytes)
+	return map[string]string{
+		"md5":		hex.EncodeToString(md5Sum[:]),
+		"sha1":		hex.EncodeToString(sha1Sum[:]),
+		"sha256":	hex.EncodeToString(sha256Sum[:]),
+	}
+}
+
 // Extract strings from microsoft documents and zip archives
 func extractZip(file *File) ([]string, error) {
     zipReader, err := zip.NewReader(bytes.NewReader(file.fileBytes), int64(len(file.fileBytes)))
@@ -151,14 +248,24 @@ func extractZip(file *File) ([]string, error) {
 	var unzippedFileStrings []string
     // Read all the files from archive
     for _, zipFile := range zipReader.File {
-		unzippedFile, err := zipFile.Open()
+		// Encrypted members are decrypted with the file's candidate passwords
+		uzFileBytes, password, err := readZipMember(file, zipFile)
 		if err != nil {
+			log.Printf("MODULE=extractZip OPERATION=readZipMember MEMBER=%s ERROR=%s", zipFile.Name, err)
 			continue
 		}
-		defer unzippedFile.Close()
-		uzFileBytes, err := ioutil.ReadAll(unzippedFile)
-		if err != nil {
-			return nil, err
+		// Office document media becomes image child files
+		if officeMediaRe.MatchString(zipFile.Name) {
+			setMemberPassword(addImageChild(file, uzFileBytes, zipFile.Name, "office_media"), password)
+			continue
+		}
+		// Members unlocked by a password become child files recording it,
+		// members of one archive may use different passwords
+		if len(password) > 0 {
+			attributes := fileHashes(uzFileBytes)
+			attributes["source"] = "zip_member"
+			setMemberPassword(file.addChild(uzFileBytes, zipFile.Name, attributes), password)
+			continue
 		}
 		extractStrings, err := extractStrings(&File{fileBytes: uzFileBytes})
 		if err != nil {
@@ -220,11 +327,13 @@ func extractGzip(file *File) ([]string, error) {
 // Extract PDF page text and resources
 // 1. Parse content streams for visible strings
 // 2. Parse object streams for resource dependencies, get strings
-// 3. Parse raw file for strings
+// 3. Parse link annotations for urls
+// 4. Extract embedded files and images as child files
+// 5. Parse raw file for strings
 func extractPdf(file *File) ([]string, error) {
 	var outputStrings []string
 	// Content stream visible text parse
-	outVisibleText, err := extractPdfVisibleText(file.fileBytes)
+	outVisibleText, err := extractPdfVisibleText(file)
 	if err != nil {
 		log.Printf("MODULE=extractPdfVisibleText ERROR=%s", err)
 	} else {
@@ -233,7 +342,7 @@ func extractPdf(file *File) ([]string, error) {
 		}
 	}
 	// Object stream resource dependency parse
-	outStreamText, err := extractPdfObjectStreams(file.fileBytes)
+	outStreamText, err := extractPdfObjectStreams(file)
 	if err != nil {
 		log.Printf("MODULE=extractPdfObjectStreams ERROR=%s", err)
 	} else {
@@ -241,6 +350,25 @@ func extractPdf(file *File) ([]string, error) {
 			outputStrings = append(outputStrings, stream)
 		}
 	}
+	// Link annotation targets, including those split across string escapes
+	links, err := extractPdfLinks(file)
+	if err != nil {
+		log.Printf("MODULE=extractPdfLinks ERROR=%s", err)
+	} else {
+		for _, link := range links {
+			outputStrings = append(outputStrings, link.Url)
+		}
+	}
+	// Embedded files and file attachment annotations become child files
+	err = extractPdfEmbeddedFiles(file)
+	if err != nil {
+		log.Printf("MODULE=extractPdfEmbeddedFiles ERROR=%s", err)
+	}
+	// Image XObjects become child files
+	err = extractPdfImages(file)
+	if err != nil {
+		log.Printf("MODULE=extractPdfImages ERROR=%s", err)
+	}
 	// Extract strings from whole raw file
 	rawStrings, err := extractStrings(file)
 	if err != nil {
@@ -277,23 +405,12 @@ func extractStrings(file *File) ([]string, error) {
 }
 
 // Extract visible text from content streams on PDF pages
-func extractPdfVisibleText(file []byte) ([]string, error) {
+func extractPdfVisibleText(file *File) ([]string, error) {
 	var outText []string
-	bytesReader := bytes.NewReader(file)
-	pdfReader, err := model.NewPdfReader(bytesReader)
+	pdfReader, err := newPdfReader(file)
 	if err != nil {
 		return nil, err
 	}
-	isEncrypted, err := pdfReader.IsEncrypted()
-	if err != nil {
-		return nil, err
-	}
-	if isEncrypted {
-		_, err = pdfReader.Decrypt([]byte(""))
-		if err != nil {
-			return nil, err
-		}
-	}
 	numPages, err := pdfReader.GetNumPages()
 	if err != nil {
 		return nil, err
@@ -351,38 +468,106 @@ func extractPdfVisibleText(file []byte) ([]string, error) {
 	return outText, nil
 }
 
-// Parse all PDF object streams, flatten and resolve values, return strings
-func extractPdfObjectStreams(file []byte) ([]string, error) {
+// Open a PDF reader, decrypting with the empty user password or the
+// file's candidate passwords when needed
+func newPdfReader(file *File) (*model.PdfReader, error) {
+	pdfReader, err := model.NewPdfReader(bytes.NewReader(file.fileBytes))
+	if err != nil {
+		return nil, err
+	}
+	isEncrypted, err := pdfReader.IsEncrypted()
+	if err != nil {
+		return nil, err
+	}
+	if isEncrypted {
+		_, ok := file.tryPasswords(func(password string) bool {
+			success, err := pdfReader.Decrypt([]byte(password))
+			return err == nil && success
+		})
+		if !ok {
+			return nil, ErrPasswordRequired
+		}
+	}
+	return pdfReader, nil
+}
+
+// Open a PDF object parser, decrypting like newPdfReader
+func newPdfParser(file *File) (*core.PdfParser, error) {
+	pdfParser, err := core.NewParser(bytes.NewReader(file.fileBytes))
+	if err != nil {
+		return nil, err
+	}
+	isEncrypted, err := pdfParser.IsEncrypted()
+	if err != nil {
+		return nil, err
+	}
+	if isEncrypted {
+		_, ok := file.tryPasswords(func(password string) bool {
+			success, err := pdfParser.Decrypt([]byte(password))
+			return err == nil && success
+		})
+		if !ok {
+			log.Printf("MODULE=newPdfParser FILE=%s ERROR=%s", file.fileName, ErrPasswordRequired)
+		}
+	}
+	return pdfParser, nil
+}
+
+// Parse all PDF objects listed by the cross-reference data and orphaned
+// by incremental updates, flatten values and decode streams, return strings
+// Binary streams only contribute their printable runs
+func extractPdfObjectStreams(file *File) ([]string, error) {
 	var outText []string
-	readSeeker := bytes.NewReader(file)
-	pdfParser, err := core.NewParser(readSeeker)
+	dumps, err := dumpPdfObjects(file)
 	if err != nil {
-		log.Printf("MODULE=extractPdfObjectStreams OPERATION=core.NewParser ERROR=%s", err)
-		} else {
-			// Parsed Object Streams
-			for i := 1; i < 1000; i++ {
-				numStream, err := pdfParser.LookupByNumber(i)
-				numStreamString := fmt.Sprintf("%v", numStream)
-				if err == nil {
-					if numStreamString == "null" || i > 1000 {
-						break
-						} else {
-							flatRef := core.FlattenObject(numStream)
-							outText = append(outText, fmt.Sprintf("%v", flatRef))
-						}
-				} else {
-					break
-				}
+		log.Printf("MODULE=extractPdfObjectStreams OPERATION=dumpPdfObjects ERROR=%s", err)
+	} else {
+		for _, dump := range dumps {
+			outText = append(outText, dump.Value)
+			if len(dump.Stream) == 0 {
+				continue
 			}
+			if printableText([]byte(dump.Stream)) {
+				outText = append(outText, dump.Stream)
+				continue
+			}
+			for _, binaryString := range ExtractBinaryStrings([]byte(dump.Stream), file.fileStringsOptions) {
+				outText = append(outText, binaryString.Value)
+			}
+		}
 	}
 	return outText, nil
 }
 
-// Extract and format urls, keeping only unique urls
+// Struct of url extraction options
+type UrlOptions struct {
+	// Recover defanged and obfuscated urls before matching
+	Refang bool
+	// Add the targets of Safe Links, Google and Proofpoint redirect wrappers
+	Unwrap bool
+	// Return canonical urls instead of the first form seen
+	Canonicalize bool
+	// Sort query parameters when canonicalizing
+	SortQuery bool
+	// Return urls in defanged form, safe to paste into tickets
+	Defang bool
+}
+
+// Url pattern used by UrlExtract
+var urlRe = regexp.MustCompile(`(http|ftp|https)://([\w_-]+(?:(?:\.[\w_-]+)+))([\w.,@?^=%&:/~+#-]*[\w@?^=%&/~+#-])?`)
+
+// Extract and format urls from a file and its child files, keeping only unique urls
 func (file *File) UrlExtract() []string {
+	return file.UrlExtractWithOptions(UrlOptions{})
+}
+
+// Extract urls with optional refang recovery and defanged output
+func (file *File) UrlExtractWithOptions(options UrlOptions) []string {
 	var urls []string
-	urlRe := regexp.MustCompile(`(http|ftp|https)://([\w_-]+(?:(?:\.[\w_-]+)+))([\w.,@?^=%&:/~+#-]*[\w@?^=%&/~+#-])?`)
-	for _, str := range file.fileStrings {
+	for _, str := range file.allStrings() {
+		if options.Refang {
+			str = RefangString(str)
+		}
 		urlMatch := urlRe.FindAllString(str, -1)
 		if urlMatch != nil {
 			for _, url := range urlMatch {
@@ -390,17 +575,40 @@ func (file *File) UrlExtract() []string {
 			}
 		}
 	}  
+	// Wrappers are kept next to their targets, the wrapper host is evidence too
+	if options.Unwrap {
+		var unwrapped []string
+		for _, url := range urls {
+			unwrapped = append(unwrapped, url)
+			if target := UnwrapUrl(url); target != url {
+				unwrapped = append(unwrapped, target)
+			}
+		}
+		urls = unwrapped
+	}
+	if options.Canonicalize {
+		for i, url := range urls {
+			urls[i], _ = CanonicalizeUrl(url, options.SortQuery)
+		}
+	}
 	urls = uniqueUrls(urls)
+	if options.Defang {
+		for i, url := range urls {
+			urls[i] = DefangUrl(url)
+		}
+	}
 	return urls
 }
 
-// Take a string slice and filter out duplicate values
+// Take a string slice and filter out duplicate values, urls are compared
+// in canonical form and the first form seen is kept
 func uniqueUrls(stringSlice []string) []string {
 	keys := make(map[string]bool)
 	list := []string{}
 	for _, entry := range stringSlice {
-		if _, value := keys[entry]; !value {
-			keys[entry] = true
+		key := urlKey(entry)
+		if _, value := keys[key]; !value {
+			keys[key] = true
 			list = append(list, entry)
 		}
 	}
@@ -0,0 +1,95 @@
+package goutils
+
+// Candidate password handling for encrypted documents and archive members
+// The empty password is always tried first, then the password that unlocked
+// an earlier item, the passwords given to Parse, and finally passwords
+// returned by the password callback, which is asked each attempt once per file
+
+import (
+	"errors"
+)
+
+// Callback returning the next candidate password for a file, ok is false
+// once the callback has no more passwords to offer
+type PasswordFunc func(fileName string, attempt int) (password string, ok bool)
+
+// Maximum number of passwords requested from a password callback per item
+const maxPasswordAttempts = 100
+
+// Error returned when no candidate password unlocks an encrypted item
+var ErrPasswordRequired = errors.New("encrypted content: no candidate password matched")
+
+// Set a callback asked for candidate passwords when an item is encrypted
+func (file *File) SetPasswordFunc(passwordFunc PasswordFunc) {
+	file.filePasswordFunc = passwordFunc
+}
+
+// Return the password that unlocked the file or its archive members
+func (file *File) GetPassword() string {
+	return file.filePassword
+}
+
+// Try candidate passwords until try succeeds, record a non empty match
+// Callback passwords join the candidate list so later items of the file
+// try them without asking the callback again
+func (file *File) tryPasswords(try func(password string) bool) (string, bool) {
+	if try("") {
+		return "", true
+	}
+	if len(file.filePassword) > 0 && try(file.filePassword) {
+		return file.filePassword, true
+	}
+	for _, password := range file.filePasswords {
+		if password != file.filePassword && try(password) {
+			file.filePassword = password
+			return password, true
+		}
+	}
+	if file.filePasswordFunc == nil {
+		return "", false
+	}
+	for file.filePasswordAttempt < maxPasswordAttempts {
+		password, ok := file.filePasswordFunc(file.fileName, file.filePasswordAttempt)
+		if !ok {
+			file.filePasswordAttempt = maxPasswordAttempts
+			break
+		}
+		file.filePasswordAttempt++
+		if len(password) == 0 || containsPassword(file.filePasswords, password) {
+			continue
+		}
+		file.filePasswords = append(file.filePasswords, password)
+		if try(password) {
+			file.filePassword = password
+			return password, true
+		}
+	}
+	return "", false
+}
+
+// Append candidate passwords that are not in the list yet
+func appendPasswords(list []string, passwords ...string) []string {
+	for _, password := range passwords {
+		if !containsPassword(list, password) {
+			list = append(list, password)
+		}
+	}
+	return list
+}
+
+// Check if a password is in a candidate list
+func containsPassword(list []string, password string) bool {
+	for _, candidate := range list {
+		if candidate == password {
+			return true
+		}
+	}
+	return false
+}
+
+// Record the password that decrypted an archive member on its child file
+func setMemberPassword(child *File, password string) {
+	if child != nil && len(password) > 0 {
+		child.fileAttributes["password"] = password
+	}
+}
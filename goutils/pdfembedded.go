@@ -0,0 +1,140 @@
+package goutils
+
+// PDF embedded file extraction
+// Walks the /EmbeddedFiles name tree and FileAttachment annotations,
+// each embedded file is loaded and parsed as a child file
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/unidoc/unipdf/core"
+)
+
+// Maximum depth of a PDF name tree walk
+const maxPdfNameTreeDepth = 32
+
+// Extract embedded files and file attachments from a PDF as child files
+func extractPdfEmbeddedFiles(file *File) error {
+	pdfReader, err := newPdfReader(file)
+	if err != nil {
+		return err
+	}
+	trailer, err := pdfReader.GetTrailer()
+	if err != nil {
+		return err
+	}
+	// Files referenced from both the name tree and an annotation are extracted once
+	seen := map[*core.PdfObjectStream]bool{}
+	// Document level embedded files from the names dictionary
+	if catalog, ok := core.GetDict(trailer.Get("Root")); ok {
+		if names, ok := core.GetDict(catalog.Get("Names")); ok {
+			walkPdfNameTree(names.Get("EmbeddedFiles"), 0, func(name string, fileSpec core.PdfObject) {
+				extractPdfFileSpec(file, fileSpec, name, "pdf_embedded_file", seen)
+			})
+		}
+	}
+	// Page level file attachment annotations
+	numPages, err := pdfReader.GetNumPages()
+	if err != nil {
+		return err
+	}
+	for i := 1; i <= numPages; i++ {
+		page, err := pdfReader.GetPage(i)
+		if err != nil {
+			log.Printf("MODULE=extractPdfEmbeddedFiles OPERATION=pdfReader.GetPage ERROR=%s", err)
+			continue
+		}
+		annots, ok := core.GetArray(page.Annots)
+		if !ok {
+			continue
+		}
+		for _, annot := range annots.Elements() {
+			annotDict, ok := core.GetDict(annot)
+			if !ok {
+				continue
+			}
+			if subtype, _ := core.GetNameVal(annotDict.Get("Subtype")); subtype != "FileAttachment" {
+				continue
+			}
+			extractPdfFileSpec(file, annotDict.Get("FS"), "", "pdf_file_attachment", seen)
+		}
+	}
+	return nil
+}
+
+// Walk a PDF name tree, calling visit for every name and value pair
+func walkPdfNameTree(node core.PdfObject, depth int, visit func(name string, value core.PdfObject)) {
+	nodeDict, ok := core.GetDict(node)
+	if !ok || depth > maxPdfNameTreeDepth {
+		return
+	}
+	if names, ok := core.GetArray(nodeDict.Get("Names")); ok {
+		elements := names.Elements()
+		for i := 0; i+1 < len(elements); i += 2 {
+			name := ""
+			if nameString, ok := core.GetString(elements[i]); ok {
+				name = nameString.Decoded()
+			}
+			visit(name, elements[i+1])
+		}
+	}
+	if kids, ok := core.GetArray(nodeDict.Get("Kids")); ok {
+		for _, kid := range kids.Elements() {
+			walkPdfNameTree(kid, depth+1, visit)
+		}
+	}
+}
+
+// Decode the embedded file stream of a file specification, add it as a child file
+func extractPdfFileSpec(file *File, fileSpec core.PdfObject, treeName string, source string, seen map[*core.PdfObjectStream]bool) {
+	specDict, ok := core.GetDict(fileSpec)
+	if !ok {
+		return
+	}
+	embedded, ok := core.GetDict(specDict.Get("EF"))
+	if !ok {
+		return
+	}
+	// Prefer the unicode file name, fall back to the name tree key
+	fileName := pdfDictString(specDict, "UF")
+	if len(fileName) == 0 {
+		fileName = pdfDictString(specDict, "F")
+	}
+	if len(fileName) == 0 {
+		fileName = treeName
+	}
+	stream, ok := core.GetStream(embedded.Get("UF"))
+	if !ok {
+		stream, ok = core.GetStream(embedded.Get("F"))
+	}
+	if !ok || seen[stream] {
+		return
+	}
+	seen[stream] = true
+	streamBytes, err := core.DecodeStream(stream)
+	if err != nil {
+		log.Printf("MODULE=extractPdfFileSpec OPERATION=core.DecodeStream FILE=%s ERROR=%s", fileName, err)
+		return
+	}
+	attributes := fileHashes(streamBytes)
+	attributes["source"] = source
+	attributes["declared_name"] = fileName
+	if description := pdfDictString(specDict, "Desc"); len(description) > 0 {
+		attributes["description"] = description
+	}
+	if subtype, ok := core.GetNameVal(stream.Get("Subtype")); ok {
+		attributes["declared_mime"] = subtype
+	}
+	// The optional /Params /CheckSum is an MD5 digest of the uncompressed file
+	if params, ok := core.GetDict(stream.Get("Params")); ok {
+		if checkSum, ok := core.GetStringBytes(params.Get("CheckSum")); ok {
+			declaredChecksum := hex.EncodeToString(checkSum)
+			attributes["declared_checksum"] = declaredChecksum
+			attributes["checksum_match"] = fmt.Sprintf("%t", strings.EqualFold(declaredChecksum, attributes["md5"]))
+		}
+	}
+	file.addChild(streamBytes, fileName, attributes)
+}
@@ -0,0 +1,188 @@
+package goutils
+
+// PDF metadata and document structure report
+// Info dictionary, XMP metadata, version, pages, encryption,
+// linearization, incremental updates and object types
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+
+	"github.com/unidoc/unipdf/core"
+	"github.com/unidoc/unipdf/core/security"
+)
+
+// Struct of a PDF document report
+type PdfInfo struct {
+	Title              string         `json:"title,omitempty"`
+	Author             string         `json:"author,omitempty"`
+	Subject            string         `json:"subject,omitempty"`
+	Keywords           string         `json:"keywords,omitempty"`
+	Creator            string         `json:"creator,omitempty"`
+	Producer           string         `json:"producer,omitempty"`
+	CreationDate       string         `json:"creation_date,omitempty"`
+	ModDate            string         `json:"mod_date,omitempty"`
+	Xmp                string         `json:"xmp,omitempty"`
+	Version            string         `json:"version"`
+	NumPages           int            `json:"num_pages"`
+	PageSizes          []PdfPageSize  `json:"page_sizes,omitempty"`
+	Encrypted          bool           `json:"encrypted"`
+	EncryptionMethod   string         `json:"encryption_method,omitempty"`
+	Permissions        []string       `json:"permissions,omitempty"`
+	Linearized         bool           `json:"linearized"`
+	IncrementalUpdates int            `json:"incremental_updates"`
+	ObjectTypes        map[string]int `json:"object_types,omitempty"`
+}
+
+// Struct of a PDF page size in points
+type PdfPageSize struct {
+	Page   int     `json:"page"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Map of PDF permission flags to report names
+var pdfPermissionNames = map[security.Permissions]string{
+	security.PermPrinting:          "print",
+	security.PermModify:            "modify",
+	security.PermExtractGraphics:   "extract",
+	security.PermAnnotate:          "annotate",
+	security.PermFillForms:         "fill_forms",
+	security.PermDisabilityExtract: "extract_accessibility",
+	security.PermRotateInsert:      "assemble",
+	security.PermFullPrintQuality:  "print_high_quality",
+}
+
+// Header comments followed by a first object holding /Linearized
+var pdfLinearizedRe = regexp.MustCompile(`^(?:\s*%[^\r\n]*)+\s*\d+\s+\d+\s+obj\s*<<[^>]*/Linearized`)
+
+// Build the PDF document report for a file
+func (file *File) GetPdfInfo() (*PdfInfo, error) {
+	if file.fileType != "application/pdf" {
+		return nil, fmt.Errorf("file type %s is not application/pdf", file.fileType)
+	}
+	pdfReader, err := newPdfReader(file)
+	if err != nil {
+		return nil, err
+	}
+	info := &PdfInfo{
+		Version:     pdfReader.PdfVersion().String(),
+		ObjectTypes: map[string]int{},
+	}
+	// Encryption algorithm and permissions granted to the password that opened the file
+	info.Encrypted, _ = pdfReader.IsEncrypted()
+	if info.Encrypted {
+		info.EncryptionMethod = pdfReader.GetEncryptionMethod()
+		_, perms, err := pdfReader.CheckAccessRights([]byte(file.filePassword))
+		if err != nil {
+			log.Printf("MODULE=GetPdfInfo OPERATION=pdfReader.CheckAccessRights ERROR=%s", err)
+		}
+		info.Permissions = pdfPermissionList(perms)
+	} else {
+		info.Permissions = pdfPermissionList(security.PermOwner)
+	}
+	// Info dictionary and XMP metadata stream
+	trailer, err := pdfReader.GetTrailer()
+	if err != nil {
+		log.Printf("MODULE=GetPdfInfo OPERATION=pdfReader.GetTrailer ERROR=%s", err)
+	} else {
+		if infoDict, ok := core.GetDict(trailer.Get("Info")); ok {
+			info.Title = pdfDictString(infoDict, "Title")
+			info.Author = pdfDictString(infoDict, "Author")
+			info.Subject = pdfDictString(infoDict, "Subject")
+			info.Keywords = pdfDictString(infoDict, "Keywords")
+			info.Creator = pdfDictString(infoDict, "Creator")
+			info.Producer = pdfDictString(infoDict, "Producer")
+			info.CreationDate = pdfDictString(infoDict, "CreationDate")
+			info.ModDate = pdfDictString(infoDict, "ModDate")
+		}
+		if catalog, ok := core.GetDict(trailer.Get("Root")); ok {
+			if xmpStream, ok := core.GetStream(catalog.Get("Metadata")); ok {
+				xmpBytes, err := core.DecodeStream(xmpStream)
+				if err != nil {
+					log.Printf("MODULE=GetPdfInfo OPERATION=core.DecodeStream ERROR=%s", err)
+				} else {
+					info.Xmp = string(xmpBytes)
+				}
+			}
+		}
+	}
+	// Page count and page sizes
+	numPages, err := pdfReader.GetNumPages()
+	if err != nil {
+		log.Printf("MODULE=GetPdfInfo OPERATION=pdfReader.GetNumPages ERROR=%s", err)
+	}
+	info.NumPages = numPages
+	for i := 1; i <= numPages; i++ {
+		page, err := pdfReader.GetPage(i)
+		if err != nil {
+			continue
+		}
+		mediaBox, err := page.GetMediaBox()
+		if err != nil {
+			continue
+		}
+		info.PageSizes = append(info.PageSizes, PdfPageSize{
+			Page:   i,
+			Width:  mediaBox.Width(),
+			Height: mediaBox.Height(),
+		})
+	}
+	// Object type counts
+	objectTypes, err := pdfReader.Inspect()
+	if err != nil {
+		log.Printf("MODULE=GetPdfInfo OPERATION=pdfReader.Inspect ERROR=%s", err)
+	} else {
+		info.ObjectTypes = objectTypes
+	}
+	// Linearization and incremental updates are read from the raw file
+	info.Linearized = pdfIsLinearized(file.fileBytes)
+	info.IncrementalUpdates = pdfIncrementalUpdates(file.fileBytes, info.Linearized)
+	return info, nil
+}
+
+// Return a text string value from a PDF dictionary
+func pdfDictString(dict *core.PdfObjectDictionary, key core.PdfObjectName) string {
+	str, ok := core.GetString(dict.Get(key))
+	if !ok {
+		return ""
+	}
+	return str.Decoded()
+}
+
+// Convert PDF permission flags to a sorted slice of names
+func pdfPermissionList(perms security.Permissions) []string {
+	var permissions []string
+	for perm, name := range pdfPermissionNames {
+		if perms.Allowed(perm) {
+			permissions = append(permissions, name)
+		}
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+// Check if the first object in the file is a linearization dictionary
+func pdfIsLinearized(fileBytes []byte) bool {
+	header := fileBytes
+	if len(header) > 1024 {
+		header = header[:1024]
+	}
+	return pdfLinearizedRe.Match(header)
+}
+
+// Count incremental updates appended after the original revision
+func pdfIncrementalUpdates(fileBytes []byte, linearized bool) int {
+	revisions := bytes.Count(fileBytes, []byte("%%EOF"))
+	// Linearized files carry a first page trailer with its own %%EOF
+	if linearized && revisions > 1 {
+		revisions--
+	}
+	if revisions <= 1 {
+		return 0
+	}
+	return revisions - 1
+}
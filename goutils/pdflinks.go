@@ -0,0 +1,300 @@
+package goutils
+
+// PDF link annotation parser
+// Resolves /Link annotations with /URI and /GoToR actions and recovers
+// the visible anchor text drawn under each annotation rectangle
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/unidoc/unipdf/contentstream"
+	"github.com/unidoc/unipdf/core"
+	"github.com/unidoc/unipdf/model"
+)
+
+// Struct of a link annotation on a PDF page
+type PdfLink struct {
+	Page       int        `json:"page"`
+	Rect       [4]float64 `json:"rect"`
+	Action     string     `json:"action"`
+	Url        string     `json:"url"`
+	AnchorText string     `json:"anchor_text,omitempty"`
+}
+
+// Struct of a run of text placed on a PDF page
+type pdfTextRun struct {
+	text  string
+	x     float64
+	y     float64
+	width float64
+}
+
+// Affine transformation matrix [a b c d e f] used by PDF content streams
+type pdfMatrix [6]float64
+
+var pdfIdentityMatrix = pdfMatrix{1, 0, 0, 1, 0, 0}
+
+// Multiply matrix m by matrix n
+func (m pdfMatrix) mult(n pdfMatrix) pdfMatrix {
+	return pdfMatrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// Transform a point by the matrix
+func (m pdfMatrix) transform(x float64, y float64) (float64, float64) {
+	return x*m[0] + y*m[2] + m[4], x*m[1] + y*m[3] + m[5]
+}
+
+// Extract link annotations from every page of a PDF file
+func (file *File) GetPdfLinks() ([]PdfLink, error) {
+	if file.fileType != "application/pdf" {
+		return nil, fmt.Errorf("file type %s is not application/pdf", file.fileType)
+	}
+	return extractPdfLinks(file)
+}
+
+// Walk page annotations and resolve link actions to urls
+func extractPdfLinks(file *File) ([]PdfLink, error) {
+	var links []PdfLink
+	pdfReader, err := newPdfReader(file)
+	if err != nil {
+		return nil, err
+	}
+	numPages, err := pdfReader.GetNumPages()
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i <= numPages; i++ {
+		page, err := pdfReader.GetPage(i)
+		if err != nil {
+			log.Printf("MODULE=extractPdfLinks OPERATION=pdfReader.GetPage ERROR=%s", err)
+			continue
+		}
+		annots, ok := core.GetArray(page.Annots)
+		if !ok {
+			continue
+		}
+		// Text runs are only placed for pages that carry annotations
+		var textRuns []pdfTextRun
+		textRunsLoaded := false
+		for _, annot := range annots.Elements() {
+			annotDict, ok := core.GetDict(annot)
+			if !ok {
+				continue
+			}
+			if subtype, _ := core.GetNameVal(annotDict.Get("Subtype")); subtype != "Link" {
+				continue
+			}
+			action, ok := core.GetDict(annotDict.Get("A"))
+			if !ok {
+				continue
+			}
+			link := PdfLink{Page: i}
+			link.Action, _ = core.GetNameVal(action.Get("S"))
+			switch link.Action {
+			case "URI":
+				link.Url = pdfObjectText(action.Get("URI"))
+			case "GoToR":
+				link.Url = pdfObjectText(action.Get("F"))
+			default:
+				continue
+			}
+			if len(link.Url) == 0 {
+				continue
+			}
+			if rect, ok := core.GetArray(annotDict.Get("Rect")); ok {
+				coords, err := rect.ToFloat64Array()
+				if err == nil && len(coords) == 4 {
+					// Normalize so that the first corner is the lower left
+					link.Rect = [4]float64{
+						minFloat(coords[0], coords[2]), minFloat(coords[1], coords[3]),
+						maxFloat(coords[0], coords[2]), maxFloat(coords[1], coords[3]),
+					}
+				}
+			}
+			if !textRunsLoaded {
+				textRuns, err = pdfPageTextRuns(page)
+				if err != nil {
+					log.Printf("MODULE=extractPdfLinks OPERATION=pdfPageTextRuns ERROR=%s", err)
+				}
+				textRunsLoaded = true
+			}
+			link.AnchorText = pdfAnchorText(textRuns, link.Rect)
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+// Return the text of runs whose baseline midpoint falls inside a rectangle
+func pdfAnchorText(textRuns []pdfTextRun, rect [4]float64) string {
+	var anchorText []string
+	for _, run := range textRuns {
+		midX := run.x + run.width/2
+		if midX >= rect[0] && midX <= rect[2] && run.y >= rect[1] && run.y <= rect[3] {
+			anchorText = append(anchorText, run.text)
+		}
+	}
+	return strings.TrimSpace(strings.Join(anchorText, " "))
+}
+
+// Place the text shown by a page content stream in user space
+func pdfPageTextRuns(page *model.PdfPage) ([]pdfTextRun, error) {
+	contentStreams, err := page.GetAllContentStreams()
+	if err != nil {
+		return nil, err
+	}
+	operations, err := contentstream.NewContentStreamParser(contentStreams).Parse()
+	if err != nil {
+		return nil, err
+	}
+	var textRuns []pdfTextRun
+	var pdFont *model.PdfFont
+	var ctmStack []pdfMatrix
+	ctm := pdfIdentityMatrix
+	tm := pdfIdentityMatrix
+	tlm := pdfIdentityMatrix
+	fontSize := 0.0
+	leading := 0.0
+	// Show a string at the current text matrix and advance it
+	showText := func(param core.PdfObject) {
+		charcodeBytes, ok := core.GetStringBytes(param)
+		if !ok {
+			return
+		}
+		var text string
+		width := 0.0
+		if pdFont != nil {
+			charcodes := pdFont.BytesToCharcodes(charcodeBytes)
+			runes, _, _ := pdFont.CharcodesToUnicodeWithStats(charcodes)
+			text = strings.Replace(string(runes), "\x00", "", -1)
+			for _, code := range charcodes {
+				metrics, found := pdFont.GetCharMetrics(code)
+				if found && metrics.Wx > 0 {
+					width += metrics.Wx / 1000 * fontSize
+				} else {
+					width += fontSize / 2
+				}
+			}
+		} else {
+			text = string(charcodeBytes)
+			width = float64(len(charcodeBytes)) * fontSize / 2
+		}
+		trm := tm.mult(ctm)
+		x, y := trm.transform(0, 0)
+		endX, _ := trm.transform(width, 0)
+		textRuns = append(textRuns, pdfTextRun{text: text, x: x, y: y, width: endX - x})
+		tm = pdfMatrix{1, 0, 0, 1, width, 0}.mult(tm)
+	}
+	// Move to the start of the next line
+	nextLine := func(tx float64, ty float64) {
+		tlm = pdfMatrix{1, 0, 0, 1, tx, ty}.mult(tlm)
+		tm = tlm
+	}
+	for _, op := range *operations {
+		params, _ := core.GetNumbersAsFloat(op.Params)
+		switch op.Operand {
+		case "q":
+			ctmStack = append(ctmStack, ctm)
+		case "Q":
+			if len(ctmStack) > 0 {
+				ctm = ctmStack[len(ctmStack)-1]
+				ctmStack = ctmStack[:len(ctmStack)-1]
+			}
+		case "cm":
+			if len(params) == 6 {
+				ctm = pdfMatrix{params[0], params[1], params[2], params[3], params[4], params[5]}.mult(ctm)
+			}
+		case "BT":
+			tm = pdfIdentityMatrix
+			tlm = pdfIdentityMatrix
+		case "Tf":
+			if len(op.Params) == 2 {
+				if name, ok := core.GetName(op.Params[0]); ok {
+					pdFont = nil
+					if page.Resources != nil {
+						if fontObj, found := page.Resources.GetFontByName(*name); found {
+							pdFont, _ = model.NewPdfFontFromPdfObject(fontObj)
+						}
+					}
+				}
+				fontSize, _ = core.GetNumberAsFloat(op.Params[1])
+			}
+		case "TL":
+			if len(params) == 1 {
+				leading = params[0]
+			}
+		case "Td":
+			if len(params) == 2 {
+				nextLine(params[0], params[1])
+			}
+		case "TD":
+			if len(params) == 2 {
+				leading = -params[1]
+				nextLine(params[0], params[1])
+			}
+		case "Tm":
+			if len(params) == 6 {
+				tlm = pdfMatrix{params[0], params[1], params[2], params[3], params[4], params[5]}
+				tm = tlm
+			}
+		case "T*":
+			nextLine(0, -leading)
+		case "Tj":
+			if len(op.Params) == 1 {
+				showText(op.Params[0])
+			}
+		case "'":
+			if len(op.Params) == 1 {
+				nextLine(0, -leading)
+				showText(op.Params[0])
+			}
+		case "\"":
+			if len(op.Params) == 3 {
+				nextLine(0, -leading)
+				showText(op.Params[2])
+			}
+		case "TJ":
+			if len(op.Params) != 1 {
+				continue
+			}
+			elements, ok := core.GetArray(op.Params[0])
+			if !ok {
+				continue
+			}
+			for _, element := range elements.Elements() {
+				// Numbers adjust the position in thousandths of text space
+				if adjust, err := core.GetNumberAsFloat(element); err == nil {
+					tm = pdfMatrix{1, 0, 0, 1, -adjust / 1000 * fontSize, 0}.mult(tm)
+					continue
+				}
+				showText(element)
+			}
+		}
+	}
+	return textRuns, nil
+}
+
+// Return the smaller of two floats
+func minFloat(a float64, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Return the larger of two floats
+func maxFloat(a float64, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
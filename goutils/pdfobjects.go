@@ -0,0 +1,164 @@
+package goutils
+
+// PDF object enumeration driven by cross-reference tables and streams
+// Includes objects held in compressed object streams and objects left
+// orphaned by incremental updates, with stream contents decoded except
+// for images and font programs
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/unidoc/unipdf/core"
+)
+
+// Struct of a dumped PDF object
+type PdfObjectDump struct {
+	Number     int      `json:"number"`
+	Generation int      `json:"generation"`
+	Source     string   `json:"source"`
+	Offset     int64    `json:"offset,omitempty"`
+	Container  int      `json:"container,omitempty"`
+	Type       string   `json:"type,omitempty"`
+	Subtype    string   `json:"subtype,omitempty"`
+	Value      string   `json:"value"`
+	Filters    []string `json:"filters,omitempty"`
+	Stream     string   `json:"stream,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Sources of a dumped PDF object
+const (
+	PdfObjectSourceXref         = "xref"
+	PdfObjectSourceObjectStream = "object_stream"
+	PdfObjectSourceOrphan       = "orphan"
+)
+
+var pdfObjectHeaderRe = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// Subtypes of compact font program streams, other font programs are
+// recognised by their Length1 entry
+var pdfFontProgramSubtypes = map[string]bool{
+	"Type1C":        true,
+	"CIDFontType0C": true,
+	"OpenType":      true,
+}
+
+// Dump every object of a PDF file
+func (file *File) GetPdfObjects() ([]PdfObjectDump, error) {
+	if file.fileType != "application/pdf" {
+		return nil, fmt.Errorf("file type %s is not application/pdf", file.fileType)
+	}
+	return dumpPdfObjects(file)
+}
+
+// Enumerate objects from the parsed cross-reference data, then scan the
+// raw file for object headers that no cross-reference entry points to
+func dumpPdfObjects(file *File) ([]PdfObjectDump, error) {
+	var dumps []PdfObjectDump
+	fileBytes := file.fileBytes
+	pdfParser, err := newPdfParser(file)
+	if err != nil {
+		return nil, err
+	}
+	xrefTable := pdfParser.GetXrefTable()
+	var objNums []int
+	xrefOffsets := map[[2]int]int64{}
+	for objNum, xref := range xrefTable.ObjectMap {
+		objNums = append(objNums, objNum)
+		if xref.XType != core.XrefTypeObjectStream {
+			xrefOffsets[[2]int{objNum, xref.Generation}] = xref.Offset
+		}
+	}
+	sort.Ints(objNums)
+	for _, objNum := range objNums {
+		xref := xrefTable.ObjectMap[objNum]
+		dump := PdfObjectDump{Number: objNum, Generation: xref.Generation}
+		if xref.XType == core.XrefTypeObjectStream {
+			dump.Source = PdfObjectSourceObjectStream
+			dump.Container = xref.OsObjNumber
+		} else {
+			dump.Source = PdfObjectSourceXref
+			dump.Offset = xref.Offset
+		}
+		obj, err := pdfParser.LookupByNumber(objNum)
+		if err != nil {
+			dump.Error = err.Error()
+		} else {
+			describePdfObject(&dump, obj)
+		}
+		dumps = append(dumps, dump)
+	}
+	// Object headers the cross-reference data does not reference. Earlier
+	// revisions of an object keep its number and generation, so the entry
+	// must also point at the header, only whitespace may separate them
+	for _, match := range pdfObjectHeaderRe.FindAllSubmatchIndex(fileBytes, -1) {
+		offset := int64(match[0])
+		objNum, _ := strconv.Atoi(string(fileBytes[match[2]:match[3]]))
+		generation, _ := strconv.Atoi(string(fileBytes[match[4]:match[5]]))
+		xrefOffset, ok := xrefOffsets[[2]int{objNum, generation}]
+		if ok && xrefOffset >= 0 && xrefOffset <= offset && len(bytes.TrimSpace(fileBytes[xrefOffset:offset])) == 0 {
+			continue
+		}
+		dump := PdfObjectDump{
+			Number:     objNum,
+			Generation: generation,
+			Source:     PdfObjectSourceOrphan,
+			Offset:     offset,
+		}
+		pdfParser.SetFileOffset(offset)
+		obj, err := pdfParser.ParseIndirectObject()
+		if err != nil {
+			dump.Error = err.Error()
+		} else {
+			describePdfObject(&dump, obj)
+		}
+		dumps = append(dumps, dump)
+	}
+	return dumps, nil
+}
+
+// Fill in the type, flattened value, filters and decoded stream of an
+// object, image and font program streams are not decoded
+func describePdfObject(dump *PdfObjectDump, obj core.PdfObject) {
+	if stream, ok := obj.(*core.PdfObjectStream); ok {
+		dump.Type, _ = core.GetNameVal(stream.Get("Type"))
+		dump.Subtype, _ = core.GetNameVal(stream.Get("Subtype"))
+		dump.Value = fmt.Sprintf("%v", core.FlattenObject(stream.PdfObjectDictionary))
+		dump.Filters = pdfStreamFilters(stream)
+		if dump.Subtype == "Image" || pdfFontProgramSubtypes[dump.Subtype] || stream.Get("Length1") != nil {
+			return
+		}
+		decoded, err := core.DecodeStream(stream)
+		if err != nil {
+			dump.Error = err.Error()
+			return
+		}
+		dump.Stream = string(decoded)
+		return
+	}
+	if dict, ok := core.GetDict(obj); ok {
+		dump.Type, _ = core.GetNameVal(dict.Get("Type"))
+	}
+	dump.Value = fmt.Sprintf("%v", core.FlattenObject(core.TraceToDirectObject(obj)))
+}
+
+// Return the names of the filters applied to a stream
+func pdfStreamFilters(stream *core.PdfObjectStream) []string {
+	var filters []string
+	filter := stream.Get("Filter")
+	if name, ok := core.GetNameVal(filter); ok {
+		return append(filters, name)
+	}
+	if array, ok := core.GetArray(filter); ok {
+		for _, element := range array.Elements() {
+			if name, ok := core.GetNameVal(element); ok {
+				filters = append(filters, name)
+			}
+		}
+	}
+	return filters
+}
@@ -0,0 +1,326 @@
+package goutils
+
+// Malicious PDF indicator detection, similar to pdfid
+// Counts risky keywords and names hiding them or their letters behind #xx
+// escapes, extracts JavaScript
+// and automatic actions by walking objects with the core parser
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+
+	"github.com/unidoc/unipdf/core"
+)
+
+// Struct of a PDF risk analysis
+type PdfRisk struct {
+	Keywords   map[string]int `json:"keywords"`
+	Obfuscated map[string]int `json:"obfuscated,omitempty"`
+	Scripts    []PdfScript    `json:"scripts,omitempty"`
+	Actions    []PdfAction    `json:"actions,omitempty"`
+	Score      int            `json:"score"`
+	Verdict    string         `json:"verdict"`
+	Reasons    []string       `json:"reasons,omitempty"`
+}
+
+// Struct of a JavaScript block found in a PDF object
+type PdfScript struct {
+	Object int    `json:"object"`
+	Source string `json:"source"`
+}
+
+// Struct of a PDF action and the trigger that runs it
+type PdfAction struct {
+	Object  int    `json:"object"`
+	Trigger string `json:"trigger"`
+	Type    string `json:"type"`
+	Target  string `json:"target,omitempty"`
+}
+
+// PDF risk verdicts
+const (
+	PdfVerdictClean      = "clean"
+	PdfVerdictSuspicious = "suspicious"
+	PdfVerdictMalicious  = "malicious"
+)
+
+// Keywords counted in the raw file and in object streams, as reported by pdfid
+var pdfRiskKeywords = []string{
+	"/Page", "/Encrypt", "/ObjStm", "/JS", "/JavaScript", "/AA", "/OpenAction",
+	"/AcroForm", "/JBIG2Decode", "/RichMedia", "/Launch", "/EmbeddedFile",
+	"/XFA", "/URI", "/SubmitForm", "/GoToR", "/GoToE", "/ImportData",
+}
+
+// Map of keywords to the score they add to the verdict when present
+var pdfRiskWeights = map[string]int{
+	"/JS":          2,
+	"/JavaScript":  2,
+	"/OpenAction":  1,
+	"/AA":          1,
+	"/Launch":      4,
+	"/SubmitForm":  2,
+	"/GoToR":       1,
+	"/GoToE":       1,
+	"/ImportData":  2,
+	"/RichMedia":   2,
+	"/XFA":         1,
+	"/JBIG2Decode": 1,
+}
+
+// Map of action types to the key holding the action target
+var pdfActionTargets = map[string]core.PdfObjectName{
+	"URI":        "URI",
+	"Launch":     "F",
+	"GoToR":      "F",
+	"GoToE":      "F",
+	"SubmitForm": "F",
+	"ImportData": "F",
+}
+
+var pdfNameRe = regexp.MustCompile(`/[^\s/\[\]<>(){}%]+`)
+
+// Maximum number of actions followed through /Next entries
+const maxPdfActionChain = 64
+
+// Run a PDF risk analysis on a file
+func (file *File) GetPdfRisk() (*PdfRisk, error) {
+	if file.fileType != "application/pdf" {
+		return nil, fmt.Errorf("file type %s is not application/pdf", file.fileType)
+	}
+	pdfParser, err := newPdfParser(file)
+	if err != nil {
+		return nil, err
+	}
+	risk := &PdfRisk{
+		Keywords:   map[string]int{},
+		Obfuscated: map[string]int{},
+	}
+	countPdfKeywords(file.fileBytes, risk)
+	for _, objNum := range pdfParser.GetObjectNums() {
+		obj, err := pdfParser.LookupByNumber(objNum)
+		if err != nil {
+			continue
+		}
+		// Names hidden inside compressed object streams are counted as well
+		if stream, ok := obj.(*core.PdfObjectStream); ok && stream.Get("Filter") != nil {
+			if streamType, _ := core.GetNameVal(stream.Get("Type")); streamType == "ObjStm" {
+				decoded, err := core.DecodeStream(stream)
+				if err == nil {
+					countPdfKeywords(decoded, risk)
+				}
+			}
+		}
+		inspectPdfRiskObject(objNum, obj, risk)
+	}
+	scorePdfRisk(risk)
+	return risk, nil
+}
+
+// Count risky keywords and obfuscated names in PDF bytes. Escapes of
+// spaces and punctuation are common in font names, only escaped keywords
+// and escaped letters or digits count as obfuscation
+func countPdfKeywords(pdfBytes []byte, risk *PdfRisk) {
+	for _, rawName := range pdfNameRe.FindAll(pdfBytes, -1) {
+		name := decodePdfName(rawName)
+		keyword := false
+		for _, riskKeyword := range pdfRiskKeywords {
+			if name == riskKeyword {
+				risk.Keywords[riskKeyword]++
+				keyword = true
+				break
+			}
+		}
+		if name != string(rawName) && (keyword || escapesAlphanumeric(rawName)) {
+			risk.Obfuscated[name]++
+		}
+	}
+}
+
+// Check whether a raw PDF name escapes a letter or a digit
+func escapesAlphanumeric(rawName []byte) bool {
+	for i := 0; i+2 < len(rawName); i++ {
+		if rawName[i] != '#' {
+			continue
+		}
+		decoded, err := hex.DecodeString(string(rawName[i+1 : i+3]))
+		if err == nil && isWordByte(decoded[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Decode #xx hex escapes in a PDF name
+func decodePdfName(rawName []byte) string {
+	if !bytes.Contains(rawName, []byte("#")) {
+		return string(rawName)
+	}
+	var name []byte
+	for i := 0; i < len(rawName); i++ {
+		if rawName[i] == '#' && i+2 < len(rawName) {
+			decoded, err := hex.DecodeString(string(rawName[i+1 : i+3]))
+			if err == nil {
+				name = append(name, decoded...)
+				i += 2
+				continue
+			}
+		}
+		name = append(name, rawName[i])
+	}
+	return string(name)
+}
+
+// Record scripts and actions held by a PDF object
+func inspectPdfRiskObject(objNum int, obj core.PdfObject, risk *PdfRisk) {
+	var dict *core.PdfObjectDictionary
+	if stream, ok := obj.(*core.PdfObjectStream); ok {
+		dict = stream.PdfObjectDictionary
+	} else if objDict, ok := core.GetDict(obj); ok {
+		dict = objDict
+	}
+	if dict == nil {
+		return
+	}
+	if js := dict.Get("JS"); js != nil {
+		if source := pdfScriptSource(js); len(source) > 0 {
+			risk.Scripts = append(risk.Scripts, PdfScript{Object: objNum, Source: source})
+		}
+	}
+	if openAction := dict.Get("OpenAction"); openAction != nil {
+		recordPdfAction(objNum, "OpenAction", openAction, risk)
+	}
+	if additional, ok := core.GetDict(dict.Get("AA")); ok {
+		for _, trigger := range additional.Keys() {
+			recordPdfAction(objNum, "AA/"+string(trigger), additional.Get(trigger), risk)
+		}
+	}
+	// Link and widget annotations carry their action in /A
+	if action := dict.Get("A"); action != nil {
+		recordPdfAction(objNum, "A", action, risk)
+	}
+}
+
+// Record an action dictionary and the actions chained by its /Next entry,
+// destinations arrays are ignored
+func recordPdfAction(objNum int, trigger string, action core.PdfObject, risk *PdfRisk) {
+	followed := 0
+	var record func(trigger string, action core.PdfObject)
+	record = func(trigger string, action core.PdfObject) {
+		if followed >= maxPdfActionChain {
+			return
+		}
+		followed++
+		actionDict, ok := core.GetDict(action)
+		if !ok {
+			return
+		}
+		addPdfAction(objNum, trigger, action, actionDict, risk)
+		// The next action is a dictionary or an array of dictionaries
+		next := actionDict.Get("Next")
+		if array, ok := core.GetArray(next); ok {
+			for _, element := range array.Elements() {
+				record(trigger+"/Next", element)
+			}
+		} else if next != nil {
+			record(trigger+"/Next", next)
+		}
+	}
+	record(trigger, action)
+}
+
+// Record one action dictionary with its target and inline script
+func addPdfAction(objNum int, trigger string, action core.PdfObject, actionDict *core.PdfObjectDictionary, risk *PdfRisk) {
+	actionType, _ := core.GetNameVal(actionDict.Get("S"))
+	if len(actionType) == 0 {
+		return
+	}
+	// Indirect actions are inspected as objects of their own, with their script
+	if _, indirect := action.(*core.PdfObjectReference); !indirect {
+		if js := actionDict.Get("JS"); js != nil {
+			if source := pdfScriptSource(js); len(source) > 0 {
+				risk.Scripts = append(risk.Scripts, PdfScript{Object: objNum, Source: source})
+			}
+		}
+	}
+	pdfAction := PdfAction{Object: objNum, Trigger: trigger, Type: actionType}
+	if targetKey, ok := pdfActionTargets[actionType]; ok {
+		pdfAction.Target = pdfObjectText(actionDict.Get(targetKey))
+		// Launch actions may hold the target in a platform dictionary
+		if len(pdfAction.Target) == 0 {
+			if win, ok := core.GetDict(actionDict.Get("Win")); ok {
+				pdfAction.Target = pdfObjectText(win.Get("F"))
+			}
+		}
+	}
+	risk.Actions = append(risk.Actions, pdfAction)
+}
+
+// Return the source of a /JS entry held in a string or a stream
+func pdfScriptSource(js core.PdfObject) string {
+	if stream, ok := core.GetStream(js); ok {
+		decoded, err := core.DecodeStream(stream)
+		if err != nil {
+			log.Printf("MODULE=pdfScriptSource OPERATION=core.DecodeStream ERROR=%s", err)
+			return ""
+		}
+		return string(decoded)
+	}
+	return pdfObjectText(js)
+}
+
+// Return the text of a string, name or file specification object
+func pdfObjectText(obj core.PdfObject) string {
+	if str, ok := core.GetString(obj); ok {
+		return str.Decoded()
+	}
+	if name, ok := core.GetNameVal(obj); ok {
+		return name
+	}
+	if fileSpec, ok := core.GetDict(obj); ok {
+		if fileName := pdfDictString(fileSpec, "UF"); len(fileName) > 0 {
+			return fileName
+		}
+		return pdfDictString(fileSpec, "F")
+	}
+	return ""
+}
+
+// Score the indicators and assign a verdict
+func scorePdfRisk(risk *PdfRisk) {
+	var keywords []string
+	for keyword := range pdfRiskWeights {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	for _, keyword := range keywords {
+		if count := risk.Keywords[keyword]; count > 0 {
+			risk.Score += pdfRiskWeights[keyword]
+			risk.Reasons = append(risk.Reasons, fmt.Sprintf("%s count=%d", keyword, count))
+		}
+	}
+	// Writers escape only characters that need it, escaped letters hide keywords
+	if len(risk.Obfuscated) > 0 {
+		risk.Score += 3
+		risk.Reasons = append(risk.Reasons, fmt.Sprintf("obfuscated_names count=%d", len(risk.Obfuscated)))
+	}
+	// Scripts that run on open are the classic exploit delivery
+	for _, action := range risk.Actions {
+		if action.Type == "JavaScript" && action.Trigger != "A" {
+			risk.Score += 2
+			risk.Reasons = append(risk.Reasons, fmt.Sprintf("automatic_javascript object=%d trigger=%s", action.Object, action.Trigger))
+			break
+		}
+	}
+	switch {
+	case risk.Score >= 5:
+		risk.Verdict = PdfVerdictMalicious
+	case risk.Score > 0:
+		risk.Verdict = PdfVerdictSuspicious
+	default:
+		risk.Verdict = PdfVerdictClean
+	}
+}
@@ -0,0 +1,466 @@
+package goutils
+
+// PE import, export and resource directories
+// debug/pe skips ordinal imports, so the directories are walked directly
+
+import (
+	"bytes"
+	"crypto/md5"
+	"debug/pe"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PE data directory indexes
+const (
+	peDirectoryExport   = 0
+	peDirectoryImport   = 1
+	peDirectoryResource = 2
+	peDirectorySecurity = 4
+)
+
+// PE section characteristics
+const (
+	peSectionExecute = 0x20000000
+	peSectionRead    = 0x40000000
+	peSectionWrite   = 0x80000000
+)
+
+// Limits on the walked directories of malformed files
+const (
+	maxPeImports       = 65536
+	maxPeExports       = 65536
+	maxPeResourceDepth = 3
+	maxPeResources     = 4096
+)
+
+// Names of PE machine types
+var peMachines = map[uint16]string{
+	pe.IMAGE_FILE_MACHINE_I386:  "i386",
+	pe.IMAGE_FILE_MACHINE_AMD64: "amd64",
+	pe.IMAGE_FILE_MACHINE_ARM:   "arm",
+	pe.IMAGE_FILE_MACHINE_ARMNT: "arm",
+	pe.IMAGE_FILE_MACHINE_ARM64: "arm64",
+	pe.IMAGE_FILE_MACHINE_IA64:  "ia64",
+}
+
+// Names of the predefined resource types
+var peResourceTypes = map[uint32]string{
+	1:  "CURSOR",
+	2:  "BITMAP",
+	3:  "ICON",
+	4:  "MENU",
+	5:  "DIALOG",
+	6:  "STRING",
+	7:  "FONTDIR",
+	8:  "FONT",
+	9:  "ACCELERATOR",
+	10: "RCDATA",
+	11: "MESSAGETABLE",
+	12: "GROUP_CURSOR",
+	14: "GROUP_ICON",
+	16: "VERSION",
+	17: "DLGINCLUDE",
+	19: "PLUGPLAY",
+	20: "VXD",
+	21: "ANICURSOR",
+	22: "ANIICON",
+	23: "HTML",
+	24: "MANIFEST",
+}
+
+// Resource types carrying arbitrary data, extracted as child files
+var peResourceChildTypes = map[string]bool{
+	"RCDATA": true,
+	"HTML":   true,
+}
+
+// Extensions dropped from library names in the imphash
+var imphashExtensions = map[string]bool{
+	"dll": true,
+	"ocx": true,
+	"sys": true,
+}
+
+// Reader of a PE image mapping relative virtual addresses to file offsets
+type peImage struct {
+	data     []byte
+	sections []*pe.Section
+	is64     bool
+}
+
+// Return the file offset of a relative virtual address
+func (image *peImage) offset(rva uint32) (int, bool) {
+	for _, section := range image.sections {
+		size := section.VirtualSize
+		if section.Size > size {
+			size = section.Size
+		}
+		if rva >= section.VirtualAddress && rva < section.VirtualAddress+size {
+			offset := int(rva - section.VirtualAddress + section.Offset)
+			return offset, offset < len(image.data)
+		}
+	}
+	// Headers are mapped at their file offsets
+	if len(image.sections) == 0 || rva < image.sections[0].VirtualAddress {
+		return int(rva), int(rva) < len(image.data)
+	}
+	return 0, false
+}
+
+// Return the bytes at a relative virtual address, truncated to the file
+func (image *peImage) bytes(rva uint32, size uint32) []byte {
+	offset, ok := image.offset(rva)
+	if !ok {
+		return nil
+	}
+	end := offset + int(size)
+	if end > len(image.data) || end < offset {
+		end = len(image.data)
+	}
+	return image.data[offset:end]
+}
+
+// Return the NUL terminated string at a relative virtual address
+func (image *peImage) cString(rva uint32) string {
+	data := image.bytes(rva, 512)
+	if end := bytes.IndexByte(data, 0); end >= 0 {
+		data = data[:end]
+	}
+	return string(data)
+}
+
+// Analyze a PE executable, returning the strings of its version info,
+// manifest and string tables, data resources become child files
+func analyzePe(file *File) (*ExecutableInfo, []string, error) {
+	peFile, err := pe.NewFile(bytes.NewReader(file.fileBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer peFile.Close()
+	image := &peImage{data: file.fileBytes, sections: peFile.Sections}
+	executable := &ExecutableInfo{
+		Format:       "pe",
+		Architecture: peMachines[peFile.Machine],
+		Bits:         32,
+	}
+	if len(executable.Architecture) == 0 {
+		executable.Architecture = fmt.Sprintf("0x%04x", peFile.Machine)
+	}
+	if peFile.TimeDateStamp != 0 {
+		executable.Timestamp = time.Unix(int64(peFile.TimeDateStamp), 0).UTC().Format(time.RFC3339)
+	}
+	var directories []pe.DataDirectory
+	var directoryCount uint32
+	switch header := peFile.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		executable.EntryPoint = uint64(header.AddressOfEntryPoint)
+		directories, directoryCount = header.DataDirectory[:], header.NumberOfRvaAndSizes
+	case *pe.OptionalHeader64:
+		image.is64 = true
+		executable.Bits = 64
+		executable.EntryPoint = uint64(header.AddressOfEntryPoint)
+		directories, directoryCount = header.DataDirectory[:], header.NumberOfRvaAndSizes
+	}
+	if directoryCount < uint32(len(directories)) {
+		directories = directories[:directoryCount]
+	}
+	var end uint64
+	for _, section := range peFile.Sections {
+		entry := ExecutableSection{
+			Name:        section.Name,
+			Address:     uint64(section.VirtualAddress),
+			VirtualSize: uint64(section.VirtualSize),
+			Offset:      uint64(section.Offset),
+			Size:        uint64(section.Size),
+			Permissions: permissionString(section.Characteristics&peSectionRead != 0, section.Characteristics&peSectionWrite != 0, section.Characteristics&peSectionExecute != 0),
+		}
+		if data, err := section.Data(); err == nil {
+			entry.Entropy = shannonEntropy(data)
+		}
+		if entry.Offset+entry.Size > end {
+			end = entry.Offset + entry.Size
+		}
+		executable.Sections = append(executable.Sections, entry)
+	}
+	// MinGW binaries keep a COFF symbol table and its string table after the sections
+	if symbols := uint64(peFile.PointerToSymbolTable); symbols >= end && peFile.NumberOfSymbols > 0 {
+		stringTable := symbols + uint64(peFile.NumberOfSymbols)*18
+		end = stringTable + uint64(readUint32(file.fileBytes, int(stringTable)))
+	}
+	// The authenticode signature is appended after the sections and is not an overlay,
+	// its directory address is a file offset
+	if len(directories) > peDirectorySecurity {
+		security := directories[peDirectorySecurity]
+		if security.Size > 0 && uint64(security.VirtualAddress) == end {
+			end += uint64(security.Size)
+		}
+	}
+	executable.OverlayOffset, executable.OverlaySize = overlayRange(len(file.fileBytes), end)
+	if len(directories) > peDirectoryImport {
+		executable.Libraries, executable.Imports, executable.Imphash = image.imports(directories[peDirectoryImport])
+	}
+	if len(directories) > peDirectoryExport {
+		executable.Exports = image.exports(directories[peDirectoryExport])
+	}
+	var resourceStrings []string
+	if len(directories) > peDirectoryResource && directories[peDirectoryResource].Size > 0 {
+		resourceStrings = image.resources(file, executable, directories[peDirectoryResource])
+	}
+	return executable, resourceStrings, nil
+}
+
+// Walk the import directory, returning libraries, library!function imports
+// and the imphash. Ordinals are listed as ordN and hashed by name where
+// pefile knows the library
+func (image *peImage) imports(directory pe.DataDirectory) ([]string, []string, string) {
+	var libraries, imports, hashed []string
+	if directory.VirtualAddress == 0 {
+		return nil, nil, ""
+	}
+	thunkSize := uint32(4)
+	ordinalFlag := uint64(0x80000000)
+	if image.is64 {
+		thunkSize = 8
+		ordinalFlag = 0x8000000000000000
+	}
+	for descriptor := directory.VirtualAddress; len(imports) < maxPeImports; descriptor += 20 {
+		data := image.bytes(descriptor, 20)
+		if len(data) < 20 {
+			break
+		}
+		originalThunk, nameRva, firstThunk := readUint32(data, 0), readUint32(data, 12), readUint32(data, 16)
+		if originalThunk == 0 && nameRva == 0 && firstThunk == 0 {
+			break
+		}
+		library := image.cString(nameRva)
+		libraries = append(libraries, library)
+		hashLibrary := strings.ToLower(library)
+		if dot := strings.LastIndexByte(hashLibrary, '.'); dot >= 0 && imphashExtensions[hashLibrary[dot+1:]] {
+			hashLibrary = hashLibrary[:dot]
+		}
+		thunk := originalThunk
+		if thunk == 0 {
+			thunk = firstThunk
+		}
+		for ; len(imports) < maxPeImports; thunk += thunkSize {
+			data := image.bytes(thunk, thunkSize)
+			if uint32(len(data)) < thunkSize {
+				break
+			}
+			value := uint64(readUint32(data, 0))
+			if image.is64 {
+				value |= uint64(readUint32(data, 4)) << 32
+			}
+			if value == 0 {
+				break
+			}
+			var function, hashFunction string
+			if value&ordinalFlag != 0 {
+				function = fmt.Sprintf("ord%d", value&0xffff)
+				hashFunction = peOrdinalName(library, uint16(value))
+			} else {
+				// Import by name entries start with a two byte hint
+				function = image.cString(uint32(value) + 2)
+				hashFunction = function
+			}
+			imports = append(imports, library+"!"+function)
+			hashed = append(hashed, hashLibrary+"."+strings.ToLower(hashFunction))
+		}
+	}
+	if len(hashed) == 0 {
+		return libraries, imports, ""
+	}
+	imphash := md5.Sum([]byte(strings.Join(hashed, ",")))
+	return libraries, imports, hex.EncodeToString(imphash[:])
+}
+
+// Walk the export directory, returning the exported names
+func (image *peImage) exports(directory pe.DataDirectory) []string {
+	data := image.bytes(directory.VirtualAddress, 40)
+	if directory.VirtualAddress == 0 || len(data) < 40 {
+		return nil
+	}
+	count := readUint32(data, 24)
+	namesRva := readUint32(data, 32)
+	if count > maxPeExports {
+		count = maxPeExports
+	}
+	names := image.bytes(namesRva, count*4)
+	var exports []string
+	for i := 0; i+4 <= len(names); i += 4 {
+		if name := image.cString(readUint32(names, i)); len(name) > 0 {
+			exports = append(exports, name)
+		}
+	}
+	return exports
+}
+
+// Walk the resource tree of type, name and language levels, record the
+// leaves and parse version info, manifests and string tables
+func (image *peImage) resources(file *File, executable *ExecutableInfo, directory pe.DataDirectory) []string {
+	base, ok := image.offset(directory.VirtualAddress)
+	if !ok {
+		return nil
+	}
+	var resourceStrings []string
+	visited := map[int]bool{}
+	var walk func(offset int, depth int, path []string)
+	walk = func(offset int, depth int, path []string) {
+		if depth >= maxPeResourceDepth || visited[offset] || len(executable.Resources) >= maxPeResources {
+			return
+		}
+		visited[offset] = true
+		entries := int(readUint16(image.data, base+offset+12)) + int(readUint16(image.data, base+offset+14))
+		for i := 0; i < entries && len(executable.Resources) < maxPeResources; i++ {
+			entry := base + offset + 16 + 8*i
+			if entry+8 > len(image.data) {
+				return
+			}
+			nameField, dataField := readUint32(image.data, entry), readUint32(image.data, entry+4)
+			name := fmt.Sprint(nameField)
+			if nameField&0x80000000 != 0 {
+				name = image.resourceName(base + int(nameField&0x7fffffff))
+			} else if depth == 0 && len(peResourceTypes[nameField]) > 0 {
+				name = peResourceTypes[nameField]
+			}
+			if dataField&0x80000000 != 0 {
+				walk(int(dataField&0x7fffffff), depth+1, append(path, name))
+				continue
+			}
+			// Leaves at the language level point at a data entry holding an RVA
+			leaf := base + int(dataField)
+			if len(path) != 2 || leaf+8 > len(image.data) {
+				continue
+			}
+			data := image.bytes(readUint32(image.data, leaf), readUint32(image.data, leaf+4))
+			dataOffset, _ := image.offset(readUint32(image.data, leaf))
+			resource := ExecutableResource{
+				Type:     path[0],
+				Name:     path[1],
+				Language: int(nameField & 0xffff),
+				Offset:   int64(dataOffset),
+				Size:     int64(len(data)),
+				Entropy:  shannonEntropy(data),
+			}
+			executable.Resources = append(executable.Resources, resource)
+			resourceStrings = append(resourceStrings, peResourceStrings(file, executable, resource, data)...)
+		}
+	}
+	walk(0, 0, nil)
+	return resourceStrings
+}
+
+// Return the length prefixed UTF-16 name of a named resource entry
+func (image *peImage) resourceName(offset int) string {
+	length := int(readUint16(image.data, offset))
+	if offset+2+2*length > len(image.data) {
+		return ""
+	}
+	return string(decodeUtf16(image.data[offset+2:offset+2+2*length], false))
+}
+
+// Return the strings of a resource, data resources and custom named types
+// become child files
+func peResourceStrings(file *File, executable *ExecutableInfo, resource ExecutableResource, data []byte) []string {
+	switch resource.Type {
+	case "VERSION":
+		if executable.VersionInfo == nil {
+			executable.VersionInfo = map[string]string{}
+		}
+		parseVersionInfo(data, 0, executable.VersionInfo)
+		var values []string
+		for key, value := range executable.VersionInfo {
+			values = append(values, key+": "+value)
+		}
+		return values
+	case "MANIFEST":
+		executable.Manifest = string(data)
+		return []string{executable.Manifest}
+	case "STRING":
+		// String tables hold 16 length prefixed UTF-16 strings
+		var values []string
+		for offset := 0; offset+2 <= len(data); {
+			length := int(readUint16(data, offset))
+			offset += 2
+			if offset+2*length > len(data) {
+				break
+			}
+			if length > 0 {
+				values = append(values, string(decodeUtf16(data[offset:offset+2*length], false)))
+			}
+			offset += 2 * length
+		}
+		return values
+	}
+	if peResourceChildTypes[resource.Type] || (!isPredefinedResourceType(resource.Type) && len(data) > 0) {
+		attributes := fileHashes(data)
+		attributes["source"] = "pe_resource"
+		attributes["resource_type"] = resource.Type
+		attributes["resource_name"] = resource.Name
+		fileName := fmt.Sprintf("resource_%s_%s.bin", resource.Type, resource.Name)
+		file.addChild(data, fileName, attributes)
+	}
+	return nil
+}
+
+// Check whether a resource type name is predefined, the others are custom types
+func isPredefinedResourceType(name string) bool {
+	for _, predefined := range peResourceTypes {
+		if name == predefined {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse the blocks of a VS_VERSIONINFO resource, collecting the key and
+// value pairs of its string tables. Blocks are a length, a value length,
+// a type, a UTF-16 key and a value, each aligned to 32 bits
+func parseVersionInfo(data []byte, depth int, values map[string]string) {
+	for offset := 0; offset+6 <= len(data) && depth < 4; {
+		length := int(readUint16(data, offset))
+		if length < 6 {
+			return
+		}
+		end := offset + length
+		if end > len(data) {
+			end = len(data)
+		}
+		valueLength := int(readUint16(data, offset+2))
+		valueType := readUint16(data, offset+4)
+		keyEnd := offset + 6
+		for keyEnd+2 <= end && readUint16(data, keyEnd) != 0 {
+			keyEnd += 2
+		}
+		key := string(decodeUtf16(data[offset+6:keyEnd], false))
+		valueOffset := align32(keyEnd + 2)
+		// Entries of a StringTable, below StringFileInfo, are text values
+		if depth == 3 {
+			valueEnd := valueOffset
+			for valueEnd+2 <= end && readUint16(data, valueEnd) != 0 {
+				valueEnd += 2
+			}
+			if valueOffset < valueEnd {
+				values[key] = string(decodeUtf16(data[valueOffset:valueEnd], false))
+			}
+		} else {
+			valueSize := valueLength
+			if valueType == 1 {
+				valueSize = 2 * valueLength
+			}
+			// VarFileInfo holds binary translation tables only
+			if childOffset := align32(valueOffset + valueSize); childOffset < end && key != "VarFileInfo" {
+				parseVersionInfo(data[childOffset:end], depth+1, values)
+			}
+		}
+		offset = align32(end)
+	}
+}
+
+// Round an offset up to a 32 bit boundary
+func align32(offset int) int {
+	return (offset + 3) &^ 3
+}
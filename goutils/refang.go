@@ -0,0 +1,97 @@
+package goutils
+
+// Defanged and obfuscated url recovery, and the reverse defang output
+// Handles hxxp schemes, bracketed dots, percent encoding, HTML entities,
+// escaped slashes and urls split across string concatenations
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Defanged schemes such as hxxp, hXXps and fxp
+var defangedSchemeRe = regexp.MustCompile(`(?i)\b(h[xX*]{2}p(s?)|fxp|fxps)(\[?:\]?//|\[://\])`)
+
+// Bracketed or spelled out separators
+var defangedDotRe = regexp.MustCompile(`(?i)\s?(?:\[\.\]|\(\.\)|\{\.\}|\[dot\]|\(dot\)|\{dot\})\s?`)
+var defangedColonRe = regexp.MustCompile(`\[:\]|\(:\)`)
+var defangedSlashRe = regexp.MustCompile(`\[/\]`)
+var defangedAtRe = regexp.MustCompile(`(?i)\[@\]|\(@\)|\[at\]|\(at\)`)
+var defangedSchemeSeparatorRe = regexp.MustCompile(`\[://\]|\[:/\]/`)
+
+// Escape sequences used by scripts to hide urls
+var escapedSlashRe = regexp.MustCompile(`\\+/`)
+var jsHexEscapeRe = regexp.MustCompile(`\\x([0-9a-fA-F]{2})`)
+var jsUnicodeEscapeRe = regexp.MustCompile(`\\u([0-9a-fA-F]{4})`)
+var percentEncodingRe = regexp.MustCompile(`(?:%[0-9a-fA-F]{2})+`)
+
+// Quoted string concatenation in scripts and macros, "htt" + "p://"
+var stringConcatRe = regexp.MustCompile(`["']\s*(?:\+|&)\s*["']`)
+
+// Recover a defanged or obfuscated string to plain text
+func RefangString(str string) string {
+	str = html.UnescapeString(str)
+	str = jsHexEscapeRe.ReplaceAllStringFunc(str, decodeEscape)
+	str = jsUnicodeEscapeRe.ReplaceAllStringFunc(str, decodeEscape)
+	str = escapedSlashRe.ReplaceAllString(str, "/")
+	str = percentEncodingRe.ReplaceAllStringFunc(str, decodePrintablePercent)
+	str = stringConcatRe.ReplaceAllString(str, "")
+	str = defangedSchemeSeparatorRe.ReplaceAllString(str, "://")
+	str = defangedDotRe.ReplaceAllString(str, ".")
+	str = defangedColonRe.ReplaceAllString(str, ":")
+	str = defangedSlashRe.ReplaceAllString(str, "/")
+	str = defangedAtRe.ReplaceAllString(str, "@")
+	str = defangedSchemeRe.ReplaceAllStringFunc(str, func(scheme string) string {
+		lower := strings.ToLower(scheme)
+		switch {
+		case strings.HasPrefix(lower, "fxp"):
+			return "ftp" + lower[3:strings.Index(lower, ":")] + "://"
+		default:
+			return "http" + lower[4:strings.Index(lower, ":")] + "://"
+		}
+	})
+	return str
+}
+
+// Decode a \xhh or \uhhhh escape
+func decodeEscape(escape string) string {
+	value, err := strconv.ParseUint(escape[2:], 16, 32)
+	if err != nil {
+		return escape
+	}
+	return string(rune(value))
+}
+
+// Decode a percent encoded run when every byte is printable ASCII
+func decodePrintablePercent(encoded string) string {
+	decoded, err := url.PathUnescape(encoded)
+	if err != nil {
+		return encoded
+	}
+	for i := 0; i < len(decoded); i++ {
+		if decoded[i] <= 0x20 || decoded[i] >= 0x7f {
+			return encoded
+		}
+	}
+	return decoded
+}
+
+// Defang a url: hxxp scheme, bracketed scheme separator and host dots
+func DefangUrl(rawUrl string) string {
+	schemeEnd := strings.Index(rawUrl, "://")
+	if schemeEnd < 0 {
+		return strings.Replace(rawUrl, ".", "[.]", -1)
+	}
+	scheme := strings.ToLower(rawUrl[:schemeEnd])
+	scheme = strings.Replace(strings.Replace(scheme, "http", "hxxp", 1), "ftp", "fxp", 1)
+	rest := rawUrl[schemeEnd+3:]
+	hostEnd := strings.IndexAny(rest, "/?#")
+	if hostEnd < 0 {
+		hostEnd = len(rest)
+	}
+	host := strings.Replace(rest[:hostEnd], ".", "[.]", -1)
+	return scheme + "[://]" + host + rest[hostEnd:]
+}
@@ -0,0 +1,202 @@
+package goutils
+
+// Parser registry
+// Content sniffers and parsers are tried in a fixed order of priority, so
+// the parser chosen for a file does not change between runs
+
+import (
+	"path"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Parser of a file type, returning the strings of the file
+type ParserFunc func(file *File) ([]string, error)
+
+// Content sniffer returning the MIME type and extension of the bytes it
+// recognizes, empty strings otherwise
+type SnifferFunc func(fileBytes []byte) (string, string)
+
+// Struct of a registered parser. Mime is matched as a prefix of the
+// detected file type, Extensions against the file name
+type ParserInfo struct {
+	Mime       string   `json:"mime"`
+	Priority   int      `json:"priority"`
+	Extensions []string `json:"extensions,omitempty"`
+	Parser     string   `json:"parser"`
+	parse      ParserFunc
+	order      int
+}
+
+// Struct of a registered content sniffer, fallback sniffers only run on
+// content the magic byte detection does not recognize
+type snifferInfo struct {
+	priority int
+	fallback bool
+	sniff    SnifferFunc
+	order    int
+}
+
+// Registered parsers and sniffers, kept in resolution order
+var (
+	registryMutex   sync.RWMutex
+	parserRegistry  []ParserInfo
+	snifferRegistry []snifferInfo
+	registryOrder   int
+)
+
+// Parser of files no registered parser matches
+var defaultParser ParserFunc = extractBinaryStrings
+
+// Register a parser for a MIME type or MIME prefix, with optional file name
+// extensions. Higher priorities win, then MIME matches over extension
+// matches, then longer MIME prefixes, then earlier registrations.
+// Registering a MIME type again replaces its parser
+func RegisterParser(mime string, priority int, parser ParserFunc, extensions ...string) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	var normalized []string
+	for _, extension := range extensions {
+		normalized = append(normalized, strings.ToLower(strings.TrimPrefix(extension, ".")))
+	}
+	info := ParserInfo{
+		Mime:       mime,
+		Priority:   priority,
+		Extensions: normalized,
+		Parser:     parserName(parser),
+		parse:      parser,
+		order:      registryOrder,
+	}
+	registryOrder++
+	replaced := false
+	for i := range parserRegistry {
+		if parserRegistry[i].Mime == mime {
+			info.order = parserRegistry[i].order
+			parserRegistry[i] = info
+			replaced = true
+		}
+	}
+	if !replaced {
+		parserRegistry = append(parserRegistry, info)
+	}
+	sort.SliceStable(parserRegistry, func(i, j int) bool {
+		a, b := parserRegistry[i], parserRegistry[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if len(a.Mime) != len(b.Mime) {
+			return len(a.Mime) > len(b.Mime)
+		}
+		return a.order < b.order
+	})
+}
+
+// Register a content sniffer, run before magic byte detection in order of
+// priority
+func RegisterSniffer(priority int, sniffer SnifferFunc) {
+	registerSniffer(snifferInfo{priority: priority, sniff: sniffer})
+}
+
+// Register a fallback content sniffer, run in order of priority only when
+// magic byte detection does not recognize the content
+func RegisterFallbackSniffer(priority int, sniffer SnifferFunc) {
+	registerSniffer(snifferInfo{priority: priority, fallback: true, sniff: sniffer})
+}
+
+// Add a sniffer to the registry in resolution order
+func registerSniffer(info snifferInfo) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	info.order = registryOrder
+	snifferRegistry = append(snifferRegistry, info)
+	registryOrder++
+	sort.SliceStable(snifferRegistry, func(i, j int) bool {
+		if snifferRegistry[i].priority != snifferRegistry[j].priority {
+			return snifferRegistry[i].priority > snifferRegistry[j].priority
+		}
+		return snifferRegistry[i].order < snifferRegistry[j].order
+	})
+}
+
+// Return the registered parsers in resolution order
+func Parsers() []ParserInfo {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return append([]ParserInfo{}, parserRegistry...)
+}
+
+// Return the type found by the first matching sniffer of the fallback or
+// the leading sniffers
+func sniffFileType(fileBytes []byte, fallback bool) (string, string) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	for _, sniffer := range snifferRegistry {
+		if sniffer.fallback != fallback {
+			continue
+		}
+		if fileType, fileExtension := sniffer.sniff(fileBytes); len(fileType) > 0 {
+			return fileType, fileExtension
+		}
+	}
+	return "", ""
+}
+
+// Return the parser of a file type and file name, the default binary
+// strings parser when none matches
+func lookupParser(fileType string, fileName string) ParserFunc {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	extension := strings.ToLower(strings.TrimPrefix(path.Ext(fileName), "."))
+	var best *ParserInfo
+	bestByMime := false
+	for i := range parserRegistry {
+		info := &parserRegistry[i]
+		byMime := strings.HasPrefix(fileType, info.Mime)
+		if !byMime && !info.hasExtension(extension) {
+			continue
+		}
+		// The registry is sorted, a later entry only wins on a MIME match
+		// at the priority of an extension match
+		if best == nil || (info.Priority == best.Priority && byMime && !bestByMime) {
+			best, bestByMime = info, byMime
+		}
+	}
+	if best == nil {
+		return defaultParser
+	}
+	return best.parse
+}
+
+// Check whether a parser is registered for a file name extension
+func (info *ParserInfo) hasExtension(extension string) bool {
+	if len(extension) == 0 {
+		return false
+	}
+	for _, registered := range info.Extensions {
+		if registered == extension {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the function name of a parser for listings
+func parserName(parser ParserFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(parser).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// Override the parser of a file
+func (file *File) SetParser(parser ParserFunc) {
+	file.fileParseMethod = parser
+}
+
+// Override the detected type of a file, selecting the parser registered for it
+func (file *File) SetFileType(fileType string, fileExtension string) {
+	file.fileType = fileType
+	file.fileExtension = fileExtension
+	file.fileParseMethod = lookupParser(fileType, file.fileName)
+}
@@ -0,0 +1,42 @@
+package goutils
+
+// Parse report of a file and its child files
+
+// Struct of a parsed file report
+type ParseReport struct {
+	FileName      string            `json:"file_name"`
+	FileType      string            `json:"file_type"`
+	FileExtension string            `json:"file_extension"`
+	Charset       string            `json:"charset,omitempty"`
+	TypeCheck     *FileTypeCheck    `json:"type_check,omitempty"`
+	Entropy       *EntropyAnalysis  `json:"entropy,omitempty"`
+	Attributes    map[string]string `json:"attributes,omitempty"`
+	Password      string            `json:"password,omitempty"`
+	Decoded       []DecodedString   `json:"decoded,omitempty"`
+	Executable    *ExecutableInfo   `json:"executable,omitempty"`
+	Image         *ImageMetadata    `json:"image,omitempty"`
+	Rules         []RuleMatch       `json:"rules,omitempty"`
+	Children      []*ParseReport    `json:"children,omitempty"`
+}
+
+// Build the parse report of a file and its child files
+func (file *File) Report() *ParseReport {
+	report := &ParseReport{
+		FileName:      file.fileName,
+		FileType:      file.fileType,
+		FileExtension: file.fileExtension,
+		Charset:       file.fileCharset,
+		TypeCheck:     file.fileTypeCheck,
+		Entropy:       file.fileEntropy,
+		Attributes:    file.fileAttributes,
+		Password:      file.filePassword,
+		Decoded:       file.fileDecoded,
+		Executable:    file.fileExecutable,
+		Image:         file.fileImage,
+		Rules:         file.fileRuleMatches,
+	}
+	for _, child := range file.fileChildren {
+		report.Children = append(report.Children, child.Report())
+	}
+	return report
+}
@@ -0,0 +1,651 @@
+package goutils
+
+// RTF tokenizer
+// Plain text with \'hh and \uN escapes decoded, \objdata OLE objects and
+// \pict images extracted as child files, and malformed or obfuscated
+// control words flagged as anomalies
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Struct of a parsed RTF document
+type RtfDocument struct {
+	Text      string      `json:"text"`
+	Objects   []RtfObject `json:"objects,omitempty"`
+	Pictures  int         `json:"pictures"`
+	Anomalies []string    `json:"anomalies,omitempty"`
+}
+
+// Struct of an embedded or linked OLE object
+type RtfObject struct {
+	Class      string `json:"class"`
+	Size       int    `json:"size"`
+	FileName   string `json:"file_name,omitempty"`
+	Link       string `json:"link,omitempty"`
+	AutoUpdate bool   `json:"auto_update"`
+}
+
+// Signature of an OLE2 compound file
+var cfbSignature = []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}
+
+// Limits of the RTF tokenizer, Word itself rejects longer control words
+const (
+	maxRtfControlWord = 32
+	maxRtfParameter   = 10
+	maxRtfDepth       = 1000
+)
+
+// Destinations whose text is not part of the document body
+var rtfSkippedDestinations = map[string]bool{
+	"fonttbl": true, "colortbl": true, "stylesheet": true, "info": true,
+	"listtable": true, "listoverridetable": true, "revtbl": true, "rsidtbl": true,
+	"generator": true, "themedata": true, "colorschememapping": true,
+	"datastore": true, "latentstyles": true, "xmlnstbl": true, "filetbl": true,
+	"pgdsctbl": true, "nonshppict": true, "bkmkstart": true, "bkmkend": true,
+}
+
+// Starred destinations the tokenizer understands, other starred groups are skipped
+var rtfKnownStarredDestinations = map[string]bool{
+	"fldinst": true, "objdata": true, "objclass": true, "shppict": true,
+}
+
+// Control words producing text
+var rtfControlText = map[string]string{
+	"par": "\n", "line": "\n", "sect": "\n", "page": "\n", "row": "\n",
+	"tab": "\t", "cell": "\t", "emdash": "—", "endash": "–",
+	"lquote": "‘", "rquote": "’", "ldblquote": "“", "rdblquote": "”", "bullet": "•",
+}
+
+// Picture formats by blip control word
+var rtfPictureFormats = map[string]string{
+	"pngblip": "png", "jpegblip": "jpg", "emfblip": "emf", "wmetafile": "wmf",
+	"dibitmap": "bmp", "wbitmap": "bmp", "macpict": "pict",
+}
+
+// Object classes used by well known RTF exploits
+var rtfSuspiciousClasses = map[string]string{
+	"equation.3":      "Equation Editor object (CVE-2017-11882)",
+	"ole2link":        "OLE2Link object (CVE-2017-0199)",
+	"package":         "OLE Package object",
+	"word.document.8": "nested Word document object",
+}
+
+// Binary data collected by an \objdata or \pict destination
+type rtfData struct {
+	bytes  []byte
+	nibble int
+	format string
+}
+
+// Embedded object being assembled from its \object group
+type rtfObjectState struct {
+	class      strings.Builder
+	data       *rtfData
+	autoUpdate bool
+}
+
+// Tokenizer state of an RTF group, starredIn is the destination the group
+// was in when \* replaced it
+type rtfGroup struct {
+	destination string
+	starredIn   string
+	skip        bool
+	uc          int
+	data        *rtfData
+	object      *rtfObjectState
+}
+
+// Tokenizer of an RTF document
+type rtfParser struct {
+	file      *File
+	data      []byte
+	pos       int
+	group     rtfGroup
+	stack     []rtfGroup
+	text      strings.Builder
+	pending   []byte
+	skipChars int
+	codepage  string
+	document  *RtfDocument
+	anomalies map[string]bool
+}
+
+// Return the parsed RTF document of an RTF file
+func (file *File) GetRtf() *RtfDocument {
+	return file.fileRtf
+}
+
+// Sniff RTF, Word opens RTF with a truncated header, a common evasion
+func sniffRtf(fileBytes []byte) (string, string) {
+	if bytes.HasPrefix(fileBytes, []byte(`{\rt`)) {
+		return "application/rtf", "rtf"
+	}
+	return "", ""
+}
+
+// Extract text from RTF, embedded objects and pictures become child files
+func extractRtf(file *File) ([]string, error) {
+	parser := &rtfParser{
+		file:      file,
+		data:      file.fileBytes,
+		group:     rtfGroup{uc: 1},
+		codepage:  defaultCharset,
+		document:  &RtfDocument{},
+		anomalies: map[string]bool{},
+	}
+	parser.parse()
+	file.fileRtf = parser.document
+	var rtfStrings []string
+	if len(parser.document.Text) > 0 {
+		rtfStrings = append(rtfStrings, parser.document.Text)
+	}
+	for _, object := range parser.document.Objects {
+		if len(object.Link) > 0 {
+			rtfStrings = append(rtfStrings, object.Link)
+		}
+	}
+	return rtfStrings, nil
+}
+
+// Tokenize the whole document
+func (parser *rtfParser) parse() {
+	if !bytes.HasPrefix(parser.data, []byte(`{\rtf1`)) {
+		parser.anomaly(`missing {\rtf1 header`)
+	}
+	closed := false
+	for parser.pos < len(parser.data) {
+		c := parser.data[parser.pos]
+		parser.pos++
+		switch c {
+		case '{':
+			parser.flushPending()
+			if len(parser.stack) >= maxRtfDepth {
+				parser.anomaly(fmt.Sprintf("group nesting deeper than %d", maxRtfDepth))
+			}
+			parser.stack = append(parser.stack, parser.group)
+		case '}':
+			parser.flushPending()
+			if len(parser.stack) == 0 {
+				parser.anomaly("unbalanced closing brace")
+				continue
+			}
+			parser.closeGroup()
+			if len(parser.stack) == 0 {
+				closed = true
+			}
+		case '\\':
+			parser.controlWord()
+		case '\r', '\n':
+		default:
+			if closed && c > ' ' {
+				parser.anomaly("data after the final closing brace")
+				closed = false
+			}
+			parser.character(c)
+		}
+	}
+	parser.flushPending()
+	if len(parser.stack) > 0 {
+		parser.anomaly("unbalanced opening brace")
+		for len(parser.stack) > 0 {
+			parser.closeGroup()
+		}
+	}
+	parser.document.Text = strings.TrimSpace(parser.text.String())
+	for anomaly := range parser.anomalies {
+		parser.document.Anomalies = append(parser.document.Anomalies, anomaly)
+	}
+	sort.Strings(parser.document.Anomalies)
+}
+
+// Pop a group, finishing pictures and objects that end with it
+func (parser *rtfParser) closeGroup() {
+	closing := parser.group
+	parser.group = parser.stack[len(parser.stack)-1]
+	parser.stack = parser.stack[:len(parser.stack)-1]
+	if closing.data != nil && closing.data != parser.group.data && closing.destination == "pict" {
+		parser.addPicture(closing.data)
+	}
+	if closing.object != nil && closing.object != parser.group.object {
+		parser.addObject(closing.object)
+	}
+}
+
+// Handle a literal character of the current destination
+func (parser *rtfParser) character(c byte) {
+	if parser.group.data != nil {
+		parser.hexDigit(c)
+		return
+	}
+	if parser.group.destination == "objclass" && parser.group.object != nil {
+		parser.group.object.class.WriteByte(c)
+		return
+	}
+	if parser.skipChars > 0 {
+		parser.skipChars--
+		return
+	}
+	if parser.group.skip {
+		return
+	}
+	parser.pending = append(parser.pending, c)
+}
+
+// Add a hex digit to binary destination data
+func (parser *rtfParser) hexDigit(c byte) {
+	data := parser.group.data
+	var value int
+	switch {
+	case c >= '0' && c <= '9':
+		value = int(c - '0')
+	case c >= 'a' && c <= 'f':
+		value = int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		value = int(c-'A') + 10
+	case c == ' ' || c == '\t':
+		return
+	default:
+		parser.anomaly(fmt.Sprintf("non-hex characters in %s data", parser.group.destination))
+		return
+	}
+	if data.nibble < 0 {
+		data.nibble = value
+		return
+	}
+	data.bytes = append(data.bytes, byte(data.nibble<<4|value))
+	data.nibble = -1
+}
+
+// Parse a control word or control symbol after a backslash
+func (parser *rtfParser) controlWord() {
+	if parser.pos >= len(parser.data) {
+		return
+	}
+	c := parser.data[parser.pos]
+	if !isAsciiLetter(c) {
+		parser.pos++
+		parser.controlSymbol(c)
+		return
+	}
+	start := parser.pos
+	for parser.pos < len(parser.data) && isAsciiLetter(parser.data[parser.pos]) {
+		parser.pos++
+	}
+	word := string(parser.data[start:parser.pos])
+	if len(word) > maxRtfControlWord {
+		parser.anomaly(fmt.Sprintf("control word longer than %d letters", maxRtfControlWord))
+	}
+	paramStart := parser.pos
+	if parser.pos < len(parser.data) && parser.data[parser.pos] == '-' {
+		parser.pos++
+	}
+	for parser.pos < len(parser.data) && parser.data[parser.pos] >= '0' && parser.data[parser.pos] <= '9' {
+		parser.pos++
+	}
+	paramText := string(parser.data[paramStart:parser.pos])
+	if len(strings.TrimPrefix(paramText, "-")) > maxRtfParameter {
+		parser.anomaly(fmt.Sprintf("numeric parameter longer than %d digits", maxRtfParameter))
+	}
+	param, hasParam := 0, false
+	if value, err := strconv.Atoi(paramText); err == nil {
+		param, hasParam = value, true
+	}
+	// A single space delimits the control word and is not part of the text
+	if parser.pos < len(parser.data) && parser.data[parser.pos] == ' ' {
+		parser.pos++
+	}
+	parser.handleWord(word, param, hasParam)
+}
+
+// Handle a control symbol, a backslash followed by a non-letter
+func (parser *rtfParser) controlSymbol(c byte) {
+	switch c {
+	case '\'':
+		if parser.pos+2 > len(parser.data) {
+			parser.anomaly(`truncated \'hh escape`)
+			return
+		}
+		value, err := strconv.ParseUint(string(parser.data[parser.pos:parser.pos+2]), 16, 8)
+		parser.pos += 2
+		if err != nil {
+			parser.anomaly(`invalid \'hh escape`)
+			return
+		}
+		if parser.group.data != nil {
+			parser.anomaly(fmt.Sprintf(`\'hh escape in %s data`, parser.group.destination))
+			return
+		}
+		parser.character(byte(value))
+	case '*':
+		parser.group.starredIn = parser.group.destination
+		parser.group.destination = "*"
+	case '~':
+		parser.character(' ')
+	case '\\', '{', '}':
+		parser.character(c)
+	case '\r', '\n':
+		parser.flushPending()
+		parser.emit("\n")
+	case '-', '_', ':', '|':
+	default:
+		parser.anomaly("unknown control symbol")
+	}
+}
+
+// Handle a control word with its optional numeric parameter
+func (parser *rtfParser) handleWord(word string, param int, hasParam bool) {
+	parser.flushPending()
+	starred := parser.group.destination == "*"
+	if starred && !rtfKnownStarredDestinations[word] {
+		// Unknown groups inside object data are ignored by Word and used to
+		// hide junk, pictures carry starred properties such as \blipuid
+		if parser.group.starredIn == "objdata" {
+			parser.anomaly("ignorable destinations inside binary data")
+		}
+		parser.group.destination = word
+		parser.group.skip = true
+		parser.group.data = nil
+		return
+	}
+	if parser.group.data != nil && word != "bin" && rtfPictureFormats[word] == "" {
+		// Picture properties precede the hex data, anything else inside object data is obfuscation
+		if parser.group.destination == "objdata" {
+			parser.anomaly("control words inside objdata")
+		}
+		return
+	}
+	switch {
+	case word == "ansicpg" && hasParam:
+		parser.codepage = rtfCodepageCharset(param)
+	case word == "uc" && hasParam:
+		parser.group.uc = param
+	case word == "u" && hasParam:
+		if param < 0 {
+			param += 65536
+		}
+		parser.emit(string(rune(param)))
+		parser.skipChars = parser.group.uc
+	case word == "bin" && hasParam:
+		parser.binary(param)
+	case word == "object":
+		parser.group.object = &rtfObjectState{}
+	case word == "objupdate":
+		if parser.group.object != nil {
+			parser.group.object.autoUpdate = true
+		}
+	case word == "objdata":
+		parser.group.destination = word
+		parser.group.data = &rtfData{nibble: -1}
+		if parser.group.object == nil {
+			parser.group.object = &rtfObjectState{}
+		}
+		parser.group.object.data = parser.group.data
+	case word == "objclass":
+		parser.group.destination = word
+	case word == "pict":
+		parser.group.destination = word
+		parser.group.data = &rtfData{nibble: -1}
+	case rtfPictureFormats[word] != "":
+		if parser.group.data != nil {
+			parser.group.data.format = rtfPictureFormats[word]
+		}
+	case rtfSkippedDestinations[word]:
+		parser.group.destination = word
+		parser.group.skip = true
+	case rtfControlText[word] != "":
+		parser.emit(rtfControlText[word])
+	default:
+		if starred {
+			parser.group.destination = word
+		}
+	}
+}
+
+// Read \binN raw bytes into the current destination
+func (parser *rtfParser) binary(length int) {
+	if length < 0 || parser.pos+length > len(parser.data) {
+		parser.anomaly(`\bin length beyond the end of the file`)
+		length = len(parser.data) - parser.pos
+	}
+	if parser.group.destination == "objdata" {
+		parser.anomaly(`\bin inside objdata`)
+	}
+	if parser.group.data != nil {
+		parser.group.data.bytes = append(parser.group.data.bytes, parser.data[parser.pos:parser.pos+length]...)
+	}
+	parser.pos += length
+}
+
+// Append decoded text to the document body
+func (parser *rtfParser) emit(str string) {
+	if parser.group.skip || parser.group.data != nil || parser.group.destination == "objclass" {
+		return
+	}
+	parser.text.WriteString(str)
+}
+
+// Decode pending \'hh and literal bytes in the document codepage
+func (parser *rtfParser) flushPending() {
+	if len(parser.pending) == 0 {
+		return
+	}
+	pending := parser.pending
+	parser.pending = nil
+	decoded, err := TranscodeToUtf8(pending, parser.codepage)
+	if err != nil {
+		decoded = pending
+	}
+	parser.emit(string(decoded))
+}
+
+// Record an anomaly once
+func (parser *rtfParser) anomaly(anomaly string) {
+	parser.anomalies[anomaly] = true
+}
+
+// Add a finished picture as an image child file
+func (parser *rtfParser) addPicture(data *rtfData) {
+	if len(data.bytes) == 0 {
+		return
+	}
+	parser.document.Pictures++
+	format := data.format
+	if len(format) == 0 {
+		format = "bin"
+	}
+	addImageChild(parser.file, data.bytes, fmt.Sprintf("rtf_pict_%d.%s", parser.document.Pictures, format), "rtf_pict")
+}
+
+// Add a finished embedded object as a child file
+func (parser *rtfParser) addObject(state *rtfObjectState) {
+	object := RtfObject{
+		Class:      strings.TrimRight(strings.TrimSpace(state.class.String()), ";"),
+		AutoUpdate: state.autoUpdate,
+	}
+	if state.autoUpdate {
+		parser.anomaly(`object updates automatically (\objupdate)`)
+	}
+	if state.data == nil || len(state.data.bytes) == 0 {
+		parser.document.Objects = append(parser.document.Objects, object)
+		return
+	}
+	if state.data.nibble >= 0 {
+		parser.anomaly("odd number of hex digits in objdata")
+	}
+	objectBytes := state.data.bytes
+	object.Size = len(objectBytes)
+	childName := fmt.Sprintf("rtf_object_%d.bin", len(parser.document.Objects)+1)
+	className, nativeData, link, err := parseOle1Object(objectBytes)
+	if err != nil {
+		log.Printf("MODULE=extractRtf OPERATION=parseOle1Object ERROR=%s", err)
+		nativeData = objectBytes
+	}
+	if len(className) > 0 {
+		object.Class = className
+	}
+	object.Link = link
+	if description, ok := rtfSuspiciousClasses[strings.ToLower(object.Class)]; ok {
+		parser.anomaly(description)
+	}
+	if strings.EqualFold(object.Class, "Package") {
+		fileName, content, err := parseOlePackage(nativeData)
+		if err == nil {
+			object.FileName = fileName
+			childName = fileName
+			nativeData = content
+		} else {
+			log.Printf("MODULE=extractRtf OPERATION=parseOlePackage ERROR=%s", err)
+		}
+	}
+	parser.document.Objects = append(parser.document.Objects, object)
+	if len(nativeData) == 0 {
+		return
+	}
+	attributes := fileHashes(nativeData)
+	attributes["source"] = "rtf_object"
+	attributes["object_class"] = object.Class
+	attributes["auto_update"] = strconv.FormatBool(object.AutoUpdate)
+	parser.file.addChild(nativeData, childName, attributes)
+}
+
+// Parse an OLE1 ObjectHeader, returning the class name and the native data
+// of embedded objects or the link source of linked objects
+func parseOle1Object(data []byte) (string, []byte, string, error) {
+	// Some objects carry an OLE2 compound file without an OLE1 header
+	if bytes.HasPrefix(data, cfbSignature) {
+		return "", data, "", nil
+	}
+	reader := bytes.NewReader(data)
+	var version, format uint32
+	if err := binary.Read(reader, binary.LittleEndian, &version); err != nil {
+		return "", nil, "", err
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &format); err != nil {
+		return "", nil, "", err
+	}
+	className, err := readOleString(reader)
+	if err != nil {
+		return "", nil, "", err
+	}
+	topicName, err := readOleString(reader)
+	if err != nil {
+		return className, nil, "", err
+	}
+	if _, err = readOleString(reader); err != nil {
+		return className, nil, "", err
+	}
+	switch format {
+	case 1:
+		// Linked objects name their source in the topic
+		return className, nil, topicName, nil
+	case 2:
+		var size uint32
+		if err := binary.Read(reader, binary.LittleEndian, &size); err != nil {
+			return className, nil, "", err
+		}
+		native := data[len(data)-reader.Len():]
+		if int(size) < len(native) {
+			native = native[:size]
+		}
+		return className, native, "", nil
+	}
+	return className, nil, "", fmt.Errorf("unknown OLE1 format id %d", format)
+}
+
+// Read an OLE1 length prefixed ANSI string
+func readOleString(reader *bytes.Reader) (string, error) {
+	var length uint32
+	if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+	if int(length) > reader.Len() {
+		return "", fmt.Errorf("string length %d beyond the end of the object", length)
+	}
+	str := make([]byte, length)
+	if _, err := io.ReadFull(reader, str); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(str), "\x00"), nil
+}
+
+// Read a zero terminated string
+func readZeroTerminated(reader *bytes.Reader) (string, error) {
+	var str []byte
+	for {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if c == 0 {
+			return string(str), nil
+		}
+		str = append(str, c)
+	}
+}
+
+// Parse OLE Package native data into the packaged file name and content
+func parseOlePackage(native []byte) (string, []byte, error) {
+	reader := bytes.NewReader(native)
+	var header uint16
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return "", nil, err
+	}
+	fileName, err := readZeroTerminated(reader)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err = readZeroTerminated(reader); err != nil {
+		return "", nil, err
+	}
+	var unknown [2]uint32
+	if err := binary.Read(reader, binary.LittleEndian, &unknown); err != nil {
+		return "", nil, err
+	}
+	if _, err = readZeroTerminated(reader); err != nil {
+		return "", nil, err
+	}
+	var size uint32
+	if err := binary.Read(reader, binary.LittleEndian, &size); err != nil {
+		return "", nil, err
+	}
+	if int(size) > reader.Len() {
+		return "", nil, fmt.Errorf("package size %d beyond the end of the object", size)
+	}
+	content := native[len(native)-reader.Len():][:size]
+	return fileName, content, nil
+}
+
+// Map a Windows codepage number to a charset name
+func rtfCodepageCharset(codepage int) string {
+	switch codepage {
+	case 932:
+		return "shift_jis"
+	case 936:
+		return "gbk"
+	case 949:
+		return "euc-kr"
+	case 950:
+		return "big5"
+	case 65001:
+		return "utf-8"
+	case 874:
+		return "windows-874"
+	}
+	if codepage >= 1250 && codepage <= 1258 {
+		return "windows-" + strconv.Itoa(codepage)
+	}
+	return defaultCharset
+}
+
+// Check for an ASCII letter, control words are made of letters only
+func isAsciiLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
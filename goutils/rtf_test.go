@@ -0,0 +1,36 @@
+package goutils
+
+import (
+	"testing"
+)
+
+// Return the anomalies of a parsed RTF document
+func testRtfAnomalies(t *testing.T, rtf string) map[string]bool {
+	t.Helper()
+	file := LoadFile([]byte(rtf), "test.rtf")
+	if _, err := file.Parse(); err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	if file.GetRtf() == nil {
+		t.Fatal("no RTF document")
+	}
+	anomalies := map[string]bool{}
+	for _, anomaly := range file.GetRtf().Anomalies {
+		anomalies[anomaly] = true
+	}
+	return anomalies
+}
+
+func TestRtfPictureStarredProperties(t *testing.T) {
+	anomalies := testRtfAnomalies(t, `{\rtf1\ansi {\pict{\*\picprop{\sp{\sn a}}}\pngblip{\*\blipuid 0123456789abcdef0123456789abcdef}89504e470d0a1a0a}}`)
+	if anomalies["ignorable destinations inside binary data"] {
+		t.Fatalf("picture with blipuid flagged: %v", anomalies)
+	}
+}
+
+func TestRtfObjdataIgnorableDestination(t *testing.T) {
+	anomalies := testRtfAnomalies(t, `{\rtf1\ansi {\object\objemb{\*\objclass Package}{\*\objdata 0105{\*\junk 4141}0000}}}`)
+	if !anomalies["ignorable destinations inside binary data"] {
+		t.Fatalf("junk group inside objdata not flagged: %v", anomalies)
+	}
+}
@@ -0,0 +1,832 @@
+package goutils
+
+// Rule file parser
+// Rules follow the YARA layout of meta, strings and condition sections
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Condition of a rule and the operands of its comparisons
+type (
+	ruleBool   func(context *ruleContext) bool
+	ruleNumber func(context *ruleContext) float64
+	ruleText   func(context *ruleContext) string
+)
+
+// File properties usable as numbers in conditions
+var ruleNumberFields = map[string]func(file *File) float64{
+	"filesize": func(file *File) float64 { return float64(len(file.fileBytes)) },
+	"depth":    func(file *File) float64 { return float64(file.fileDepth) },
+	"entropy": func(file *File) float64 {
+		if file.fileEntropy == nil {
+			return shannonEntropy(file.fileBytes)
+		}
+		return file.fileEntropy.Entropy
+	},
+	"max_entropy": func(file *File) float64 {
+		if file.fileEntropy == nil {
+			return 0
+		}
+		return file.fileEntropy.MaxEntropy
+	},
+	"high_entropy_size": func(file *File) float64 {
+		size := 0
+		if file.fileEntropy != nil {
+			for _, region := range file.fileEntropy.HighRegions {
+				size += region.Size
+			}
+		}
+		return float64(size)
+	},
+	// High entropy regions outside data compressed by the file format
+	"uncompressed_high_entropy_size": func(file *File) float64 {
+		size := 0
+		if file.fileEntropy != nil {
+			for _, region := range file.fileEntropy.HighRegions {
+				if len(region.Compressed) == 0 {
+					size += region.Size
+				}
+			}
+		}
+		return float64(size)
+	},
+	"incompressible_members": func(file *File) float64 {
+		count := 0
+		if file.fileEntropy != nil {
+			for _, member := range file.fileEntropy.Members {
+				if member.Incompressible {
+					count++
+				}
+			}
+		}
+		return float64(count)
+	},
+	"max_compression_ratio": func(file *File) float64 {
+		ratio := 0.0
+		if file.fileEntropy != nil {
+			for _, member := range file.fileEntropy.Members {
+				ratio = math.Max(ratio, member.Ratio)
+			}
+		}
+		return ratio
+	},
+}
+
+// File properties usable as text in conditions
+var ruleTextFields = map[string]func(file *File) string{
+	"filetype":  func(file *File) string { return file.fileType },
+	"extension": func(file *File) string { return file.fileExtension },
+	"filename":  func(file *File) string { return file.fileName },
+}
+
+// Modifiers of string patterns
+var ruleModifiers = map[string]bool{
+	"nocase":    true,
+	"ascii":     true,
+	"wide":      true,
+	"fullword":  true,
+	"extracted": true,
+}
+
+// Comparison operators of numeric operands
+var numberComparisons = map[string]bool{
+	"==": true,
+	"!=": true,
+	"<":  true,
+	"<=": true,
+	">":  true,
+	">=": true,
+}
+
+// Maximum range of a jump inside hex alternatives
+const maxAlternativeJump = 256
+
+// Kinds of rule file tokens
+const (
+	tokenEnd = iota
+	tokenIdentifier
+	tokenString
+	tokenNumber
+	tokenHex
+	tokenRegex
+	tokenPunct
+)
+
+// Struct of a rule file token
+type ruleToken struct {
+	kind int
+	text string
+	line int
+}
+
+// Struct of the rule file parser state
+type ruleParser struct {
+	tokens   []ruleToken
+	position int
+	rules    map[string]bool
+	rule     *Rule
+}
+
+// Error raised inside the parser and returned by ParseRules
+type ruleError struct {
+	err error
+}
+
+// Parse the source of a rule file
+func ParseRules(source string) (rules *RuleSet, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			parseErr, ok := recovered.(ruleError)
+			if !ok {
+				panic(recovered)
+			}
+			rules, err = nil, parseErr.err
+		}
+	}()
+	parser := &ruleParser{tokens: lexRules(source), rules: map[string]bool{}}
+	rules = &RuleSet{}
+	for parser.peek().kind != tokenEnd {
+		rules.Rules = append(rules.Rules, parser.parseRule())
+	}
+	return rules, nil
+}
+
+// Abort parsing with an error at the current token
+func (parser *ruleParser) fail(format string, args ...interface{}) {
+	panic(ruleError{fmt.Errorf("rules: line %d: %s", parser.peek().line, fmt.Sprintf(format, args...))})
+}
+
+// Return the current token
+func (parser *ruleParser) peek() ruleToken {
+	return parser.tokens[parser.position]
+}
+
+// Return the token after the current one
+func (parser *ruleParser) peekNext() ruleToken {
+	if parser.position+1 < len(parser.tokens) {
+		return parser.tokens[parser.position+1]
+	}
+	return parser.tokens[len(parser.tokens)-1]
+}
+
+// Consume and return the current token
+func (parser *ruleParser) next() ruleToken {
+	token := parser.tokens[parser.position]
+	if token.kind != tokenEnd {
+		parser.position++
+	}
+	return token
+}
+
+// Consume the current token if it is an identifier or punctuation of text
+func (parser *ruleParser) accept(text string) bool {
+	token := parser.peek()
+	if (token.kind == tokenIdentifier || token.kind == tokenPunct) && token.text == text {
+		parser.position++
+		return true
+	}
+	return false
+}
+
+// Consume an identifier or punctuation of text, failing otherwise
+func (parser *ruleParser) expect(text string) {
+	if !parser.accept(text) {
+		parser.fail("expected %s, found %q", text, parser.peek().text)
+	}
+}
+
+// Consume an identifier, failing otherwise
+func (parser *ruleParser) expectIdentifier() string {
+	token := parser.next()
+	if token.kind != tokenIdentifier {
+		parser.fail("expected identifier, found %q", token.text)
+	}
+	return token.text
+}
+
+// Parse a rule
+func (parser *ruleParser) parseRule() *Rule {
+	rule := &Rule{Meta: map[string]string{}}
+	rule.Private = parser.accept("private")
+	parser.expect("rule")
+	rule.Name = parser.expectIdentifier()
+	if parser.rules[rule.Name] {
+		parser.fail("duplicate rule %s", rule.Name)
+	}
+	if parser.accept(":") {
+		for parser.peek().kind == tokenIdentifier {
+			rule.Tags = append(rule.Tags, parser.next().text)
+		}
+	}
+	parser.expect("{")
+	parser.rule = rule
+	if parser.accept("meta") {
+		parser.expect(":")
+		for parser.peek().kind == tokenIdentifier && parser.peekNext().text == "=" {
+			key := parser.next().text
+			parser.expect("=")
+			value := parser.next()
+			if value.kind != tokenString && value.kind != tokenNumber && value.text != "true" && value.text != "false" {
+				parser.fail("invalid meta value %q", value.text)
+			}
+			rule.Meta[key] = value.text
+		}
+	}
+	if parser.accept("strings") {
+		parser.expect(":")
+		for strings.HasPrefix(parser.peek().text, "$") && parser.peek().kind == tokenIdentifier {
+			rule.patterns = append(rule.patterns, parser.parsePattern())
+		}
+	}
+	parser.expect("condition")
+	parser.expect(":")
+	rule.condition = parser.parseOr()
+	parser.expect("}")
+	parser.rules[rule.Name] = true
+	return rule
+}
+
+// Parse a string pattern definition and its modifiers
+func (parser *ruleParser) parsePattern() *rulePattern {
+	pattern := &rulePattern{identifier: parser.next().text}
+	if len(pattern.identifier) < 2 || strings.HasSuffix(pattern.identifier, "*") {
+		parser.fail("invalid string identifier %q", pattern.identifier)
+	}
+	if parser.findPattern(pattern.identifier) != nil {
+		parser.fail("duplicate string %s", pattern.identifier)
+	}
+	parser.expect("=")
+	value := parser.next()
+	switch value.kind {
+	case tokenString:
+		pattern.kind = patternText
+		pattern.text = []byte(value.text)
+		if len(pattern.text) == 0 {
+			parser.fail("empty string %s", pattern.identifier)
+		}
+	case tokenHex:
+		pattern.kind = patternHex
+		pattern.hex = parser.parseHex(value.text)
+	case tokenRegex:
+		pattern.kind = patternRegex
+	default:
+		parser.fail("invalid string %s", pattern.identifier)
+	}
+	for parser.peek().kind == tokenIdentifier && ruleModifiers[parser.peek().text] {
+		modifier := parser.next().text
+		if modifier != "extracted" && (pattern.kind == patternHex || (pattern.kind == patternRegex && modifier != "nocase")) {
+			parser.fail("modifier %s not supported on %s", modifier, pattern.identifier)
+		}
+		switch modifier {
+		case "nocase":
+			pattern.nocase = true
+		case "ascii":
+			pattern.ascii = true
+		case "wide":
+			pattern.wide = true
+		case "fullword":
+			pattern.fullword = true
+		case "extracted":
+			pattern.extracted = true
+		}
+	}
+	if pattern.kind == patternRegex {
+		expression := value.text
+		if pattern.nocase {
+			expression = "(?i)" + expression
+		}
+		var err error
+		if pattern.regex, err = regexp.Compile(expression); err != nil {
+			parser.fail("invalid regex %s: %s", pattern.identifier, err)
+		}
+	}
+	return pattern
+}
+
+// Parse the body of a hex string, bytes with ? nibble wildcards,
+// [n-m] jumps and (a|b) alternatives
+func (parser *ruleParser) parseHex(body string) []hexToken {
+	fields := strings.NewReplacer("[", " [ ", "]", " ] ", "(", " ( ", ")", " ) ", "|", " | ").Replace(body)
+	var words []string
+	for _, field := range strings.Fields(fields) {
+		// Bytes may be written without spaces between them
+		for len(field) > 2 && !strings.ContainsAny(field, "[]()|-") {
+			words = append(words, field[:2])
+			field = field[2:]
+		}
+		words = append(words, field)
+	}
+	tokens, rest := parser.parseHexSequence(words, false)
+	if len(rest) > 0 {
+		parser.fail("unexpected %q in hex string", rest[0])
+	}
+	if len(tokens) == 0 || tokens[0].jump || tokens[len(tokens)-1].jump {
+		parser.fail("hex string must start and end with a byte")
+	}
+	return tokens
+}
+
+// Parse hex words up to a closing parenthesis or alternative separator
+func (parser *ruleParser) parseHexSequence(words []string, nested bool) ([]hexToken, []string) {
+	var tokens []hexToken
+	for len(words) > 0 {
+		word := words[0]
+		switch word {
+		case ")", "|":
+			return tokens, words
+		case "(":
+			var token hexToken
+			words = words[1:]
+			for {
+				var alternative []hexToken
+				alternative, words = parser.parseHexSequence(words, true)
+				if len(alternative) == 0 {
+					parser.fail("empty hex alternative")
+				}
+				token.alternatives = append(token.alternatives, alternative)
+				if len(words) == 0 {
+					parser.fail("unterminated hex alternatives")
+				}
+				if words[0] == ")" {
+					break
+				}
+				words = words[1:]
+			}
+			tokens = append(tokens, token)
+		case "[":
+			end := 1
+			for end < len(words) && words[end] != "]" {
+				end++
+			}
+			if end >= len(words) {
+				parser.fail("unterminated hex jump")
+			}
+			jump := parser.parseHexJump(strings.Join(words[1:end], ""))
+			// Jumps inside alternatives are tried one by one, as in YARA
+			// they must be bounded and short
+			if nested && (jump.jumpMax < 0 || jump.jumpMax-jump.jumpMin > maxAlternativeJump) {
+				parser.fail("jumps inside hex alternatives are limited to %d bytes", maxAlternativeJump)
+			}
+			tokens = append(tokens, jump)
+			words = words[end:]
+		default:
+			tokens = append(tokens, parser.parseHexByte(word))
+		}
+		words = words[1:]
+	}
+	return tokens, words
+}
+
+// Parse a hex jump of n, n-m or n- bytes
+func (parser *ruleParser) parseHexJump(jump string) hexToken {
+	token := hexToken{jump: true, jumpMax: -1}
+	bounds := strings.SplitN(jump, "-", 2)
+	var err error
+	// An unbounded jump may leave out its minimum
+	if len(bounds[0]) > 0 || len(bounds) == 1 {
+		if token.jumpMin, err = strconv.Atoi(bounds[0]); err != nil || token.jumpMin < 0 {
+			parser.fail("invalid hex jump [%s]", jump)
+		}
+	}
+	switch {
+	case len(bounds) == 1:
+		token.jumpMax = token.jumpMin
+	case len(bounds[1]) > 0:
+		if token.jumpMax, err = strconv.Atoi(bounds[1]); err != nil || token.jumpMax < token.jumpMin {
+			parser.fail("invalid hex jump [%s]", jump)
+		}
+	}
+	return token
+}
+
+// Parse a hex byte with optional ? nibble wildcards
+func (parser *ruleParser) parseHexByte(word string) hexToken {
+	if len(word) != 2 {
+		parser.fail("invalid hex byte %q", word)
+	}
+	var token hexToken
+	for i, shift := range []uint{4, 0} {
+		if word[i] == '?' {
+			continue
+		}
+		nibble, err := strconv.ParseUint(word[i:i+1], 16, 8)
+		if err != nil {
+			parser.fail("invalid hex byte %q", word)
+		}
+		token.value |= byte(nibble) << shift
+		token.mask |= 0xf << shift
+	}
+	return token
+}
+
+// Return a string pattern of the current rule by identifier
+func (parser *ruleParser) findPattern(identifier string) *rulePattern {
+	for _, pattern := range parser.rule.patterns {
+		if pattern.identifier == identifier {
+			return pattern
+		}
+	}
+	return nil
+}
+
+// Parse a condition of or terms
+func (parser *ruleParser) parseOr() ruleBool {
+	condition := parser.parseAnd()
+	for parser.accept("or") {
+		left, right := condition, parser.parseAnd()
+		condition = func(context *ruleContext) bool { return left(context) || right(context) }
+	}
+	return condition
+}
+
+// Parse a condition of and terms
+func (parser *ruleParser) parseAnd() ruleBool {
+	condition := parser.parseNot()
+	for parser.accept("and") {
+		left, right := condition, parser.parseNot()
+		condition = func(context *ruleContext) bool { return left(context) && right(context) }
+	}
+	return condition
+}
+
+// Parse a negated or plain condition term
+func (parser *ruleParser) parseNot() ruleBool {
+	if parser.accept("not") {
+		condition := parser.parseNot()
+		return func(context *ruleContext) bool { return !condition(context) }
+	}
+	return parser.parsePrimary()
+}
+
+// Parse a condition term
+func (parser *ruleParser) parsePrimary() ruleBool {
+	token := parser.peek()
+	switch {
+	case parser.accept("("):
+		condition := parser.parseOr()
+		parser.expect(")")
+		return condition
+	case parser.accept("true"):
+		return func(context *ruleContext) bool { return true }
+	case parser.accept("false"):
+		return func(context *ruleContext) bool { return false }
+	case parser.peekNext().text == "of" && (token.kind == tokenNumber || token.text == "all" || token.text == "any" || token.text == "none"):
+		return parser.parseOf()
+	case token.kind == tokenIdentifier && strings.HasPrefix(token.text, "$"):
+		return parser.parseStringReference()
+	case token.kind == tokenIdentifier && parser.rules[token.text]:
+		parser.next()
+		return func(context *ruleContext) bool { return context.results[token.text] }
+	}
+	number, text := parser.parseOperand()
+	if number != nil {
+		return parser.parseNumberComparison(number)
+	}
+	return parser.parseTextComparison(text)
+}
+
+// Parse a quantified string set, all, any, none or n of them or of a list
+func (parser *ruleParser) parseOf() ruleBool {
+	quantifier := parser.next()
+	parser.expect("of")
+	var set []string
+	if parser.accept("them") {
+		for _, pattern := range parser.rule.patterns {
+			set = append(set, pattern.identifier)
+		}
+	} else {
+		parser.expect("(")
+		for {
+			identifier := parser.expectIdentifier()
+			found := false
+			for _, pattern := range parser.rule.patterns {
+				if pattern.identifier == identifier || (strings.HasSuffix(identifier, "*") && strings.HasPrefix(pattern.identifier, strings.TrimSuffix(identifier, "*"))) {
+					set = append(set, pattern.identifier)
+					found = true
+				}
+			}
+			if !found {
+				parser.fail("undefined string %s", identifier)
+			}
+			if !parser.accept(",") {
+				break
+			}
+		}
+		parser.expect(")")
+	}
+	if len(set) == 0 {
+		parser.fail("empty string set")
+	}
+	required := len(set)
+	switch quantifier.text {
+	case "any":
+		required = 1
+	case "none":
+		required = 0
+	case "all":
+	default:
+		required = int(parser.number(quantifier))
+	}
+	return func(context *ruleContext) bool {
+		count := 0
+		for _, identifier := range set {
+			if len(context.matches[identifier]) > 0 {
+				count++
+			}
+		}
+		if quantifier.text == "none" {
+			return count == 0
+		}
+		return count >= required
+	}
+}
+
+// Parse a string reference, optionally at an offset or in a range of the
+// raw bytes
+func (parser *ruleParser) parseStringReference() ruleBool {
+	identifier := parser.next().text
+	pattern := parser.findPattern(identifier)
+	if pattern == nil {
+		parser.fail("undefined string %s", identifier)
+	}
+	if pattern.extracted && (parser.peek().text == "at" || parser.peek().text == "in") {
+		parser.fail("offsets not supported on extracted string %s", identifier)
+	}
+	if parser.accept("at") {
+		offset := parser.expectNumber()
+		return func(context *ruleContext) bool {
+			return rawMatchIn(context.matches[identifier], offset(context), offset(context))
+		}
+	}
+	if parser.accept("in") {
+		parser.expect("(")
+		start := parser.expectNumber()
+		parser.expect("..")
+		end := parser.expectNumber()
+		parser.expect(")")
+		return func(context *ruleContext) bool {
+			return rawMatchIn(context.matches[identifier], start(context), end(context))
+		}
+	}
+	return func(context *ruleContext) bool { return len(context.matches[identifier]) > 0 }
+}
+
+// Check whether a raw bytes match starts within a range of offsets
+func rawMatchIn(matches []RuleStringMatch, start float64, end float64) bool {
+	for _, match := range matches {
+		if match.Source == "raw" && float64(match.Offset) >= start && float64(match.Offset) <= end {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse a numeric or text operand, exactly one of the results is set
+func (parser *ruleParser) parseOperand() (ruleNumber, ruleText) {
+	token := parser.next()
+	switch {
+	case token.kind == tokenNumber:
+		value := parser.number(token)
+		return func(context *ruleContext) float64 { return value }, nil
+	case token.kind == tokenString:
+		return nil, func(context *ruleContext) string { return token.text }
+	case token.kind == tokenIdentifier && strings.HasPrefix(token.text, "#"):
+		identifier := "$" + token.text[1:]
+		if parser.findPattern(identifier) == nil {
+			parser.fail("undefined string %s", identifier)
+		}
+		return func(context *ruleContext) float64 { return float64(len(context.matches[identifier])) }, nil
+	case token.kind == tokenIdentifier && ruleNumberFields[token.text] != nil:
+		field := ruleNumberFields[token.text]
+		return func(context *ruleContext) float64 { return field(context.file) }, nil
+	case token.kind == tokenIdentifier && ruleTextFields[token.text] != nil:
+		field := ruleTextFields[token.text]
+		return nil, func(context *ruleContext) string { return field(context.file) }
+	}
+	parser.fail("unexpected %q in condition", token.text)
+	return nil, nil
+}
+
+// Parse a numeric operand, failing on text
+func (parser *ruleParser) expectNumber() ruleNumber {
+	token := parser.peek()
+	number, _ := parser.parseOperand()
+	if number == nil {
+		parser.fail("expected number, found %q", token.text)
+	}
+	return number
+}
+
+// Return the value of a number token
+func (parser *ruleParser) number(token ruleToken) float64 {
+	value, err := parseRuleNumber(token.text)
+	if err != nil {
+		parser.fail("invalid number %q", token.text)
+	}
+	return value
+}
+
+// Parse the comparison of a numeric operand
+func (parser *ruleParser) parseNumberComparison(left ruleNumber) ruleBool {
+	operator := parser.peek().text
+	if parser.peek().kind != tokenPunct || !numberComparisons[operator] {
+		parser.fail("expected comparison, found %q", operator)
+	}
+	parser.next()
+	right := parser.expectNumber()
+	switch operator {
+	case "==":
+		return func(context *ruleContext) bool { return left(context) == right(context) }
+	case "!=":
+		return func(context *ruleContext) bool { return left(context) != right(context) }
+	case "<":
+		return func(context *ruleContext) bool { return left(context) < right(context) }
+	case "<=":
+		return func(context *ruleContext) bool { return left(context) <= right(context) }
+	case ">":
+		return func(context *ruleContext) bool { return left(context) > right(context) }
+	case ">=":
+		return func(context *ruleContext) bool { return left(context) >= right(context) }
+	}
+	return nil
+}
+
+// Parse the comparison of a text operand
+func (parser *ruleParser) parseTextComparison(left ruleText) ruleBool {
+	operator := parser.next().text
+	if operator == "matches" {
+		token := parser.next()
+		if token.kind != tokenRegex {
+			parser.fail("expected regex after matches")
+		}
+		expression, err := regexp.Compile(token.text)
+		if err != nil {
+			parser.fail("invalid regex: %s", err)
+		}
+		return func(context *ruleContext) bool { return expression.MatchString(left(context)) }
+	}
+	token := parser.peek()
+	_, right := parser.parseOperand()
+	if right == nil {
+		parser.fail("expected text, found %q", token.text)
+	}
+	switch operator {
+	case "==":
+		return func(context *ruleContext) bool { return left(context) == right(context) }
+	case "!=":
+		return func(context *ruleContext) bool { return left(context) != right(context) }
+	case "contains":
+		return func(context *ruleContext) bool { return strings.Contains(left(context), right(context)) }
+	case "icontains":
+		return func(context *ruleContext) bool {
+			return strings.Contains(strings.ToLower(left(context)), strings.ToLower(right(context)))
+		}
+	case "startswith":
+		return func(context *ruleContext) bool { return strings.HasPrefix(left(context), right(context)) }
+	case "endswith":
+		return func(context *ruleContext) bool { return strings.HasSuffix(left(context), right(context)) }
+	}
+	parser.fail("invalid text comparison %q", operator)
+	return nil
+}
+
+// Split a rule file into tokens, hex strings follow = and regexes follow =
+// or matches
+func lexRules(source string) []ruleToken {
+	var tokens []ruleToken
+	line := 1
+	previous := ""
+	for i := 0; i < len(source); {
+		c := source[i]
+		start := i
+		token := ruleToken{line: line}
+		switch {
+		case c == '\n':
+			line++
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+			continue
+		case strings.HasPrefix(source[i:], "//"):
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+			continue
+		case strings.HasPrefix(source[i:], "/*"):
+			end := strings.Index(source[i+2:], "*/")
+			if end < 0 {
+				end = len(source) - i - 4
+			}
+			line += strings.Count(source[i:i+end+4], "\n")
+			i += end + 4
+			continue
+		case c == '{' && previous == "=":
+			end := strings.IndexByte(source[i:], '}')
+			if end < 0 {
+				end = len(source) - i
+			}
+			token.kind, token.text = tokenHex, source[i+1:i+end]
+			line += strings.Count(token.text, "\n")
+			i += end + 1
+		case c == '/' && (previous == "=" || previous == "matches"):
+			token.kind = tokenRegex
+			i++
+			var expression strings.Builder
+			for i < len(source) && source[i] != '/' && source[i] != '\n' {
+				if source[i] == '\\' && i+1 < len(source) && source[i+1] == '/' {
+					i++
+				}
+				expression.WriteByte(source[i])
+				i++
+			}
+			i++
+			flags := ""
+			for i < len(source) && (source[i] == 'i' || source[i] == 's') {
+				flags += string(source[i])
+				i++
+			}
+			token.text = expression.String()
+			if len(flags) > 0 {
+				token.text = "(?" + flags + ")" + token.text
+			}
+		case c == '"':
+			token.kind = tokenString
+			var text strings.Builder
+			for i++; i < len(source) && source[i] != '"' && source[i] != '\n'; i++ {
+				if source[i] != '\\' || i+1 >= len(source) {
+					text.WriteByte(source[i])
+					continue
+				}
+				i++
+				switch source[i] {
+				case 'n':
+					text.WriteByte('\n')
+				case 't':
+					text.WriteByte('\t')
+				case 'r':
+					text.WriteByte('\r')
+				case 'x':
+					if i+2 >= len(source) {
+						break
+					}
+					if value, err := strconv.ParseUint(source[i+1:i+3], 16, 8); err == nil {
+						text.WriteByte(byte(value))
+						i += 2
+					}
+				default:
+					text.WriteByte(source[i])
+				}
+			}
+			i++
+			token.text = text.String()
+		case c >= '0' && c <= '9':
+			token.kind = tokenNumber
+			for i < len(source) && (isWordByte(source[i]) || (source[i] == '.' && i+1 < len(source) && source[i+1] >= '0' && source[i+1] <= '9')) {
+				i++
+			}
+			token.text = source[start:i]
+		case isWordByte(c) || c == '_' || c == '$' || c == '#':
+			token.kind = tokenIdentifier
+			for i++; i < len(source) && (isWordByte(source[i]) || source[i] == '_'); i++ {
+			}
+			if c == '$' && i < len(source) && source[i] == '*' {
+				i++
+			}
+			token.text = source[start:i]
+		default:
+			token.kind = tokenPunct
+			i++
+			for _, operator := range []string{"..", "==", "!=", "<=", ">="} {
+				if strings.HasPrefix(source[start:], operator) {
+					i = start + 2
+				}
+			}
+			token.text = source[start:i]
+		}
+		tokens = append(tokens, token)
+		previous = token.text
+	}
+	return append(tokens, ruleToken{kind: tokenEnd, line: line})
+}
+
+// Return the value of a decimal, hex or KB and MB sized number
+func parseRuleNumber(text string) (float64, error) {
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(text, "KB"):
+		multiplier, text = 1024, strings.TrimSuffix(text, "KB")
+	case strings.HasSuffix(text, "MB"):
+		multiplier, text = 1024*1024, strings.TrimSuffix(text, "MB")
+	}
+	if value, err := strconv.ParseInt(text, 0, 64); err == nil {
+		return float64(value) * multiplier, nil
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	return value * multiplier, err
+}
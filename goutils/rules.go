@@ -0,0 +1,392 @@
+package goutils
+
+// Pattern rule engine
+// Rules of text, hex and regex strings with boolean conditions are matched
+// against the raw bytes of a file and its children, or against the extracted
+// strings for patterns with the extracted modifier
+
+import (
+	"bytes"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// Struct of a set of rules loaded from rule files
+type RuleSet struct {
+	Rules []*Rule
+}
+
+// Struct of a rule, private rules only serve as conditions of other rules
+type Rule struct {
+	Name      string
+	Tags      []string
+	Meta      map[string]string
+	Private   bool
+	patterns  []*rulePattern
+	condition ruleBool
+}
+
+// Struct of a rule matched by a file
+type RuleMatch struct {
+	Rule     string            `json:"rule"`
+	Tags     []string          `json:"tags,omitempty"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	FileName string            `json:"file_name"`
+	Depth    int               `json:"depth"`
+	Strings  []RuleStringMatch `json:"strings,omitempty"`
+}
+
+// Struct of a string pattern match, Source is raw for the file bytes and
+// strings for the extracted strings joined by newlines
+type RuleStringMatch struct {
+	Identifier string `json:"identifier"`
+	Source     string `json:"source"`
+	Offset     int    `json:"offset"`
+	Data       string `json:"data"`
+}
+
+// Kinds of string patterns
+const (
+	patternText = iota
+	patternHex
+	patternRegex
+)
+
+// Struct of a string pattern of a rule
+type rulePattern struct {
+	identifier string
+	kind       int
+	text       []byte
+	nocase     bool
+	ascii      bool
+	wide       bool
+	fullword   bool
+	extracted  bool
+	hex        []hexToken
+	regex      *regexp.Regexp
+}
+
+// Struct of a hex pattern token, a masked byte, a jump or alternatives
+type hexToken struct {
+	value        byte
+	mask         byte
+	jump         bool
+	jumpMin      int
+	jumpMax      int
+	alternatives [][]hexToken
+}
+
+// Struct of the state a rule condition is evaluated against
+type ruleContext struct {
+	file    *File
+	matches map[string][]RuleStringMatch
+	results map[string]bool
+}
+
+// Maximum number of matches kept per string pattern and source
+const maxPatternMatches = 1000
+
+// Maximum length of matched data kept in a match
+const maxMatchData = 64
+
+// Load rules from rule files
+func LoadRules(paths ...string) (*RuleSet, error) {
+	rules := &RuleSet{}
+	for _, rulePath := range paths {
+		source, err := ioutil.ReadFile(rulePath)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := ParseRules(string(source))
+		if err != nil {
+			return nil, err
+		}
+		rules.Rules = append(rules.Rules, parsed.Rules...)
+	}
+	return rules, nil
+}
+
+// Return the rules matched by a file, run after Parse
+func (file *File) GetRuleMatches() []RuleMatch {
+	return file.fileRuleMatches
+}
+
+// Match rules against a parsed file and its child files, returning the
+// matches of the whole tree
+func (file *File) MatchRules(rules *RuleSet) []RuleMatch {
+	file.fileRuleMatches = rules.match(file)
+	matches := append([]RuleMatch{}, file.fileRuleMatches...)
+	for _, child := range file.fileChildren {
+		matches = append(matches, child.MatchRules(rules)...)
+	}
+	return matches
+}
+
+// Match rules in order against a single file, later rules may use the
+// results of earlier ones
+func (rules *RuleSet) match(file *File) []RuleMatch {
+	var matches []RuleMatch
+	extracted := []byte(strings.Join(file.fileStrings, "\n"))
+	results := map[string]bool{}
+	for _, rule := range rules.Rules {
+		context := &ruleContext{file: file, matches: map[string][]RuleStringMatch{}, results: results}
+		for _, pattern := range rule.patterns {
+			if pattern.extracted {
+				context.matches[pattern.identifier] = pattern.find(extracted, "strings")
+			} else {
+				context.matches[pattern.identifier] = pattern.find(file.fileBytes, "raw")
+			}
+		}
+		results[rule.Name] = rule.condition(context)
+		if !results[rule.Name] || rule.Private {
+			continue
+		}
+		match := RuleMatch{
+			Rule:     rule.Name,
+			Tags:     rule.Tags,
+			Meta:     rule.Meta,
+			FileName: file.fileName,
+			Depth:    file.fileDepth,
+		}
+		for _, pattern := range rule.patterns {
+			match.Strings = append(match.Strings, context.matches[pattern.identifier]...)
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// Return the matches of a pattern in data
+func (pattern *rulePattern) find(data []byte, source string) []RuleStringMatch {
+	var matches []RuleStringMatch
+	add := func(start int, end int) bool {
+		matchData := data[start:end]
+		if len(matchData) > maxMatchData {
+			matchData = matchData[:maxMatchData]
+		}
+		matches = append(matches, RuleStringMatch{Identifier: pattern.identifier, Source: source, Offset: start, Data: string(matchData)})
+		return len(matches) < maxPatternMatches
+	}
+	switch pattern.kind {
+	case patternText:
+		haystack := data
+		if pattern.nocase {
+			haystack = asciiLower(data)
+		}
+		for _, needle := range pattern.needles() {
+			if !findText(haystack, needle, pattern.fullword, pattern.wide && !pattern.ascii, add) {
+				break
+			}
+		}
+	case patternHex:
+		findHex(data, pattern.hex, add)
+	case patternRegex:
+		for _, index := range pattern.regex.FindAllIndex(data, maxPatternMatches) {
+			if index[1] > index[0] && !add(index[0], index[1]) {
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// Return the byte sequences searched for a text pattern
+func (pattern *rulePattern) needles() [][]byte {
+	text := pattern.text
+	if pattern.nocase {
+		text = asciiLower(text)
+	}
+	var needles [][]byte
+	if pattern.ascii || !pattern.wide {
+		needles = append(needles, text)
+	}
+	if pattern.wide {
+		wide := make([]byte, 0, len(text)*2)
+		for _, c := range text {
+			wide = append(wide, c, 0)
+		}
+		needles = append(needles, wide)
+	}
+	return needles
+}
+
+// Report the occurrences of a needle, overlapping ones included, until add
+// returns false
+func findText(data []byte, needle []byte, fullword bool, wide bool, add func(int, int) bool) bool {
+	if len(needle) == 0 {
+		return true
+	}
+	for start := 0; start < len(data); {
+		index := bytes.Index(data[start:], needle)
+		if index < 0 {
+			break
+		}
+		offset := start + index
+		start = offset + 1
+		if fullword && !isFullword(data, offset, offset+len(needle), wide) {
+			continue
+		}
+		if !add(offset, offset+len(needle)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check whether a match is not surrounded by alphanumeric characters
+func isFullword(data []byte, start int, end int, wide bool) bool {
+	before := start - 1
+	if wide {
+		before = start - 2
+	}
+	if before >= 0 && isWordByte(data[before]) {
+		return false
+	}
+	return end >= len(data) || !isWordByte(data[end])
+}
+
+// Check whether a byte is an ASCII letter or digit
+func isWordByte(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Return a copy of bytes with ASCII letters lowered, keeping offsets intact
+func asciiLower(data []byte) []byte {
+	lower := make([]byte, len(data))
+	for i, c := range data {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+	return lower
+}
+
+// Report the matches of hex tokens at every offset until add returns false
+func findHex(data []byte, tokens []hexToken, add func(int, int) bool) {
+	scanner := &hexScanner{data: data, tokens: tokens, jumps: map[int]*hexJumpMemo{}}
+	// Hex patterns start with a byte, a fixed one skips ahead quickly
+	first := tokens[0]
+	for start := 0; start < len(data); start++ {
+		if first.mask == 0xff && len(first.alternatives) == 0 {
+			index := bytes.IndexByte(data[start:], first.value)
+			if index < 0 {
+				return
+			}
+			start += index
+		}
+		if end := scanner.matchFrom(0, start); end >= 0 {
+			if !add(start, end) {
+				return
+			}
+		}
+	}
+}
+
+// Struct of the state of a hex pattern scan
+type hexScanner struct {
+	data   []byte
+	tokens []hexToken
+	jumps  map[int]*hexJumpMemo
+}
+
+// Struct of the first match of the tokens after a top level jump at or
+// after a query offset, start is -1 when there is none
+type hexJumpMemo struct {
+	query int
+	start int
+	end   int
+}
+
+// Return the end of a match of the top level tokens from an index at an
+// offset, -1 without a match
+func (scanner *hexScanner) matchFrom(index int, offset int) int {
+	for i := index; i < len(scanner.tokens); i++ {
+		token := scanner.tokens[i]
+		switch {
+		case len(token.alternatives) > 0:
+			return matchHexAlternatives(scanner.data, token.alternatives, offset, func(end int) int {
+				return scanner.matchFrom(i+1, end)
+			})
+		case token.jump:
+			start, end := scanner.afterJump(i, offset+token.jumpMin)
+			if start < 0 || (token.jumpMax >= 0 && start > offset+token.jumpMax) {
+				return -1
+			}
+			return end
+		default:
+			if offset >= len(scanner.data) || scanner.data[offset]&token.mask != token.value {
+				return -1
+			}
+			offset++
+		}
+	}
+	return offset
+}
+
+// Return the start and end of the first match of the tokens after a top
+// level jump at or after an offset. The tokens after a top level jump do not
+// depend on where the match started, so increasing queries reuse the answer
+// and every offset is searched once per scan
+func (scanner *hexScanner) afterJump(index int, query int) (int, int) {
+	memo := scanner.jumps[index]
+	if memo != nil && query >= memo.query && (memo.start < 0 || query <= memo.start) {
+		return memo.start, memo.end
+	}
+	memo = &hexJumpMemo{query: query, start: -1, end: -1}
+	scanner.jumps[index] = memo
+	next := scanner.tokens[index+1]
+	for position := query; position < len(scanner.data); position++ {
+		if next.mask == 0xff && len(next.alternatives) == 0 {
+			found := bytes.IndexByte(scanner.data[position:], next.value)
+			if found < 0 {
+				break
+			}
+			position += found
+		}
+		if end := scanner.matchFrom(index+1, position); end >= 0 {
+			memo.start, memo.end = position, end
+			break
+		}
+	}
+	return memo.start, memo.end
+}
+
+// Return the end of the first alternative matching at an offset and
+// followed by a match of next, -1 without a match
+func matchHexAlternatives(data []byte, alternatives [][]hexToken, offset int, next func(int) int) int {
+	for _, alternative := range alternatives {
+		if end := matchHexSequence(data, alternative, offset, next); end >= 0 {
+			return end
+		}
+	}
+	return -1
+}
+
+// Return the end of a match of tokens inside alternatives followed by a
+// match of next, -1 without a match. Jumps inside alternatives are bounded
+func matchHexSequence(data []byte, tokens []hexToken, offset int, next func(int) int) int {
+	for i, token := range tokens {
+		switch {
+		case len(token.alternatives) > 0:
+			rest := tokens[i+1:]
+			return matchHexAlternatives(data, token.alternatives, offset, func(end int) int {
+				return matchHexSequence(data, rest, end, next)
+			})
+		case token.jump:
+			for skip := token.jumpMin; skip <= token.jumpMax && offset+skip <= len(data); skip++ {
+				if end := matchHexSequence(data, tokens[i+1:], offset+skip, next); end >= 0 {
+					return end
+				}
+			}
+			return -1
+		default:
+			if offset >= len(data) || data[offset]&token.mask != token.value {
+				return -1
+			}
+			offset++
+		}
+	}
+	return next(offset)
+}
@@ -0,0 +1,197 @@
+package goutils
+
+import (
+	"testing"
+	"time"
+)
+
+// Return the names of the rules matching raw data
+func testRuleNames(t *testing.T, source string, data []byte) []string {
+	t.Helper()
+	rules, err := ParseRules(source)
+	if err != nil {
+		t.Fatalf("ParseRules: %s", err)
+	}
+	var names []string
+	for _, match := range rules.match(LoadFile(data, "test.bin")) {
+		names = append(names, match.Rule)
+	}
+	return names
+}
+
+func TestLexRules(t *testing.T) {
+	tokens := lexRules(`rule a : t { // comment
+strings: $h = { 41 ?? } $r = /a\/b/i $s = "q\"\x41"
+condition: #h >= 2 and filesize < 2KB /* block */ and $s in (0..10) }`)
+	want := []struct {
+		kind int
+		text string
+	}{
+		{tokenIdentifier, "rule"}, {tokenIdentifier, "a"}, {tokenPunct, ":"}, {tokenIdentifier, "t"}, {tokenPunct, "{"},
+		{tokenIdentifier, "strings"}, {tokenPunct, ":"},
+		{tokenIdentifier, "$h"}, {tokenPunct, "="}, {tokenHex, " 41 ?? "},
+		{tokenIdentifier, "$r"}, {tokenPunct, "="}, {tokenRegex, "(?i)a/b"},
+		{tokenIdentifier, "$s"}, {tokenPunct, "="}, {tokenString, `q"A`},
+		{tokenIdentifier, "condition"}, {tokenPunct, ":"},
+		{tokenIdentifier, "#h"}, {tokenPunct, ">="}, {tokenNumber, "2"}, {tokenIdentifier, "and"},
+		{tokenIdentifier, "filesize"}, {tokenPunct, "<"}, {tokenNumber, "2KB"}, {tokenIdentifier, "and"},
+		{tokenIdentifier, "$s"}, {tokenIdentifier, "in"}, {tokenPunct, "("}, {tokenNumber, "0"}, {tokenPunct, ".."}, {tokenNumber, "10"}, {tokenPunct, ")"},
+		{tokenPunct, "}"}, {tokenEnd, ""},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d tokens, want %d: %+v", len(tokens), len(want), tokens)
+	}
+	for i, token := range tokens {
+		if token.kind != want[i].kind || token.text != want[i].text {
+			t.Errorf("token %d = %d %q, want %d %q", i, token.kind, token.text, want[i].kind, want[i].text)
+		}
+	}
+	if tokens[len(tokens)-1].line != 3 {
+		t.Errorf("last line = %d, want 3", tokens[len(tokens)-1].line)
+	}
+}
+
+func TestRuleHexPatterns(t *testing.T) {
+	data := []byte("\x00\x4d\x5a\x90\x00\x03\x00\x00\x00PE\x00\x00\xff")
+	tests := []struct {
+		hex   string
+		match bool
+	}{
+		{"4D 5A 90", true},
+		{"4D5A90", true},
+		{"4D ?? 90", true},
+		{"4D 5? 9?", true},
+		{"4D ?A ?1", false},
+		{"4D [2] 00 03", true},
+		{"4D [3] 00 03", false},
+		{"4D [1-4] 03", true},
+		{"4D [4-6] 03", false},
+		{"4D [-] FF", true},
+		{"4D [10-] FF", true},
+		{"4D [20-] FF", false},
+		{"4D 5A ( 91 | 90 00 ) 03", true},
+		{"4D 5A ( 91 | 92 ) 00", false},
+		{"5A ( 90 [1-2] 03 | 91 ) 00", true},
+		{"5A ( 90 ( 01 | 00 ) | 91 ) 03", true},
+		{"( 50 | 51 ) 45 00", true},
+	}
+	for _, test := range tests {
+		names := testRuleNames(t, "rule r { strings: $h = { "+test.hex+" } condition: $h }", data)
+		if (len(names) > 0) != test.match {
+			t.Errorf("{ %s } matched %t, want %t", test.hex, len(names) > 0, test.match)
+		}
+	}
+}
+
+func TestRuleConditions(t *testing.T) {
+	data := []byte("MZ alpha beta alpha gamma Hello World h\x00i\x00 word swords")
+	tests := []struct {
+		name      string
+		condition string
+		match     bool
+	}{
+		{"all of them", "all of them", false},
+		{"any of them", "any of them", true},
+		{"none of them", "none of ($z*)", true},
+		{"n of set", "2 of ($a, $b, $z*)", true},
+		{"n of wildcard", "3 of them", true},
+		{"too many of", "7 of them", false},
+		{"at", "$m at 0", true},
+		{"at wrong offset", "$a at 0", false},
+		{"in range", "$b in (5..10)", true},
+		{"out of range", "$b in (0..5)", false},
+		{"count", "#a == 2 and #b == 1", true},
+		{"count compare", "#a > 2", false},
+		{"filesize", "filesize < 1KB and filesize > 0x10", true},
+		{"nocase", "$hello", true},
+		{"wide", "$wide", true},
+		{"fullword", "#word == 1", true},
+		{"text field", `filename endswith ".bin" and not (filetype == "x")`, true},
+		{"regex field", `filename matches /^test\.[a-z]+$/`, true},
+		{"precedence", "false and true or true", true},
+	}
+	for _, test := range tests {
+		source := `rule r { strings:
+  $m = "MZ"
+  $a = "alpha"
+  $b = "beta"
+  $z1 = "zeta"
+  $hello = "hello world" nocase
+  $wide = "hi" wide
+  $word = "word" fullword
+condition: ` + test.condition + " }"
+		if names := testRuleNames(t, source, data); (len(names) > 0) != test.match {
+			t.Errorf("%s: matched %t, want %t", test.name, len(names) > 0, test.match)
+		}
+	}
+}
+
+func TestRuleReferences(t *testing.T) {
+	names := testRuleNames(t, `private rule p { strings: $a = "abc" condition: $a }
+rule r { condition: p and filesize > 100 }
+rule s { condition: p }`, []byte("xxabcxx"))
+	if len(names) != 1 || names[0] != "s" {
+		t.Fatalf("matched %v, want [s]", names)
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, source := range []string{
+		`rule a { condition: $x }`,
+		`rule a { strings: $x = { [2] 41 } condition: $x }`,
+		`rule a { strings: $x = { 41 [-] } condition: $x }`,
+		`rule a { strings: $x = { 41 ( 42 [-] 43 | 44 ) } condition: $x }`,
+		`rule a { strings: $x = { 41 4 } condition: $x }`,
+		`rule a { strings: $x = /a/ wide condition: $x }`,
+		`rule a { strings: $x = "a" condition: $x and filesize }`,
+		`rule a { condition: filesize = 3 }`,
+		`rule a { condition: filesize > 1XB }`,
+		`rule a { condition: true } rule a { condition: true }`,
+		`rule a { condition: 2 of ($y*) }`,
+		`rule a { strings: $x = "a" extracted condition: $x at 0 }`,
+	} {
+		if _, err := ParseRules(source); err == nil {
+			t.Errorf("ParseRules(%q) succeeded, want an error", source)
+		}
+	}
+}
+
+func TestRuleHexUnboundedJumpLinear(t *testing.T) {
+	data := make([]byte, 80*1024)
+	start := time.Now()
+	names := testRuleNames(t, "rule r { strings: $h = { 00 [-] FF } condition: $h }", data)
+	if len(names) > 0 {
+		t.Fatal("matched data without FF")
+	}
+	data[len(data)-1] = 0xff
+	rules, _ := ParseRules("rule r { strings: $h = { 00 [-] FF } $b = { 00 [0-65536] 00 FF } condition: #h == 1000 and $b }")
+	if matches := rules.match(LoadFile(data, "test.bin")); len(matches) != 1 {
+		t.Fatalf("matched %d rules, want 1", len(matches))
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("scan took %s", elapsed)
+	}
+}
+
+func TestRuleCountsRawMatchesOnly(t *testing.T) {
+	file := LoadFile([]byte("this is evil\n"), "test.txt")
+	if _, err := file.Parse(); err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	rules, err := ParseRules(`rule one { strings: $a = "evil" condition: #a == 1 }
+rule two { strings: $a = "evil" condition: #a >= 2 }
+rule extracted { strings: $a = "evil" extracted condition: #a == 1 }`)
+	if err != nil {
+		t.Fatalf("ParseRules: %s", err)
+	}
+	var names []string
+	for _, match := range rules.match(file) {
+		names = append(names, match.Rule)
+		if len(match.Strings) != 1 {
+			t.Errorf("%s: %d string matches, want 1", match.Rule, len(match.Strings))
+		}
+	}
+	if len(names) != 2 || names[0] != "one" || names[1] != "extracted" {
+		t.Fatalf("matched %v, want [one extracted]", names)
+	}
+}
@@ -0,0 +1,157 @@
+package goutils
+
+// Secret and credential detection in parsed file strings
+// Cloud access keys, tokens, JWTs, private keys, password assignments and
+// connection strings, reported with redacted previews
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// Struct of a detected secret, Location is the path of the file within its
+// parents and Preview the match with the secret redacted
+type Secret struct {
+	Type     string `json:"type"`
+	Location string `json:"location"`
+	Preview  string `json:"preview"`
+	Count    int    `json:"count"`
+}
+
+// Secret types
+const (
+	SecretAwsAccessKey     = "aws_access_key"
+	SecretAwsSecretKey     = "aws_secret_key"
+	SecretGithubToken      = "github_token"
+	SecretSlackToken       = "slack_token"
+	SecretSlackWebhook     = "slack_webhook"
+	SecretJwt              = "jwt"
+	SecretPrivateKey       = "private_key"
+	SecretPassword         = "password_assignment"
+	SecretConnectionString = "connection_string"
+)
+
+// Struct of a secret pattern, the secret subexpression is redacted in
+// previews and checked by the validator
+type secretPattern struct {
+	secretType string
+	re         *regexp.Regexp
+	valid      func(string) bool
+}
+
+// Secret patterns, checked in order over every string
+var secretPatterns = []secretPattern{
+	{SecretAwsAccessKey, regexp.MustCompile(`\b(?P<secret>(?:AKIA|ASIA|AGPA|AIDA|AROA|AIPA|ANPA|ANVA)[A-Z0-9]{16})\b`), nil},
+	{SecretAwsSecretKey, regexp.MustCompile(`(?i)\baws_?secret_?(?:access_?)?key\b["']?\s*[:=]\s*["']?(?P<secret>[A-Za-z0-9/+]{40})\b`), nil},
+	{SecretGithubToken, regexp.MustCompile(`\b(?P<secret>gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{50,255})\b`), nil},
+	{SecretSlackToken, regexp.MustCompile(`\b(?P<secret>xox[abposr]-[A-Za-z0-9-]{10,})`), nil},
+	{SecretSlackWebhook, regexp.MustCompile(`https://hooks\.slack\.com/services/T[A-Z0-9]+/B[A-Z0-9]+/(?P<secret>[A-Za-z0-9]{16,})`), nil},
+	{SecretJwt, regexp.MustCompile(`\b(?P<secret>eyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{10,})`), validJwt},
+	{SecretPrivateKey, regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`), nil},
+	{SecretPassword, regexp.MustCompile(`(?i)\b(?:password|passwd|pwd|secret|api_?key|apikey|access_?token|auth_?token|client_?secret|secret_?key)["']?\s*[:=]\s*["']?(?P<secret>[^\s"'&;,<>]{8,})`), validPasswordValue},
+	{SecretConnectionString, regexp.MustCompile(`(?i)\b(?:mongodb(?:\+srv)?|postgres(?:ql)?|mysql|mariadb|redis|rediss|amqps?|mssql|sqlserver|ftps?|sftp|ldaps?|smtps?)://[^\s:@/"'<>]+:(?P<secret>[^\s@/"'<>]+)@[^\s"'<>]+`), nil},
+	{SecretConnectionString, regexp.MustCompile(`(?i)\b(?:server|data source|host)=[^;"'\n]+;[^"'\n]*?\b(?:password|pwd)=(?P<secret>[^;"'\s]+)`), nil},
+}
+
+// Minimum entropy in bits per character of a generic password value
+const minPasswordEntropy = 2.8
+
+// Placeholder values of password assignments that are not secrets
+var passwordPlaceholderRe = regexp.MustCompile(`(?i)^(?:\*+|x+|\$\{.*\}|\{\{.*\}\}|%.*%|<.*>|\[.*\]|null|none|undefined|true|false|password|changeme|example|redacted)$`)
+
+// Detect secrets in the strings of a file and its child files
+func (file *File) Secrets() []Secret {
+	var secrets []Secret
+	seen := map[[3]string]int{}
+	file.collectSecrets(file.fileName, &secrets, seen)
+	return secrets
+}
+
+// Detect the secrets of a file and add those of its child files with their
+// location, repeated secrets are counted once per location
+func (file *File) collectSecrets(location string, secrets *[]Secret, seen map[[3]string]int) {
+	strs := append([]string{}, file.fileStrings...)
+	for _, decoded := range file.fileDecoded {
+		strs = append(strs, decoded.Value)
+	}
+	for _, str := range strs {
+		connectionSpans := secretSpans(str, SecretConnectionString)
+		for _, pattern := range secretPatterns {
+			secretIndex := pattern.re.SubexpIndex("secret")
+			for _, match := range pattern.re.FindAllStringSubmatchIndex(str, -1) {
+				// The password of a connection string is reported with it
+				if pattern.secretType == SecretPassword && insideSpans(match[0], match[1], connectionSpans) {
+					continue
+				}
+				value, preview := str[match[0]:match[1]], str[match[0]:match[1]]
+				if secretIndex >= 0 {
+					value = str[match[2*secretIndex]:match[2*secretIndex+1]]
+					preview = str[match[0]:match[2*secretIndex]] + redactSecret(value) + str[match[2*secretIndex+1]:match[1]]
+				}
+				if pattern.valid != nil && !pattern.valid(value) {
+					continue
+				}
+				key := [3]string{pattern.secretType, location, value}
+				if index, ok := seen[key]; ok {
+					(*secrets)[index].Count++
+					continue
+				}
+				seen[key] = len(*secrets)
+				*secrets = append(*secrets, Secret{Type: pattern.secretType, Location: location, Preview: preview, Count: 1})
+			}
+		}
+	}
+	for _, child := range file.fileChildren {
+		child.collectSecrets(location+"/"+child.fileName, secrets, seen)
+	}
+}
+
+// Return the spans of the matches of a secret type in a string
+func secretSpans(str string, secretType string) [][]int {
+	var spans [][]int
+	for _, pattern := range secretPatterns {
+		if pattern.secretType == secretType {
+			spans = append(spans, pattern.re.FindAllStringIndex(str, -1)...)
+		}
+	}
+	return spans
+}
+
+// Check if a match lies within one of the spans
+func insideSpans(start int, end int, spans [][]int) bool {
+	for _, span := range spans {
+		if start >= span[0] && end <= span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the start of a secret followed by a fixed mask, hiding its length
+// The start is cut on a character boundary
+func redactSecret(secret string) string {
+	runes := []rune(secret)
+	visible := len(runes) / 4
+	if visible > 4 {
+		visible = 4
+	}
+	return string(runes[:visible]) + "********"
+}
+
+// Validate a JWT by its header naming a signing algorithm
+func validJwt(token string) bool {
+	header, err := base64.RawURLEncoding.DecodeString(token[:strings.IndexByte(token, '.')])
+	if err != nil {
+		return false
+	}
+	var fields map[string]interface{}
+	return json.Unmarshal(header, &fields) == nil && fields["alg"] != nil
+}
+
+// Validate a generic password value, skipping placeholders and low
+// entropy words
+func validPasswordValue(value string) bool {
+	return !passwordPlaceholderRe.MatchString(value) && shannonEntropy([]byte(value)) >= minPasswordEntropy
+}
@@ -0,0 +1,303 @@
+package goutils
+
+// File name and content consistency checks
+// Declared extensions are compared with the detected type, and secondary
+// magic signatures at other offsets reveal polyglot files. In text files
+// signatures count at line starts only, in images past the image data only
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Struct of the type checks of a file
+type FileTypeCheck struct {
+	DeclaredExtension   string          `json:"declared_extension,omitempty"`
+	DetectedType        string          `json:"detected_type"`
+	DetectedExtension   string          `json:"detected_extension"`
+	ExtensionMismatch   bool            `json:"extension_mismatch"`
+	DisguisedExecutable bool            `json:"disguised_executable"`
+	Polyglot            bool            `json:"polyglot"`
+	Signatures          []FileSignature `json:"signatures,omitempty"`
+}
+
+// Struct of a magic signature found after the start of a file
+type FileSignature struct {
+	Offset int    `json:"offset"`
+	Format string `json:"format"`
+}
+
+// Struct of a magic signature and an optional check of the bytes at a match
+type magicSignature struct {
+	format string
+	magic  []byte
+	check  func(data []byte, offset int) bool
+}
+
+// Signatures of formats that make a file a polyglot when found past its start
+var polyglotSignatures = []magicSignature{
+	{format: "pdf", magic: []byte("%PDF-"), check: isPdfHeader},
+	{format: "zip", magic: []byte("PK\x03\x04"), check: isZipHeader},
+	{format: "pe", magic: []byte("MZ"), check: isPeHeader},
+	{format: "elf", magic: []byte("\x7fELF\x01")},
+	{format: "elf", magic: []byte("\x7fELF\x02")},
+	{format: "cfb", magic: cfbSignature},
+	{format: "rtf", magic: []byte(`{\rtf`)},
+	{format: "rar", magic: []byte("Rar!\x1a\x07")},
+	{format: "7z", magic: []byte("7z\xbc\xaf\x27\x1c")},
+}
+
+// Format families of detected extensions, secondary signatures of the
+// file's own family are expected
+var extensionFamilies = map[string]string{
+	"pdf":  "pdf",
+	"zip":  "zip",
+	"docx": "zip",
+	"xlsx": "zip",
+	"pptx": "zip",
+	"odt":  "zip",
+	"ods":  "zip",
+	"odp":  "zip",
+	"epub": "zip",
+	"exe":  "pe",
+	"elf":  "elf",
+	"doc":  "cfb",
+	"xls":  "cfb",
+	"ppt":  "cfb",
+	"msg":  "cfb",
+	"rtf":  "rtf",
+	"rar":  "rar",
+	"7z":   "7z",
+}
+
+// Containers storing other files as they are, their members' signatures
+// do not make them polyglots
+var containerFamilies = map[string]bool{
+	"zip": true,
+	"cfb": true,
+	"rar": true,
+	"7z":  true,
+	"tar": true,
+}
+
+// Detected types accepted for declared extensions, as MIME prefixes
+var extensionTypes = map[string][]string{
+	"pdf":   {"application/pdf"},
+	"exe":   {"application/vnd.microsoft.portable-executable"},
+	"dll":   {"application/vnd.microsoft.portable-executable"},
+	"scr":   {"application/vnd.microsoft.portable-executable"},
+	"sys":   {"application/vnd.microsoft.portable-executable"},
+	"cpl":   {"application/vnd.microsoft.portable-executable"},
+	"so":    {"application/x-executable"},
+	"dylib": {"application/x-mach-binary"},
+	"doc":   {"application/msword", "application/rtf"},
+	"xls":   {"application/vnd.ms-excel", "application/msword"},
+	"ppt":   {"application/vnd.ms-powerpoint", "application/msword"},
+	"msg":   {"application/vnd.ms-outlook"},
+	"docx":  {"application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/zip"},
+	"xlsx":  {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "application/zip"},
+	"pptx":  {"application/vnd.openxmlformats-officedocument.presentationml.presentation", "application/zip"},
+	"odt":   {"application/vnd.oasis.opendocument.text"},
+	"ods":   {"application/vnd.oasis.opendocument.spreadsheet"},
+	"odp":   {"application/vnd.oasis.opendocument.presentation"},
+	"epub":  {"application/epub+zip"},
+	"zip":   {"application/zip"},
+	"gz":    {"application/gzip"},
+	"tgz":   {"application/gzip"},
+	"bz2":   {"application/x-bzip2"},
+	"rar":   {"application/vnd.rar", "application/x-rar-compressed"},
+	"7z":    {"application/x-7z-compressed"},
+	"rtf":   {"application/rtf"},
+	"eml":   {"message/rfc822", "text/"},
+	"html":  {"text/html"},
+	"htm":   {"text/html"},
+	"txt":   {"text/"},
+	"csv":   {"text/"},
+	"log":   {"text/"},
+	"jpg":   {"image/jpeg"},
+	"jpeg":  {"image/jpeg"},
+	"png":   {"image/png"},
+	"gif":   {"image/gif"},
+	"bmp":   {"image/bmp"},
+	"tif":   {"image/tiff"},
+	"tiff":  {"image/tiff"},
+	"webp":  {"image/webp"},
+	"heic":  {"image/heif"},
+	"heif":  {"image/heif"},
+}
+
+// Detected types of text content, as MIME prefixes
+var textTypes = []string{
+	"text/",
+	"message/",
+	"application/json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// Detected types of executable code
+var executableTypes = []string{
+	"application/vnd.microsoft.portable-executable",
+	"application/x-executable",
+	"application/x-mach-binary",
+}
+
+// Maximum number of candidate offsets checked per signature
+const maxSignatureCandidates = 4096
+
+// Return the type checks of a file
+func (file *File) GetTypeCheck() *FileTypeCheck {
+	return file.fileTypeCheck
+}
+
+// Compare the declared extension with the detected type and scan for
+// secondary signatures
+func checkFileType(file *File) *FileTypeCheck {
+	check := &FileTypeCheck{
+		DeclaredExtension: strings.ToLower(strings.TrimPrefix(path.Ext(file.fileName), ".")),
+		DetectedType:      file.fileType,
+		DetectedExtension: file.fileExtension,
+	}
+	if accepted, ok := extensionTypes[check.DeclaredExtension]; ok {
+		check.ExtensionMismatch = true
+		for _, prefix := range accepted {
+			if strings.HasPrefix(file.fileType, prefix) {
+				check.ExtensionMismatch = false
+			}
+		}
+		// Executable content behind a document or image name is the classic lure
+		if check.ExtensionMismatch && hasTypePrefix(file.fileType, executableTypes) && !hasTypePrefix(accepted[0], executableTypes) {
+			check.DisguisedExecutable = true
+		}
+	}
+	family := extensionFamilies[file.fileExtension]
+	check.Signatures = scanSignatures(file.fileBytes, signatureBoundary(file))
+	for _, signature := range check.Signatures {
+		if signature.Format != family && !containerFamilies[family] {
+			check.Polyglot = true
+		}
+	}
+	return check
+}
+
+// Check whether a type starts with one of the prefixes
+func hasTypePrefix(fileType string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(fileType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the check of the offsets where a secondary signature may start
+// in a file, nil when signatures count anywhere
+func signatureBoundary(file *File) func(data []byte, offset int) bool {
+	if hasTypePrefix(file.fileType, textTypes) {
+		return isLineStart
+	}
+	if end := imageDataEnd(file.fileBytes, file.fileType); end > 0 {
+		return func(data []byte, offset int) bool {
+			return offset >= end
+		}
+	}
+	return nil
+}
+
+// Check whether an offset starts a line or follows the end of an HTML
+// document
+func isLineStart(data []byte, offset int) bool {
+	return data[offset-1] == '\n' || data[offset-1] == '\r' ||
+		(offset >= 7 && bytes.EqualFold(data[offset-7:offset], []byte("</html>")))
+}
+
+// Return the offset following the image data of a PNG or JPEG file, zero
+// when it is not known
+func imageDataEnd(data []byte, fileType string) int {
+	switch fileType {
+	case "image/png":
+		for offset := 8; offset+12 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[offset:]))
+			if length < 0 || length > len(data)-offset-12 {
+				return 0
+			}
+			offset += 12 + length
+			if string(data[offset-length-8:offset-length-4]) == "IEND" {
+				return offset
+			}
+		}
+	case "image/jpeg":
+		// Markers cannot occur in entropy coded data, the first end of
+		// image marker after the scan ends the image
+		if spans := jpegScanSpans(data); spans != nil {
+			if index := bytes.Index(data[spans[0][0]:], []byte{0xff, 0xd9}); index >= 0 {
+				return spans[0][0] + index + 2
+			}
+		}
+	}
+	return 0
+}
+
+// Return the first offset past the start of the file of each signature,
+// at offsets accepted by boundary when it is set
+func scanSignatures(data []byte, boundary func(data []byte, offset int) bool) []FileSignature {
+	found := map[string]int{}
+	for _, signature := range polyglotSignatures {
+		candidates := 0
+		for start := 1; start < len(data) && candidates < maxSignatureCandidates; candidates++ {
+			index := bytes.Index(data[start:], signature.magic)
+			if index < 0 {
+				break
+			}
+			offset := start + index
+			start = offset + 1
+			if signature.check != nil && !signature.check(data, offset) {
+				continue
+			}
+			if boundary != nil && !boundary(data, offset) {
+				continue
+			}
+			if previous, ok := found[signature.format]; !ok || offset < previous {
+				found[signature.format] = offset
+			}
+			break
+		}
+	}
+	var signatures []FileSignature
+	for format, offset := range found {
+		signatures = append(signatures, FileSignature{Offset: offset, Format: format})
+	}
+	sort.Slice(signatures, func(i, j int) bool {
+		return signatures[i].Offset < signatures[j].Offset
+	})
+	return signatures
+}
+
+// Check whether a PDF header at an offset carries a version number
+func isPdfHeader(data []byte, offset int) bool {
+	version := data[offset+len("%PDF-"):]
+	return len(version) >= 3 && version[0] >= '1' && version[0] <= '2' && version[1] == '.' && version[2] >= '0' && version[2] <= '9'
+}
+
+// Check whether a zip local file header at an offset has a plausible
+// version and a file name within the data
+func isZipHeader(data []byte, offset int) bool {
+	if offset+30 > len(data) {
+		return false
+	}
+	version := binary.LittleEndian.Uint16(data[offset+4:])
+	nameLength := int(binary.LittleEndian.Uint16(data[offset+26:]))
+	return version <= 63 && nameLength > 0 && offset+30+nameLength <= len(data)
+}
+
+// Check whether an MZ header at an offset points at a PE signature
+func isPeHeader(data []byte, offset int) bool {
+	if offset+0x40 > len(data) {
+		return false
+	}
+	peOffset := offset + int(binary.LittleEndian.Uint32(data[offset+0x3c:]))
+	return peOffset > offset && peOffset+4 <= len(data) && bytes.Equal(data[peOffset:peOffset+4], []byte("PE\x00\x00"))
+}
@@ -0,0 +1,254 @@
+package goutils
+
+// Url canonicalization, decomposition and redirect wrapper unwrapping
+// Safe Links, Google redirects and Proofpoint urldefense (v1, v2, v3)
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Struct of a url decomposed into its parts
+type UrlParts struct {
+	Url              string `json:"url"`
+	Scheme           string `json:"scheme"`
+	Host             string `json:"host"`
+	Port             string `json:"port,omitempty"`
+	RegisteredDomain string `json:"registered_domain,omitempty"`
+	Path             string `json:"path"`
+	Query            string `json:"query,omitempty"`
+	Fragment         string `json:"fragment,omitempty"`
+}
+
+// Map of schemes to their default port
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ftp":   "21",
+}
+
+// Maximum number of nested redirect wrappers unwrapped from a url
+const maxUnwrapDepth = 5
+
+// Run length alphabet used by Proofpoint v3 to shorten replacement runs
+const proofpointRunLengths = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// Canonicalize a url: lowercase scheme and host, punycode IDNs, strip
+// default ports, resolve dot segments and optionally sort query parameters
+func CanonicalizeUrl(rawUrl string, sortQuery bool) (string, error) {
+	parsedUrl, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return rawUrl, err
+	}
+	if len(parsedUrl.Host) == 0 {
+		return rawUrl, fmt.Errorf("url %s has no host", rawUrl)
+	}
+	parsedUrl.Scheme = strings.ToLower(parsedUrl.Scheme)
+	host := strings.TrimSuffix(strings.ToLower(parsedUrl.Hostname()), ".")
+	if asciiHost, err := idna.Lookup.ToASCII(host); err == nil {
+		host = asciiHost
+	}
+	port := parsedUrl.Port()
+	if port == defaultPorts[parsedUrl.Scheme] {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if len(port) > 0 {
+		host = host + ":" + port
+	}
+	parsedUrl.Host = host
+	parsedUrl.Path = removeDotSegments(parsedUrl.Path)
+	parsedUrl.RawPath = removeDotSegments(parsedUrl.RawPath)
+	if len(parsedUrl.Path) == 0 {
+		parsedUrl.Path = "/"
+	}
+	if sortQuery && len(parsedUrl.RawQuery) > 0 {
+		params := strings.Split(parsedUrl.RawQuery, "&")
+		sort.SliceStable(params, func(i, j int) bool {
+			return strings.SplitN(params[i], "=", 2)[0] < strings.SplitN(params[j], "=", 2)[0]
+		})
+		parsedUrl.RawQuery = strings.Join(params, "&")
+	}
+	return parsedUrl.String(), nil
+}
+
+// Remove dot segments from a path as described in RFC 3986 section 5.2.4
+func removeDotSegments(path string) string {
+	if !strings.Contains(path, ".") {
+		return path
+	}
+	var output []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		switch segment {
+		case ".":
+		case "..":
+			if len(output) > 1 {
+				output = output[:len(output)-1]
+			}
+		default:
+			output = append(output, segment)
+			continue
+		}
+		// A trailing dot segment leaves the path ending in a slash
+		if i == len(segments)-1 {
+			output = append(output, "")
+		}
+	}
+	return strings.Join(output, "/")
+}
+
+// Decompose a url into scheme, host, registered domain, path and query
+func ParseUrl(rawUrl string) (*UrlParts, error) {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	parts := &UrlParts{
+		Url:      rawUrl,
+		Scheme:   strings.ToLower(parsedUrl.Scheme),
+		Host:     strings.ToLower(parsedUrl.Hostname()),
+		Port:     parsedUrl.Port(),
+		Path:     parsedUrl.Path,
+		Query:    parsedUrl.RawQuery,
+		Fragment: parsedUrl.Fragment,
+	}
+	if net.ParseIP(parts.Host) == nil {
+		parts.RegisteredDomain, _ = publicsuffix.EffectiveTLDPlusOne(parts.Host)
+	}
+	return parts, nil
+}
+
+// Unwrap Safe Links, Google redirect and Proofpoint urldefense wrappers
+// to the final target, nested wrappers are unwrapped repeatedly
+func UnwrapUrl(rawUrl string) string {
+	for depth := 0; depth < maxUnwrapDepth; depth++ {
+		target, ok := unwrapUrlOnce(rawUrl)
+		if !ok || target == rawUrl {
+			break
+		}
+		rawUrl = target
+	}
+	return rawUrl
+}
+
+// Unwrap a single redirect wrapper, ok is false if the url is not wrapped
+func unwrapUrlOnce(rawUrl string) (string, bool) {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl, false
+	}
+	host := strings.ToLower(parsedUrl.Hostname())
+	query := parsedUrl.Query()
+	switch {
+	case strings.HasSuffix(host, "safelinks.protection.outlook.com"):
+		return nonEmptyTarget(query.Get("url"), rawUrl)
+	case isGoogleHost(host) && parsedUrl.Path == "/url":
+		if target := query.Get("q"); len(target) > 0 {
+			return target, true
+		}
+		return nonEmptyTarget(query.Get("url"), rawUrl)
+	case host == "urldefense.proofpoint.com" && strings.HasPrefix(parsedUrl.Path, "/v1/"):
+		return nonEmptyTarget(query.Get("u"), rawUrl)
+	case host == "urldefense.proofpoint.com" && strings.HasPrefix(parsedUrl.Path, "/v2/"):
+		encoded := strings.Replace(strings.Replace(query.Get("u"), "-", "%", -1), "_", "/", -1)
+		target, err := url.QueryUnescape(encoded)
+		if err != nil {
+			return rawUrl, false
+		}
+		return nonEmptyTarget(target, rawUrl)
+	case host == "urldefense.com" && strings.HasPrefix(parsedUrl.Path, "/v3/"):
+		return unwrapProofpointV3(rawUrl)
+	}
+	return rawUrl, false
+}
+
+// Return target when it is not empty, otherwise the original url
+func nonEmptyTarget(target string, rawUrl string) (string, bool) {
+	if len(target) == 0 {
+		return rawUrl, false
+	}
+	return target, true
+}
+
+// Check for hosts whose registered domain is google under a public suffix,
+// such as www.google.com or google.co.uk
+func isGoogleHost(host string) bool {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return false
+	}
+	suffix, icann := publicsuffix.PublicSuffix(domain)
+	return icann && domain == "google."+suffix
+}
+
+// Decode a Proofpoint v3 url: the target sits between "__" markers and
+// each '*' is replaced by characters from the base64 trailer after ";"
+func unwrapProofpointV3(rawUrl string) (string, bool) {
+	start := strings.Index(rawUrl, "/v3/__")
+	end := strings.LastIndex(rawUrl, "__;")
+	if start < 0 || end <= start+6 {
+		return rawUrl, false
+	}
+	encodedUrl := rawUrl[start+6 : end]
+	trailer := rawUrl[end+3:]
+	if bang := strings.Index(trailer, "!!"); bang >= 0 {
+		trailer = trailer[:bang]
+	}
+	replacementBytes, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(trailer, "="))
+	if err != nil {
+		return rawUrl, false
+	}
+	replacements := []rune(string(replacementBytes))
+	var target strings.Builder
+	for i := 0; i < len(encodedUrl); i++ {
+		if encodedUrl[i] != '*' {
+			target.WriteByte(encodedUrl[i])
+			continue
+		}
+		// "**X" replaces a run whose length is encoded by X
+		count := 1
+		if i+2 < len(encodedUrl) && encodedUrl[i+1] == '*' {
+			count = strings.IndexByte(proofpointRunLengths, encodedUrl[i+2]) + 2
+			i += 2
+		}
+		if count < 1 || count > len(replacements) {
+			return rawUrl, false
+		}
+		target.WriteString(string(replacements[:count]))
+		replacements = replacements[count:]
+	}
+	return target.String(), true
+}
+
+// Canonical key used to de-duplicate urls, the url itself when unparsable
+func urlKey(rawUrl string) string {
+	canonical, err := CanonicalizeUrl(rawUrl, false)
+	if err != nil {
+		return rawUrl
+	}
+	return canonical
+}
+
+// Extract urls and decompose each one into its parts
+func (file *File) UrlExtractParts(options UrlOptions) []*UrlParts {
+	var urlParts []*UrlParts
+	options.Defang = false
+	for _, rawUrl := range file.UrlExtractWithOptions(options) {
+		parts, err := ParseUrl(rawUrl)
+		if err != nil {
+			continue
+		}
+		urlParts = append(urlParts, parts)
+	}
+	return urlParts
+}
@@ -0,0 +1,217 @@
+package goutils
+
+// Encrypted zip member support
+// Traditional PKWARE encryption (ZipCrypto) and WinZip AES (AE-1, AE-2)
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+)
+
+// Zip compression method and extra field id used by WinZip AES
+const (
+	zipMethodWinZipAes    = 99
+	zipExtraWinZipAes     = 0x9901
+	zipFlagEncrypted      = 0x1
+	zipFlagDataDescriptor = 0x8
+)
+
+var errZipBadPassword = errors.New("zip: incorrect password")
+
+// Read a zip member, decrypting ZipCrypto and WinZip AES members with the
+// file's candidate passwords, return the password that decrypted it
+func readZipMember(file *File, zipFile *zip.File) ([]byte, string, error) {
+	if zipFile.Flags&zipFlagEncrypted == 0 {
+		unzippedFile, err := zipFile.Open()
+		if err != nil {
+			return nil, "", err
+		}
+		defer unzippedFile.Close()
+		memberBytes, err := ioutil.ReadAll(unzippedFile)
+		return memberBytes, "", err
+	}
+	rawFile, err := zipFile.OpenRaw()
+	if err != nil {
+		return nil, "", err
+	}
+	rawBytes, err := ioutil.ReadAll(rawFile)
+	if err != nil {
+		return nil, "", err
+	}
+	var memberBytes []byte
+	password, ok := file.tryPasswords(func(password string) bool {
+		var err error
+		if zipFile.Method == zipMethodWinZipAes {
+			memberBytes, err = decryptWinZipAes(zipFile, rawBytes, password)
+		} else {
+			memberBytes, err = decryptZipCrypto(zipFile, rawBytes, password)
+		}
+		return err == nil
+	})
+	if !ok {
+		return nil, "", ErrPasswordRequired
+	}
+	return memberBytes, password, nil
+}
+
+// Decrypt and decompress a traditional PKWARE encrypted member
+func decryptZipCrypto(zipFile *zip.File, rawBytes []byte, password string) ([]byte, error) {
+	if len(rawBytes) < 12 {
+		return nil, fmt.Errorf("zip: encryption header too short")
+	}
+	keys := [3]uint32{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		zipCryptoUpdateKeys(&keys, password[i])
+	}
+	decrypted := make([]byte, len(rawBytes))
+	for i, c := range rawBytes {
+		temp := keys[2] | 2
+		plain := c ^ byte((temp*(temp^1))>>8)
+		zipCryptoUpdateKeys(&keys, plain)
+		decrypted[i] = plain
+	}
+	// The last header byte holds the high byte of the CRC, or of the
+	// modification time when sizes follow in a data descriptor
+	check := byte(zipFile.CRC32 >> 24)
+	if zipFile.Flags&zipFlagDataDescriptor != 0 {
+		check = byte(zipFile.ModifiedTime >> 8)
+	}
+	if decrypted[11] != check {
+		return nil, errZipBadPassword
+	}
+	memberBytes, err := decompressZipMember(zipFile.Method, decrypted[12:])
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(memberBytes) != zipFile.CRC32 {
+		return nil, errZipBadPassword
+	}
+	return memberBytes, nil
+}
+
+// Update the ZipCrypto key state with a plaintext byte
+func zipCryptoUpdateKeys(keys *[3]uint32, c byte) {
+	keys[0] = crc32.IEEETable[byte(keys[0])^c] ^ (keys[0] >> 8)
+	keys[1] = (keys[1]+(keys[0]&0xff))*134775813 + 1
+	keys[2] = crc32.IEEETable[byte(keys[2])^byte(keys[1]>>24)] ^ (keys[2] >> 8)
+}
+
+// Decrypt, authenticate and decompress a WinZip AES encrypted member
+func decryptWinZipAes(zipFile *zip.File, rawBytes []byte, password string) ([]byte, error) {
+	version, strength, method, err := winZipAesExtra(zipFile.Extra)
+	if err != nil {
+		return nil, err
+	}
+	keyLength := 8 * (strength + 1)
+	saltLength := keyLength / 2
+	if len(rawBytes) < saltLength+2+10 {
+		return nil, fmt.Errorf("zip: aes member too short")
+	}
+	salt := rawBytes[:saltLength]
+	verifier := rawBytes[saltLength : saltLength+2]
+	encrypted := rawBytes[saltLength+2 : len(rawBytes)-10]
+	authCode := rawBytes[len(rawBytes)-10:]
+	derived := pbkdf2Sha1([]byte(password), salt, 1000, 2*keyLength+2)
+	if !bytes.Equal(derived[2*keyLength:], verifier) {
+		return nil, errZipBadPassword
+	}
+	mac := hmac.New(sha1.New, derived[keyLength:2*keyLength])
+	mac.Write(encrypted)
+	if !hmac.Equal(mac.Sum(nil)[:10], authCode) {
+		return nil, errZipBadPassword
+	}
+	block, err := aes.NewCipher(derived[:keyLength])
+	if err != nil {
+		return nil, err
+	}
+	// WinZip uses CTR mode with a little endian counter starting at 1
+	decrypted := make([]byte, len(encrypted))
+	counter := make([]byte, aes.BlockSize)
+	keyStream := make([]byte, aes.BlockSize)
+	for i := 0; i < len(encrypted); i += aes.BlockSize {
+		binary.LittleEndian.PutUint64(counter, uint64(i/aes.BlockSize+1))
+		block.Encrypt(keyStream, counter)
+		for j := i; j < i+aes.BlockSize && j < len(encrypted); j++ {
+			decrypted[j] = encrypted[j] ^ keyStream[j-i]
+		}
+	}
+	memberBytes, err := decompressZipMember(method, decrypted)
+	if err != nil {
+		return nil, err
+	}
+	// AE-2 members store a zero CRC, AE-1 members keep the real one
+	if version == 1 && crc32.ChecksumIEEE(memberBytes) != zipFile.CRC32 {
+		return nil, errZipBadPassword
+	}
+	return memberBytes, nil
+}
+
+// Parse the WinZip AES extra field: vendor version, key strength and
+// the compression method applied before encryption
+func winZipAesExtra(extra []byte) (int, int, uint16, error) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+size {
+			break
+		}
+		if id == zipExtraWinZipAes && size >= 7 {
+			data := extra[4 : 4+size]
+			version := int(binary.LittleEndian.Uint16(data[0:2]))
+			strength := int(data[4])
+			if strength < 1 || strength > 3 {
+				return 0, 0, 0, fmt.Errorf("zip: unknown aes strength %d", strength)
+			}
+			return version, strength, binary.LittleEndian.Uint16(data[5:7]), nil
+		}
+		extra = extra[4+size:]
+	}
+	return 0, 0, 0, fmt.Errorf("zip: missing aes extra field")
+}
+
+// Decompress a decrypted member with its compression method
+func decompressZipMember(method uint16, data []byte) ([]byte, error) {
+	switch method {
+	case zip.Store:
+		return data, nil
+	case zip.Deflate:
+		flateReader := flate.NewReader(bytes.NewReader(data))
+		defer flateReader.Close()
+		return ioutil.ReadAll(flateReader)
+	}
+	return nil, fmt.Errorf("zip: unsupported compression method %d", method)
+}
+
+// PBKDF2 key derivation with HMAC-SHA1
+func pbkdf2Sha1(password []byte, salt []byte, iterations int, keyLength int) []byte {
+	prf := hmac.New(sha1.New, password)
+	var derived []byte
+	blockIndex := make([]byte, 4)
+	for block := uint32(1); len(derived) < keyLength; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(blockIndex, block)
+		prf.Write(blockIndex)
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(nil)
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		derived = append(derived, t...)
+	}
+	return derived[:keyLength]
+}